@@ -0,0 +1,223 @@
+// Package audit records the state changing operations of the vote service in a
+// hash chain.
+//
+// Each entry contains the hash of the entry before it. A changed, removed or
+// inserted entry breaks the chain, which is detected by Verify.
+//
+// Ballots are never part of an entry. Single votes are only recorded for named
+// polls. For a stopped poll, the number of votes is recorded.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+// Entry is one operation in the audit log.
+type Entry struct {
+	Seq       int       `json:"seq"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Caller    string    `json:"caller"`
+	PollID    int       `json:"poll_id"`
+	Votes     int       `json:"votes"`
+	Outcome   string    `json:"outcome"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// computeHash returns the hash of the entry. The hash of the entry before is
+// part of it.
+//
+// The fields are encoded as a json array, so a field can not contain the
+// separator.
+func (e Entry) computeHash() string {
+	content, _ := json.Marshal([]any{
+		e.PrevHash,
+		e.Seq,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Operation,
+		e.Caller,
+		e.PollID,
+		e.Votes,
+		e.Outcome,
+	})
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// Chain sets the sequence number and the hashes of an entry, so it follows
+// prev. prev is nil for the first entry.
+//
+// The time is truncated to microseconds, so it can be saved in postgres without
+// changing the hash.
+func Chain(prev *Entry, e Entry) Entry {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+
+	e.Time = e.Time.UTC().Truncate(time.Microsecond)
+	e.Hash = e.computeHash()
+	return e
+}
+
+// Sink saves the entries of the audit log.
+type Sink interface {
+	// Append adds an entry to the end of the chain. It has to set the sequence
+	// number and the hashes with Chain.
+	Append(ctx context.Context, e Entry) error
+
+	// Entries calls fn for each entry in the order of the chain.
+	Entries(ctx context.Context, fn func(Entry) error) error
+}
+
+// Verify checks the chain of all entries in the sink. It returns the number of
+// entries.
+func Verify(ctx context.Context, sink Sink) (int, error) {
+	var prev *Entry
+	var count int
+	err := sink.Entries(ctx, func(e Entry) error {
+		expectSeq := 1
+		expectPrev := ""
+		if prev != nil {
+			expectSeq = prev.Seq + 1
+			expectPrev = prev.Hash
+		}
+
+		if e.Seq != expectSeq {
+			return fmt.Errorf("entry %d: expected sequence number %d", e.Seq, expectSeq)
+		}
+
+		if e.PrevHash != expectPrev {
+			return fmt.Errorf("entry %d: previous hash does not match entry %d", e.Seq, expectSeq-1)
+		}
+
+		if e.computeHash() != e.Hash {
+			return fmt.Errorf("entry %d: content does not match its hash", e.Seq)
+		}
+
+		prev = &e
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// queueSize is the number of entries, that can wait to be written. If the
+// queue is full, Audit waits.
+const queueSize = 1000
+
+// Log records operations in a sink.
+//
+// The entries are written in the background in the order of the calls to
+// Audit, so the caller does not wait for the sink.
+//
+// Has to be created with audit.New().
+type Log struct {
+	sink Sink
+	now  func() time.Time
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan queuedEntry
+	done   chan struct{}
+}
+
+type queuedEntry struct {
+	ctx   context.Context
+	entry Entry
+}
+
+// New creates an audit log, that writes to the sink until Close is called.
+func New(sink Sink) *Log {
+	l := Log{
+		sink:  sink,
+		now:   time.Now,
+		queue: make(chan queuedEntry, queueSize),
+		done:  make(chan struct{}),
+	}
+
+	go l.writeLoop()
+
+	return &l
+}
+
+// writeLoop writes the queued entries until the queue is closed.
+func (l *Log) writeLoop() {
+	defer close(l.done)
+
+	for q := range l.queue {
+		if err := l.sink.Append(q.ctx, q.entry); err != nil {
+			log.ErrorContext(q.ctx, "Writing audit log", "operation", q.entry.Operation, "poll_id", q.entry.PollID, "error", err)
+		}
+	}
+}
+
+// Audit records one operation.
+//
+// The operation is already done, so an error of the sink is only logged.
+func (l *Log) Audit(ctx context.Context, operation string, caller string, pollID int, votes int, err error) {
+	e := Entry{
+		Time:      l.now(),
+		Operation: operation,
+		Caller:    caller,
+		PollID:    pollID,
+		Votes:     votes,
+		Outcome:   Outcome(err),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		log.ErrorContext(ctx, "Audit log is closed", "operation", operation, "poll_id", pollID)
+		return
+	}
+
+	// The entry has to be saved, even if the request was canceled.
+	l.queue <- queuedEntry{ctx: context.WithoutCancel(ctx), entry: e}
+}
+
+// Close writes the queued entries and stops the background writer. Later
+// operations are not recorded.
+func (l *Log) Close() {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	<-l.done
+}
+
+// Outcome returns the outcome of an operation. It is `ok` or the type of the
+// error.
+//
+// The message of the error is not used, since it could contain a ballot.
+func Outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	var errTyped interface {
+		Type() string
+	}
+	if errors.As(err, &errTyped) {
+		return errTyped.Type()
+	}
+	return "internal"
+}
@@ -0,0 +1,189 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/audit"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := audit.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	log := audit.New(sink)
+	log.Audit(ctx, "start", "internal/127.0.0.1", 1, 0, nil)
+	log.Audit(ctx, "stop", "internal/127.0.0.1", 1, 3, nil)
+	log.Audit(ctx, "clear", "internal/127.0.0.1", 2, 0, vote.ErrNotExists)
+	log.Close()
+	sink.Close()
+
+	t.Run("reopen continues the chain", func(t *testing.T) {
+		sink, err := audit.OpenFile(path)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		defer sink.Close()
+
+		log := audit.New(sink)
+		log.Audit(ctx, "clear_all", "internal/127.0.0.1", 0, 0, fmt.Errorf("some error"))
+		log.Close()
+
+		var entries []audit.Entry
+		if err := sink.Entries(ctx, func(e audit.Entry) error { entries = append(entries, e); return nil }); err != nil {
+			t.Fatalf("Entries: %v", err)
+		}
+
+		if len(entries) != 4 {
+			t.Fatalf("got %d entries, expected 4", len(entries))
+		}
+
+		for i, expect := range []struct {
+			operation string
+			pollID    int
+			votes     int
+			outcome   string
+		}{
+			{"start", 1, 0, "ok"},
+			{"stop", 1, 3, "ok"},
+			{"clear", 2, 0, "not-exist"},
+			{"clear_all", 0, 0, "internal"},
+		} {
+			got := entries[i]
+			if got.Seq != i+1 || got.Operation != expect.operation || got.Caller != "internal/127.0.0.1" || got.PollID != expect.pollID || got.Votes != expect.votes || got.Outcome != expect.outcome {
+				t.Errorf("entry %d: got %+v", i+1, got)
+			}
+		}
+
+		count, err := audit.Verify(ctx, sink)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+
+		if count != 4 {
+			t.Errorf("Verify returned %d, expected 4", count)
+		}
+	})
+}
+
+func TestVerifyTampered(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name   string
+		change func(lines []string) []string
+	}{
+		{
+			"changed entry",
+			func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"votes":3`, `"votes":4`, 1)
+				return lines
+			},
+		},
+		{
+			"removed entry",
+			func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+		},
+		{
+			"removed last entry and changed the one before",
+			func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"ok"`, `"not-allowed"`, 1)
+				return lines[:2]
+			},
+		},
+		{
+			"swapped entries",
+			func(lines []string) []string {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			sink, err := audit.OpenFile(path)
+			if err != nil {
+				t.Fatalf("OpenFile: %v", err)
+			}
+			defer sink.Close()
+
+			log := audit.New(sink)
+			log.Audit(ctx, "start", "internal/127.0.0.1", 1, 0, nil)
+			log.Audit(ctx, "stop", "internal/127.0.0.1", 1, 3, nil)
+			log.Audit(ctx, "clear", "internal/127.0.0.1", 1, 0, nil)
+			log.Close()
+
+			if _, err := audit.Verify(ctx, sink); err != nil {
+				t.Fatalf("Verify before change: %v", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading file: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			lines = tt.change(lines)
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatalf("writing file: %v", err)
+			}
+
+			if _, err := audit.Verify(ctx, sink); err == nil {
+				t.Errorf("Verify returned no error")
+			}
+		})
+	}
+}
+
+func TestLogClosed(t *testing.T) {
+	ctx := context.Background()
+	sink, err := audit.OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer sink.Close()
+
+	log := audit.New(sink)
+	log.Audit(ctx, "start", "internal/127.0.0.1", 1, 0, nil)
+	log.Close()
+	log.Audit(ctx, "stop", "internal/127.0.0.1", 1, 0, nil)
+	log.Close()
+
+	count, err := audit.Verify(ctx, sink)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Verify returned %d, expected only the entry before Close", count)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		expect string
+	}{
+		{nil, "ok"},
+		{vote.ErrNotExists, "not-exist"},
+		{fmt.Errorf("wrapped: %w", vote.MessageError(vote.ErrInvalid, "ballot: secret")), "invalid"},
+		{errors.New("ballot: secret"), "internal"},
+	} {
+		if got := audit.Outcome(tt.err); got != tt.expect {
+			t.Errorf("Outcome(%v) == %q, expected %q", tt.err, got, tt.expect)
+		}
+	}
+}
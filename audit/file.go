@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink saves the entries as json lines in a file.
+//
+// The file can only be used by one instance of the service.
+//
+// Has to be created with audit.OpenFile().
+type FileSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	last *Entry
+}
+
+// OpenFile opens or creates the file. It reads the last entry, so new entries
+// continue the chain.
+func OpenFile(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+
+	s := FileSink{
+		path: path,
+		file: file,
+	}
+
+	var last Entry
+	var found bool
+	if err := readEntries(file, func(e Entry) error { last, found = e, true; return nil }); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if found {
+		s.last = &last
+	}
+
+	return &s, nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// Append adds an entry at the end of the file.
+func (s *FileSink) Append(ctx context.Context, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e = Chain(s.last, e)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding entry: %w", err)
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing entry: %w", err)
+	}
+
+	s.last = &e
+	return nil
+}
+
+// Entries calls fn for each entry in the file.
+func (s *FileSink) Entries(ctx context.Context, fn func(Entry) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer file.Close()
+
+	return readEntries(file, fn)
+}
+
+func readEntries(r io.Reader, fn func(Entry) error) error {
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading audit log: %w", err)
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("line %d of audit log: %w", lineNumber, err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/audit"
+	"github.com/OpenSlides/openslides-vote-service/backend/encrypted"
+	"github.com/OpenSlides/openslides-vote-service/backend/instrumented"
 	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/backend/mirror"
 	"github.com/OpenSlides/openslides-vote-service/backend/postgres"
 	"github.com/OpenSlides/openslides-vote-service/backend/redis"
+	"github.com/OpenSlides/openslides-vote-service/backend/wal"
 	"github.com/OpenSlides/openslides-vote-service/vote"
 )
 
@@ -17,6 +23,14 @@ var (
 	envRedisHost = environment.NewVariable("CACHE_HOST", "localhost", "Host of the redis used for the fast backend.")
 	envRedisPort = environment.NewVariable("CACHE_PORT", "6379", "Port of the redis used for the fast backend.")
 
+	envRedisMode                 = environment.NewVariable("CACHE_MODE", "standalone", "How to connect to redis. One of `standalone`, `sentinel` or `cluster`.")
+	envRedisNodes                = environment.NewVariable("CACHE_NODES", "", "Comma separated list of `host:port` of the sentinels or cluster nodes. If empty, CACHE_HOST and CACHE_PORT are used.")
+	envRedisSentinelMaster       = environment.NewVariable("CACHE_SENTINEL_MASTER", "mymaster", "Name of the master monitored by the sentinels.")
+	envRedisUsername             = environment.NewVariable("CACHE_USERNAME", "", "ACL username for redis. Only used with CACHE_PASSWORD_FILE.")
+	envRedisPasswordFile         = environment.NewVariable("CACHE_PASSWORD_FILE", "", "File with the password for redis. If empty, no authentication is used.")
+	envRedisSentinelPasswordFile = environment.NewVariable("CACHE_SENTINEL_PASSWORD_FILE", "", "File with the password for the sentinels. If empty, no authentication is used.")
+	envRedisKeyPrefix            = environment.NewVariable("CACHE_KEY_PREFIX", "", "Prefix for all redis keys of the vote service. Needed if more then one OpenSlides instance uses the same redis.")
+
 	envPostgresHost         = environment.NewVariable("VOTE_DATABASE_HOST", "localhost", "Host of the postgres database used for long polls.")
 	envPostgresPort         = environment.NewVariable("VOTE_DATABASE_PORT", "5432", "Port of the postgres database used for long polls.")
 	envPostgresUser         = environment.NewVariable("VOTE_DATABASE_USER", "openslides", "Databasename of the postgres database used for long polls.")
@@ -24,8 +38,20 @@ var (
 	envPostgresPasswordFile = environment.NewVariable("VOTE_DATABASE_PASSWORD_FILE", "/run/secrets/postgres_password", "Password of the postgres database used for long polls.")
 
 	envSingleInstance = environment.NewVariable("VOTE_SINGLE_INSTANCE", "false", "More performance if the serice is not scalled horizontally.")
+	envWALDir         = environment.NewVariable("VOTE_WAL_DIR", "", "Directory to persist fast polls with VOTE_SINGLE_INSTANCE. If empty, fast polls are only held in memory.")
+	envMirror         = environment.NewVariable("VOTE_MIRROR", "none", "Mirror the fast polls from redis to postgres. One of `none`, `sync` or `async`.")
+
+	envEncryptionKeyFile = environment.NewVariable("VOTE_ENCRYPTION_KEY_FILE", "", "File with the keys to encrypt the ballots in the backends. One `id:base64-key` per line, the first key is used for new ballots. If empty, the ballots are not encrypted.")
+
+	envAuditLog  = environment.NewVariable("VOTE_AUDIT_LOG", "none", "Where to save the audit log of the poll operations. One of `none`, `file` or `postgres`.")
+	envAuditFile = environment.NewVariable("VOTE_AUDIT_FILE", "/var/lib/vote/audit.log", "File of the audit log. Only used with VOTE_AUDIT_LOG=file.")
 )
 
+// mirrorSchema is the postgres schema of the fast polls with VOTE_MIRROR. They
+// are not saved in the schema of the long polls, so the polls and the
+// notifications of both backends are independent.
+const mirrorSchema = "vote_mirror"
+
 // Build builds a fast and a long backends from the environment.
 func Build(lookup environment.Environmenter) (fast, long func(context.Context) (vote.Backend, error), singleInstance bool, err error) {
 	// All environment variables have to be called in this function and not in a
@@ -36,9 +62,17 @@ func Build(lookup environment.Environmenter) (fast, long func(context.Context) (
 		return memory.New(), nil
 	}
 
-	redisAddr := envRedisHost.Value(lookup) + ":" + envRedisPort.Value(lookup)
+	redisCfg, err := redisConfig(lookup)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("init redis: %w", err)
+	}
+
 	buildRedis := func(ctx context.Context) (vote.Backend, error) {
-		r := redis.New(redisAddr)
+		r, err := redis.NewWithConfig(redisCfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating redis backend: %w", err)
+		}
+
 		r.Wait(ctx)
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
@@ -47,41 +81,252 @@ func Build(lookup environment.Environmenter) (fast, long func(context.Context) (
 		return r, nil
 	}
 
-	dbPassword, err := environment.ReadSecret(lookup, envPostgresPasswordFile)
+	connectPostgres, err := BuildPostgres(lookup)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("reading postgres password: %w", err)
+		return nil, nil, false, fmt.Errorf("init postgres: %w", err)
 	}
 
-	postgresAddr := fmt.Sprintf(
-		`user='%s' password='%s' host='%s' port='%s' dbname='%s'`,
-		encodePostgresConfig(envPostgresUser.Value(lookup)),
-		dbPassword,
-		encodePostgresConfig(envPostgresHost.Value(lookup)),
-		encodePostgresConfig(envPostgresPort.Value(lookup)),
-		encodePostgresConfig(envPostgresDatabase.Value(lookup)),
+	// The long backend and the mirror of the fast backend share one
+	// connection pool.
+	var (
+		postgresOnce sync.Once
+		postgresConn *postgres.Backend
+		postgresErr  error
 	)
+	sharedPostgres := func(ctx context.Context) (*postgres.Backend, error) {
+		postgresOnce.Do(func() {
+			postgresConn, postgresErr = connectPostgres(ctx)
+		})
+		return postgresConn, postgresErr
+	}
 
 	buildPostgres := func(ctx context.Context) (vote.Backend, error) {
-		p, err := postgres.New(ctx, postgresAddr)
+		p, err := sharedPostgres(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("creating postgres connection pool: %w", err)
+			return nil, err
 		}
 
-		p.Wait(ctx)
 		if err := p.Migrate(ctx); err != nil {
-			return nil, fmt.Errorf("creating shema: %w", err)
+			return nil, fmt.Errorf("migrating schema: %w", err)
 		}
 		return p, nil
 	}
 
+	buildPostgresMirror := func(ctx context.Context) (vote.Backend, error) {
+		p, err := sharedPostgres(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := p.WithSchema(mirrorSchema)
+		if err != nil {
+			return nil, fmt.Errorf("creating mirror backend: %w", err)
+		}
+
+		if err := m.Migrate(ctx); err != nil {
+			return nil, fmt.Errorf("migrating mirror schema: %w", err)
+		}
+		return m, nil
+	}
+
 	long = buildPostgres
 	fast = buildRedis
 	singleInstace, _ := strconv.ParseBool(envSingleInstance.Value(lookup))
+
+	walDir := envWALDir.Value(lookup)
+	buildWAL := func(_ context.Context) (vote.Backend, error) {
+		w, err := wal.New(walDir)
+		if err != nil {
+			return nil, fmt.Errorf("opening write-ahead log: %w", err)
+		}
+		return w, nil
+	}
+
 	if singleInstace {
 		fast = buildMemory
+		if walDir != "" {
+			fast = buildWAL
+		}
+	}
+
+	mirrorMode := envMirror.Value(lookup)
+	switch mirrorMode {
+	case "none":
+	case "sync", "async":
+		if singleInstace {
+			return nil, nil, false, fmt.Errorf("VOTE_MIRROR can not be used with VOTE_SINGLE_INSTANCE")
+		}
+
+		fast = func(ctx context.Context) (vote.Backend, error) {
+			primary, err := buildRedis(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			secondary, err := buildPostgresMirror(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("start mirror: %w", err)
+			}
+
+			return mirror.New(ctx, primary, secondary, mirrorMode == "async"), nil
+		}
+	default:
+		return nil, nil, false, fmt.Errorf("invalid value for VOTE_MIRROR: %s", mirrorMode)
+	}
+
+	if envEncryptionKeyFile.Value(lookup) != "" {
+		content, err := environment.ReadSecret(lookup, envEncryptionKeyFile)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("reading encryption keys: %w", err)
+		}
+
+		keys, err := encrypted.ParseKeys(content)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("parsing encryption keys: %w", err)
+		}
+
+		fast = encrypt(fast, keys)
+		long = encrypt(long, keys)
+	}
+
+	return instrument(fast), instrument(long), singleInstace, nil
+}
+
+// encrypt wraps the backend from build, so the ballots are encrypted.
+func encrypt(build func(context.Context) (vote.Backend, error), keys []encrypted.Key) func(context.Context) (vote.Backend, error) {
+	return func(ctx context.Context) (vote.Backend, error) {
+		backend, err := build(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return encrypted.New(backend, keys)
+	}
+}
+
+// instrument wraps the backend from build, so its calls are recorded as
+// metrics.
+func instrument(build func(context.Context) (vote.Backend, error)) func(context.Context) (vote.Backend, error) {
+	return func(ctx context.Context) (vote.Backend, error) {
+		backend, err := build(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return instrumented.New(backend), nil
+	}
+}
+
+// BuildAudit returns a function, that opens the sink of the audit log from the
+// environment. The second return value of the function closes the sink.
+//
+// Returns nil, if the audit log is disabled.
+func BuildAudit(lookup environment.Environmenter) (func(context.Context) (audit.Sink, func(), error), error) {
+	mode := envAuditLog.Value(lookup)
+	auditFile := envAuditFile.Value(lookup)
+
+	switch mode {
+	case "none":
+		return nil, nil
+
+	case "file":
+		return func(_ context.Context) (audit.Sink, func(), error) {
+			sink, err := audit.OpenFile(auditFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("opening audit file: %w", err)
+			}
+			return sink, func() { sink.Close() }, nil
+		}, nil
+
+	case "postgres":
+		connectPostgres, err := BuildPostgres(lookup)
+		if err != nil {
+			return nil, fmt.Errorf("init postgres: %w", err)
+		}
+
+		return func(ctx context.Context) (audit.Sink, func(), error) {
+			p, err := connectPostgres(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if err := p.Migrate(ctx); err != nil {
+				p.Close()
+				return nil, nil, fmt.Errorf("migrating schema: %w", err)
+			}
+			return p.AuditSink(), func() { p.Close() }, nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid value for %s: %s", envAuditLog.Key, mode)
+	}
+}
+
+// redisConfig reads the connection to redis from the environment.
+func redisConfig(lookup environment.Environmenter) (redis.Config, error) {
+	cfg := redis.Config{
+		Addrs:      []string{envRedisHost.Value(lookup) + ":" + envRedisPort.Value(lookup)},
+		Mode:       redis.Mode(envRedisMode.Value(lookup)),
+		MasterName: envRedisSentinelMaster.Value(lookup),
+		Username:   envRedisUsername.Value(lookup),
+		KeyPrefix:  envRedisKeyPrefix.Value(lookup),
+	}
+
+	if nodes := envRedisNodes.Value(lookup); nodes != "" {
+		cfg.Addrs = strings.Split(nodes, ",")
+	}
+
+	if envRedisPasswordFile.Value(lookup) != "" {
+		password, err := environment.ReadSecret(lookup, envRedisPasswordFile)
+		if err != nil {
+			return redis.Config{}, fmt.Errorf("reading redis password: %w", err)
+		}
+		cfg.Password = password
 	}
 
-	return fast, long, singleInstace, nil
+	if envRedisSentinelPasswordFile.Value(lookup) != "" {
+		password, err := environment.ReadSecret(lookup, envRedisSentinelPasswordFile)
+		if err != nil {
+			return redis.Config{}, fmt.Errorf("reading redis sentinel password: %w", err)
+		}
+		cfg.SentinelPassword = password
+	}
+
+	switch cfg.Mode {
+	case redis.ModeStandalone, redis.ModeSentinel, redis.ModeCluster:
+	default:
+		return redis.Config{}, fmt.Errorf("invalid value for CACHE_MODE: %s", cfg.Mode)
+	}
+
+	return cfg, nil
+}
+
+// BuildPostgres returns a function, that connects to the postgres database
+// from the environment. It does not migrate the database.
+func BuildPostgres(lookup environment.Environmenter) (func(context.Context) (*postgres.Backend, error), error) {
+	dbPassword, err := environment.ReadSecret(lookup, envPostgresPasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading postgres password: %w", err)
+	}
+
+	postgresAddr := fmt.Sprintf(
+		`user='%s' password='%s' host='%s' port='%s' dbname='%s'`,
+		encodePostgresConfig(envPostgresUser.Value(lookup)),
+		dbPassword,
+		encodePostgresConfig(envPostgresHost.Value(lookup)),
+		encodePostgresConfig(envPostgresPort.Value(lookup)),
+		encodePostgresConfig(envPostgresDatabase.Value(lookup)),
+	)
+
+	return func(ctx context.Context) (*postgres.Backend, error) {
+		p, err := postgres.New(ctx, postgresAddr)
+		if err != nil {
+			return nil, fmt.Errorf("creating postgres connection pool: %w", err)
+		}
+
+		p.Wait(ctx)
+		return p, nil
+	}, nil
 }
 
 // encodePostgresConfig encodes a string to be used in the postgres key value style.
@@ -0,0 +1,319 @@
+// Package encrypted implements a vote.Backend, that encrypts the ballots before
+// they are saved in another backend.
+//
+// Each ballot is sealed with AES-256-GCM. The id of the poll is used as
+// associated data, so a ballot can not be moved to another poll. Each sealed
+// ballot starts with the id of its key, so the keys can be rotated. New
+// ballots are sealed with the first key. The other keys are only used to open
+// older ballots.
+package encrypted
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+// formatVersion is the first byte of a sealed ballot.
+const formatVersion = 1
+
+// Key is an AES-256 key with an id.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses the content of a key file.
+//
+// Each line has the form `id:base64-key`. The key has to be 32 bytes long.
+// Empty lines and lines starting with `#` are ignored. The first key is used to
+// seal new ballots.
+func ParseKeys(content string) ([]Key, error) {
+	var keys []Key
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(line, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("line %d: expected `id:base64-key`", i+1)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: decoding key %s: %w", i+1, id, err)
+		}
+
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// Backend encrypts the ballots of another backend.
+//
+// Has to be created with encrypted.New().
+type Backend struct {
+	backend vote.Backend
+
+	currentID string
+	aeads     map[string]cipher.AEAD
+}
+
+// New creates a backend, that encrypts the ballots before they are saved in the
+// given backend.
+//
+// The returned backend only implements vote.ChangeListener, if the given
+// backend implements it.
+func New(backend vote.Backend, keys []Key) (vote.Backend, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key")
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for _, key := range keys {
+		if len(key.ID) > 255 {
+			return nil, fmt.Errorf("key id %s is too long", key.ID)
+		}
+
+		if len(key.Secret) != 32 {
+			return nil, fmt.Errorf("key %s has %d bytes, expected 32", key.ID, len(key.Secret))
+		}
+
+		if _, exists := aeads[key.ID]; exists {
+			return nil, fmt.Errorf("key id %s is used twice", key.ID)
+		}
+
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("creating cipher for key %s: %w", key.ID, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("creating gcm for key %s: %w", key.ID, err)
+		}
+		aeads[key.ID] = aead
+	}
+
+	b := &Backend{
+		backend:   backend,
+		currentID: keys[0].ID,
+		aeads:     aeads,
+	}
+
+	if _, ok := backend.(vote.ChangeListener); ok {
+		return listenerBackend{b}, nil
+	}
+	return b, nil
+}
+
+func (b *Backend) String() string {
+	return fmt.Sprintf("encrypted(%s)", b.backend)
+}
+
+// Unwrap returns the backend, that saves the encrypted ballots.
+func (b *Backend) Unwrap() vote.Backend {
+	return b.backend
+}
+
+// seal encrypts a ballot with the current key.
+//
+// The format is: version, length of the key id, key id, nonce, ciphertext.
+func (b *Backend) seal(pollID int, ballot []byte) ([]byte, error) {
+	aead := b.aeads[b.currentID]
+
+	header := make([]byte, 0, 2+len(b.currentID)+aead.NonceSize())
+	header = append(header, formatVersion, byte(len(b.currentID)))
+	header = append(header, b.currentID...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("creating nonce: %w", err)
+	}
+
+	return aead.Seal(append(header, nonce...), nonce, ballot, associatedData(pollID)), nil
+}
+
+// open decrypts a sealed ballot.
+func (b *Backend) open(pollID int, sealed []byte) ([]byte, error) {
+	if len(sealed) < 2 || sealed[0] != formatVersion {
+		return nil, errors.New("unknown format")
+	}
+
+	idEnd := 2 + int(sealed[1])
+	if len(sealed) < idEnd {
+		return nil, errors.New("ballot is too short")
+	}
+
+	keyID := string(sealed[2:idEnd])
+	aead, ok := b.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+
+	nonceEnd := idEnd + aead.NonceSize()
+	if len(sealed) < nonceEnd {
+		return nil, errors.New("ballot is too short")
+	}
+
+	ballot, err := aead.Open(nil, sealed[idEnd:nonceEnd], sealed[nonceEnd:], associatedData(pollID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with key %s: %w", keyID, err)
+	}
+	return ballot, nil
+}
+
+func associatedData(pollID int) []byte {
+	return []byte(strconv.Itoa(pollID))
+}
+
+// Start starts the poll.
+func (b *Backend) Start(ctx context.Context, pollID int) error {
+	return b.backend.Start(ctx, pollID)
+}
+
+// StartNamed starts a named poll.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) error {
+	if namedBackend, ok := b.backend.(vote.NamedBackend); ok {
+		return namedBackend.StartNamed(ctx, pollID)
+	}
+	return b.backend.Start(ctx, pollID)
+}
+
+// Vote encrypts the ballot and saves it.
+func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byte) error {
+	sealed, err := b.seal(pollID, object)
+	if err != nil {
+		return fmt.Errorf("encrypting ballot: %w", err)
+	}
+	return b.backend.Vote(ctx, pollID, userID, sealed)
+}
+
+// VoteIdempotent encrypts the ballot and saves it with the idempotency key.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) error {
+	sealed, err := b.seal(pollID, object)
+	if err != nil {
+		return fmt.Errorf("encrypting ballot: %w", err)
+	}
+
+	if idempotentBackend, ok := b.backend.(vote.IdempotentBackend); ok {
+		return idempotentBackend.VoteIdempotent(ctx, pollID, userID, sealed, key)
+	}
+	return b.backend.Vote(ctx, pollID, userID, sealed)
+}
+
+// SetMoved marks the poll as moved in the wrapped backend. If the wrapped
+// backend does not implement vote.MoveBackend, the mark is not saved.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	if moveBackend, ok := b.backend.(vote.MoveBackend); ok {
+		return moveBackend.SetMoved(ctx, pollID, backend)
+	}
+	return nil
+}
+
+// Moved returns the marked polls of the wrapped backend.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	if moveBackend, ok := b.backend.(vote.MoveBackend); ok {
+		return moveBackend.Moved(ctx)
+	}
+	return nil, nil
+}
+
+// Stop stops the poll and returns the decrypted ballots.
+func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error) {
+	sealed, userIDs, err := b.backend.Stop(ctx, pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ballots := make([][]byte, len(sealed))
+	for i := range sealed {
+		ballots[i], err = b.open(pollID, sealed[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening ballot of poll %d: %w", pollID, err)
+		}
+	}
+
+	return ballots, userIDs, nil
+}
+
+// Clear removes the poll.
+func (b *Backend) Clear(ctx context.Context, pollID int) error {
+	return b.backend.Clear(ctx, pollID)
+}
+
+// ClearAll removes all polls.
+func (b *Backend) ClearAll(ctx context.Context) error {
+	return b.backend.ClearAll(ctx)
+}
+
+// LiveVotes returns the decrypted live votes.
+func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
+	liveVotes, err := b.backend.LiveVotes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for pollID, userID2Vote := range liveVotes {
+		for userID, sealed := range userID2Vote {
+			if sealed == nil {
+				continue
+			}
+
+			ballot, err := b.open(pollID, sealed)
+			if err != nil {
+				return nil, fmt.Errorf("opening ballot of user %d in poll %d: %w", userID, pollID, err)
+			}
+			userID2Vote[userID] = ballot
+		}
+	}
+
+	return liveVotes, nil
+}
+
+// listenerBackend is a Backend, whose wrapped backend implements
+// vote.ChangeListener.
+type listenerBackend struct {
+	*Backend
+}
+
+// ListenChanges listens for the changes of the backend and decrypts the
+// ballots. A change with a ballot, that can not be decrypted, is dropped and
+// ready is called, so the caller loads the data again.
+func (b listenerBackend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	return b.backend.(vote.ChangeListener).ListenChanges(ctx, ready, func(c vote.BackendChange) {
+		if c.Vote != nil {
+			ballot, err := b.open(c.PollID, c.Vote)
+			if err != nil {
+				// A change without a vote would look like a removed vote.
+				// Drop it and let the caller load the live votes again.
+				log.Error("opening ballot of user %d in poll %d from change: %v", c.UserID, c.PollID, err)
+				ready()
+				return
+			}
+			c.Vote = ballot
+		}
+		change(c)
+	})
+}
+
+// Close closes the wrapped backend.
+func (b *Backend) Close() error {
+	return vote.Close(b.backend)
+}
+
+// Ping checks, if the wrapped backend is reachable.
+func (b *Backend) Ping(ctx context.Context) error {
+	return vote.Ping(ctx, b.backend)
+}
@@ -0,0 +1,212 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/backend/encrypted"
+	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+var (
+	keyA = encrypted.Key{ID: "a", Secret: bytes.Repeat([]byte{1}, 32)}
+	keyB = encrypted.Key{ID: "b", Secret: bytes.Repeat([]byte{2}, 32)}
+)
+
+func newBackend(t *testing.T, keys ...encrypted.Key) (vote.Backend, *memory.Backend) {
+	t.Helper()
+
+	inner := memory.New()
+	b, err := encrypted.New(inner, keys)
+	if err != nil {
+		t.Fatalf("encrypted.New: %v", err)
+	}
+	return b, inner
+}
+
+func TestBackend(t *testing.T) {
+	b, _ := newBackend(t, keyA)
+	test.Backend(t, b)
+}
+
+func TestBallotIsEncrypted(t *testing.T) {
+	ctx := context.Background()
+	b, inner := newBackend(t, keyA)
+
+	b.(vote.NamedBackend).StartNamed(ctx, 1)
+	if err := b.Vote(ctx, 1, 5, []byte(`"secret-ballot"`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+
+	saved, _ := inner.LiveVotes(ctx)
+	if bytes.Contains(saved[1][5], []byte("secret-ballot")) {
+		t.Errorf("The inner backend has the ballot as plaintext: %q", saved[1][5])
+	}
+
+	liveVotes, err := b.LiveVotes(ctx)
+	if err != nil {
+		t.Fatalf("LiveVotes: %v", err)
+	}
+
+	if got := string(liveVotes[1][5]); got != `"secret-ballot"` {
+		t.Errorf("LiveVotes returned %s, expected the ballot", got)
+	}
+
+	objects, _, err := b.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if len(objects) != 1 || string(objects[0]) != `"secret-ballot"` {
+		t.Errorf("Stop returned %q, expected the ballot", objects)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	old, inner := newBackend(t, keyA)
+
+	old.Start(ctx, 1)
+	old.Vote(ctx, 1, 5, []byte(`"Y"`))
+
+	rotated, err := encrypted.New(inner, []encrypted.Key{keyB, keyA})
+	if err != nil {
+		t.Fatalf("encrypted.New: %v", err)
+	}
+	rotated.Vote(ctx, 1, 6, []byte(`"N"`))
+
+	objects, _, err := rotated.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if len(objects) != 2 {
+		t.Errorf("Stop returned %q, expected two ballots", objects)
+	}
+
+	withoutOldKey, _ := encrypted.New(inner, []encrypted.Key{keyB})
+	if _, _, err := withoutOldKey.Stop(ctx, 1); err == nil {
+		t.Errorf("Stop without the old key did not return an error")
+	}
+}
+
+func TestBallotFromOtherPoll(t *testing.T) {
+	ctx := context.Background()
+	b, inner := newBackend(t, keyA)
+
+	b.(vote.NamedBackend).StartNamed(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+
+	saved, _ := inner.LiveVotes(ctx)
+
+	// Someone with access to the database copies the ballot to another poll.
+	inner.Start(ctx, 2)
+	inner.Vote(ctx, 2, 5, saved[1][5])
+
+	if _, _, err := b.Stop(ctx, 2); err == nil {
+		t.Errorf("Stop returned a ballot from another poll")
+	}
+}
+
+// listenerBackend is a memory backend, that sends the given changes.
+type listenerBackend struct {
+	*memory.Backend
+	changes []vote.BackendChange
+}
+
+func (b *listenerBackend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	ready()
+	for _, c := range b.changes {
+		change(c)
+	}
+	return nil
+}
+
+func TestListenChangesUndecryptable(t *testing.T) {
+	ctx := context.Background()
+	inner := &listenerBackend{Backend: memory.New()}
+	b, err := encrypted.New(inner, []encrypted.Key{keyA})
+	if err != nil {
+		t.Fatalf("encrypted.New: %v", err)
+	}
+
+	listener, ok := b.(vote.ChangeListener)
+	if !ok {
+		t.Fatalf("Backend does not implement vote.ChangeListener")
+	}
+
+	inner.Start(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+	saved, _ := inner.LiveVotes(ctx)
+
+	inner.changes = []vote.BackendChange{
+		{Type: vote.ChangeVote, PollID: 1, UserID: 5, Vote: saved[1][5]},
+		{Type: vote.ChangeVote, PollID: 1, UserID: 6, Vote: []byte("not encrypted")},
+	}
+
+	var readyCalls int
+	var got []vote.BackendChange
+	listener.ListenChanges(ctx, func() { readyCalls++ }, func(c vote.BackendChange) { got = append(got, c) })
+
+	if len(got) != 1 || got[0].UserID != 5 || string(got[0].Vote) != `"Y"` {
+		t.Errorf("Got changes %v, expected only the decrypted vote of user 5", got)
+	}
+
+	if readyCalls != 2 {
+		t.Errorf("ready was called %d times, expected 2", readyCalls)
+	}
+}
+
+func TestChangeListenerOnlyWithInnerListener(t *testing.T) {
+	b, _ := newBackend(t, keyA)
+	if _, ok := b.(vote.ChangeListener); ok {
+		t.Errorf("Backend implements vote.ChangeListener, but the memory backend does not")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		keys []encrypted.Key
+	}{
+		{"no key", nil},
+		{"short key", []encrypted.Key{{ID: "a", Secret: []byte("short")}}},
+		{"same id", []encrypted.Key{keyA, keyA}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encrypted.New(memory.New(), tt.keys); err == nil {
+				t.Errorf("New did not return an error")
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	content := strings.Join([]string{
+		"# current key",
+		"2026:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=",
+		"",
+		"2025:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=",
+	}, "\n")
+
+	keys, err := encrypted.ParseKeys(content)
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != "2026" || keys[1].ID != "2025" {
+		t.Fatalf("Got keys %v, expected 2026 and 2025", keys)
+	}
+
+	if !bytes.Equal(keys[1].Secret, bytes.Repeat([]byte{2}, 32)) {
+		t.Errorf("Got secret %v, expected 32 times 2", keys[1].Secret)
+	}
+
+	if _, err := encrypted.ParseKeys("missing-colon"); err == nil {
+		t.Errorf("ParseKeys with an invalid line did not return an error")
+	}
+}
@@ -0,0 +1,209 @@
+// Package instrumented implements a vote.Backend, that records the latency and
+// the results of the calls to another backend as metrics, creates a span for
+// each call and writes a debug log.
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+	"github.com/OpenSlides/openslides-vote-service/tracing"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	metricDuration = metrics.NewHistogramVec(
+		"vote_backend_duration_seconds",
+		"Duration of the calls to a backend.",
+		metrics.DefaultBuckets,
+		"backend", "operation",
+	)
+
+	metricCalls = metrics.NewCounterVec(
+		"vote_backend_calls_total",
+		"Number of calls to a backend by result. The result is one of ok, not_exist, stopped, double_vote or error.",
+		"backend", "operation", "result",
+	)
+)
+
+// Backend records metrics for another backend.
+//
+// Has to be created with instrumented.New().
+type Backend struct {
+	backend vote.Backend
+	name    string
+}
+
+// New creates a backend, that records metrics for the given backend.
+//
+// The returned backend only implements vote.ChangeListener, if the given
+// backend implements it.
+func New(backend vote.Backend) vote.Backend {
+	b := &Backend{
+		backend: backend,
+		name:    backend.String(),
+	}
+
+	if _, ok := backend.(vote.ChangeListener); ok {
+		return listenerBackend{b}
+	}
+	return b
+}
+
+// String returns the name of the wrapped backend.
+func (b *Backend) String() string {
+	return b.name
+}
+
+// Unwrap returns the wrapped backend.
+func (b *Backend) Unwrap() vote.Backend {
+	return b.backend
+}
+
+// record starts the recording of one call. It returns the context for the call
+// and a function, that saves the duration and the result.
+func (b *Backend) record(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+	attributes = append(attributes, attribute.String("vote.backend", b.name))
+	ctx, span := tracing.Start(ctx, "backend."+operation, attributes...)
+
+	return ctx, func(err error) {
+		duration := time.Since(start)
+		metricDuration.Observe(duration.Seconds(), b.name, operation)
+		metricCalls.Inc(b.name, operation, result(err))
+		tracing.End(span, err)
+		log.DebugContext(ctx, "Backend call", "backend", b.name, "operation", operation, "result", result(err), "duration", duration)
+	}
+}
+
+// result returns the label for the result of a call.
+func result(err error) string {
+	var errDoesNotExist interface{ DoesNotExist() }
+	var errStopped interface{ Stopped() }
+	var errDoubleVote interface{ DoubleVote() }
+
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &errDoesNotExist):
+		return "not_exist"
+	case errors.As(err, &errStopped):
+		return "stopped"
+	case errors.As(err, &errDoubleVote):
+		return "double_vote"
+	default:
+		return "error"
+	}
+}
+
+// Start starts the poll.
+func (b *Backend) Start(ctx context.Context, pollID int) (err error) {
+	ctx, done := b.record(ctx, "Start", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+	return b.backend.Start(ctx, pollID)
+}
+
+// StartNamed starts a named poll. It is recorded as Start.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) (err error) {
+	ctx, done := b.record(ctx, "Start", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+
+	if namedBackend, ok := b.backend.(vote.NamedBackend); ok {
+		return namedBackend.StartNamed(ctx, pollID)
+	}
+	return b.backend.Start(ctx, pollID)
+}
+
+// Vote saves a vote.
+func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byte) (err error) {
+	ctx, done := b.record(ctx, "Vote", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+	return b.backend.Vote(ctx, pollID, userID, object)
+}
+
+// VoteIdempotent saves a vote with an idempotency key. It is recorded as Vote.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) (err error) {
+	ctx, done := b.record(ctx, "Vote", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+
+	if idempotentBackend, ok := b.backend.(vote.IdempotentBackend); ok {
+		return idempotentBackend.VoteIdempotent(ctx, pollID, userID, object, key)
+	}
+	return b.backend.Vote(ctx, pollID, userID, object)
+}
+
+// Stop stops the poll.
+func (b *Backend) Stop(ctx context.Context, pollID int) (objects [][]byte, userIDs []int, err error) {
+	ctx, done := b.record(ctx, "Stop", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+	return b.backend.Stop(ctx, pollID)
+}
+
+// Clear removes the poll.
+func (b *Backend) Clear(ctx context.Context, pollID int) (err error) {
+	ctx, done := b.record(ctx, "Clear", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+	return b.backend.Clear(ctx, pollID)
+}
+
+// ClearAll removes all polls.
+func (b *Backend) ClearAll(ctx context.Context) (err error) {
+	ctx, done := b.record(ctx, "ClearAll")
+	defer func() { done(err) }()
+	return b.backend.ClearAll(ctx)
+}
+
+// SetMoved marks the poll as moved. If the wrapped backend does not implement
+// vote.MoveBackend, the mark is not saved.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) (err error) {
+	ctx, done := b.record(ctx, "SetMoved", attribute.Int("vote.poll_id", pollID))
+	defer func() { done(err) }()
+
+	if moveBackend, ok := b.backend.(vote.MoveBackend); ok {
+		return moveBackend.SetMoved(ctx, pollID, backend)
+	}
+	return nil
+}
+
+// Moved returns the marked polls.
+func (b *Backend) Moved(ctx context.Context) (moved map[int]string, err error) {
+	ctx, done := b.record(ctx, "Moved")
+	defer func() { done(err) }()
+
+	if moveBackend, ok := b.backend.(vote.MoveBackend); ok {
+		return moveBackend.Moved(ctx)
+	}
+	return nil, nil
+}
+
+// LiveVotes returns the live votes.
+func (b *Backend) LiveVotes(ctx context.Context) (liveVotes map[int]map[int][]byte, err error) {
+	ctx, done := b.record(ctx, "LiveVotes")
+	defer func() { done(err) }()
+	return b.backend.LiveVotes(ctx)
+}
+
+// listenerBackend is a Backend, whose wrapped backend implements
+// vote.ChangeListener.
+type listenerBackend struct {
+	*Backend
+}
+
+// ListenChanges listens for the changes of the backend. It is not recorded.
+func (b listenerBackend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	return b.backend.(vote.ChangeListener).ListenChanges(ctx, ready, change)
+}
+
+// Close closes the wrapped backend. It is not recorded.
+func (b *Backend) Close() error {
+	return vote.Close(b.backend)
+}
+
+// Ping checks, if the wrapped backend is reachable. It is not recorded.
+func (b *Backend) Ping(ctx context.Context) error {
+	return vote.Ping(ctx, b.backend)
+}
@@ -0,0 +1,91 @@
+package instrumented_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/backend/instrumented"
+	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+	"github.com/OpenSlides/openslides-vote-service/tracing/tracingtest"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestBackend(t *testing.T) {
+	test.Backend(t, instrumented.New(memory.New()))
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	b := instrumented.New(memory.New())
+
+	b.Start(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+	b.Vote(ctx, 2, 5, []byte(`"Y"`))
+
+	buf := new(bytes.Buffer)
+	if err := metrics.Write(buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for _, line := range []string{
+		`vote_backend_calls_total{backend="memory",operation="Start",result="ok"} `,
+		`vote_backend_calls_total{backend="memory",operation="Vote",result="ok"} `,
+		`vote_backend_calls_total{backend="memory",operation="Vote",result="double_vote"} `,
+		`vote_backend_calls_total{backend="memory",operation="Vote",result="not_exist"} `,
+		`vote_backend_duration_seconds_count{backend="memory",operation="Vote"} `,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Metrics do not contain `%s`:\n%s", line, buf)
+		}
+	}
+}
+
+// listenerBackend is a memory backend, that implements vote.ChangeListener.
+type listenerBackend struct {
+	*memory.Backend
+}
+
+func (listenerBackend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	return nil
+}
+
+func TestChangeListener(t *testing.T) {
+	if _, ok := instrumented.New(memory.New()).(vote.ChangeListener); ok {
+		t.Errorf("Backend implements vote.ChangeListener, but the memory backend does not")
+	}
+
+	if _, ok := instrumented.New(listenerBackend{memory.New()}).(vote.ChangeListener); !ok {
+		t.Errorf("Backend does not implement vote.ChangeListener, but the wrapped backend does")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	spans := tracingtest.Record(t)
+	ctx := context.Background()
+	b := instrumented.New(memory.New())
+
+	b.Start(ctx, 1)
+	b.Vote(ctx, 2, 5, []byte(`"Y"`))
+
+	expect := []string{"backend.Start", "backend.Vote"}
+	if got := tracingtest.Names(spans); !slices.Equal(got, expect) {
+		t.Fatalf("Got spans %v, expected %v", got, expect)
+	}
+
+	voteSpan := spans.GetSpans()[1]
+	if voteSpan.Status.Code != codes.Error {
+		t.Errorf("Vote span has status %v, expected an error", voteSpan.Status.Code)
+	}
+
+	if !slices.Contains(voteSpan.Attributes, attribute.Int("vote.poll_id", 2)) {
+		t.Errorf("Vote span has attributes %v, expected vote.poll_id=2", voteSpan.Attributes)
+	}
+}
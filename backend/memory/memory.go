@@ -24,6 +24,8 @@ type Backend struct {
 	mu    sync.Mutex
 	votes map[int]map[int][]byte
 	state map[int]int
+	keys  map[int]map[int]string
+	moved map[int]string
 }
 
 // New initializes a new memory.Backend.
@@ -31,6 +33,8 @@ func New() *Backend {
 	b := Backend{
 		votes: make(map[int]map[int][]byte),
 		state: make(map[int]int),
+		keys:  make(map[int]map[int]string),
+		moved: make(map[int]string),
 	}
 	return &b
 }
@@ -70,6 +74,15 @@ func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error)
 
 // Vote saves a vote.
 func (b *Backend) Vote(ctx context.Context, pollID int, userID int, vote []byte) error {
+	return b.vote(pollID, userID, vote, "")
+}
+
+// VoteIdempotent saves a vote together with an idempotency key.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, vote []byte, key string) error {
+	return b.vote(pollID, userID, vote, key)
+}
+
+func (b *Backend) vote(pollID int, userID int, vote []byte, key string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -86,10 +99,17 @@ func (b *Backend) Vote(ctx context.Context, pollID int, userID int, vote []byte)
 	}
 
 	if _, ok := b.votes[pollID][userID]; ok {
-		return doubleVoteError{fmt.Errorf("user has already voted")}
+		return doubleVoteError{fmt.Errorf("user has already voted"), b.keys[pollID][userID]}
 	}
 
 	b.votes[pollID][userID] = vote
+
+	if key != "" {
+		if b.keys[pollID] == nil {
+			b.keys[pollID] = make(map[int]string)
+		}
+		b.keys[pollID][userID] = key
+	}
 	return nil
 }
 
@@ -100,6 +120,8 @@ func (b *Backend) Clear(ctx context.Context, pollID int) error {
 
 	delete(b.votes, pollID)
 	delete(b.state, pollID)
+	delete(b.keys, pollID)
+	delete(b.moved, pollID)
 	return nil
 }
 
@@ -110,9 +132,36 @@ func (b *Backend) ClearAll(ctx context.Context) error {
 
 	b.votes = make(map[int]map[int][]byte)
 	b.state = make(map[int]int)
+	b.keys = make(map[int]map[int]string)
+	b.moved = make(map[int]string)
+	return nil
+}
+
+// SetMoved marks a poll as moved to another backend.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state[pollID] == pollStateUnknown {
+		return doesNotExistError{fmt.Errorf("Poll does not exist")}
+	}
+
+	if backend == "" {
+		delete(b.moved, pollID)
+		return nil
+	}
+	b.moved[pollID] = backend
 	return nil
 }
 
+// Moved returns the new backend of all marked polls.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return maps.Clone(b.moved), nil
+}
+
 // LiveVotes returns all votes from each user. Returns nil on non named votes.
 func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
 	b.mu.Lock()
@@ -146,10 +195,15 @@ func (doesNotExistError) DoesNotExist() {}
 
 type doubleVoteError struct {
 	error
+	key string
 }
 
 func (doubleVoteError) DoubleVote() {}
 
+func (err doubleVoteError) IdempotencyKey() string {
+	return err.key
+}
+
 type stoppedError struct {
 	error
 }
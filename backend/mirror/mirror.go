@@ -0,0 +1,397 @@
+// Package mirror implements a vote.Backend, that writes all data to a primary
+// backend and mirrors it to a secondary backend.
+//
+// The primary backend is used for all reads. The secondary backend is a backup,
+// if the primary backend looses its data. The mirroring can be synchronous or
+// asynchronous. In the asynchronous mode, the changes are written to the
+// secondary backend in the background in the same order as to the primary
+// backend.
+//
+// When a poll is stopped, the results of both backends are compared. If one
+// backend has lost votes, the result of the other backend is used.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+// queueSize is the number of changes, that can wait for the secondary backend
+// in the asynchronous mode. If the queue is full, the callers wait.
+const queueSize = 10_000
+
+// Backend writes to two backends.
+//
+// Has to be created with mirror.New().
+type Backend struct {
+	primary   vote.Backend
+	secondary vote.Backend
+
+	// queue is nil in the synchronous mode.
+	queue chan func(context.Context)
+
+	// stopLoop is closed by Close. loopDone is closed, when the mirror loop
+	// has returned.
+	stopLoop chan struct{}
+	loopDone chan struct{}
+}
+
+// New creates a backend, that mirrors the primary backend to the secondary
+// backend.
+//
+// If async is true, the secondary backend is written in the background until
+// Close is called. The writes use the values of the context, but not its
+// cancel, so no change is lost during the shutdown.
+//
+// The returned backend only implements vote.ChangeListener, if the primary
+// backend implements it.
+func New(ctx context.Context, primary, secondary vote.Backend, async bool) vote.Backend {
+	b := Backend{
+		primary:   primary,
+		secondary: secondary,
+	}
+
+	if async {
+		b.queue = make(chan func(context.Context), queueSize)
+		b.stopLoop = make(chan struct{})
+		b.loopDone = make(chan struct{})
+		go b.mirrorLoop(ctx)
+	}
+
+	if _, ok := primary.(vote.ChangeListener); ok {
+		return listenerBackend{&b}
+	}
+	return &b
+}
+
+func (b *Backend) String() string {
+	return fmt.Sprintf("mirror(%s,%s)", b.primary, b.secondary)
+}
+
+// mirrorLoop writes the queued changes until Close is called.
+func (b *Backend) mirrorLoop(ctx context.Context) {
+	defer close(b.loopDone)
+
+	ctx = context.WithoutCancel(ctx)
+	for {
+		select {
+		case f := <-b.queue:
+			f(ctx)
+		case <-b.stopLoop:
+			return
+		}
+	}
+}
+
+// mirror runs f with the secondary backend. An error is only logged, since the
+// change is already saved in the primary backend.
+func (b *Backend) mirror(ctx context.Context, name string, pollID int, f func(context.Context) error) {
+	run := func(ctx context.Context) {
+		if err := f(ctx); err != nil {
+			log.Error("mirroring %s of poll %d to %s: %v", name, pollID, b.secondary, err)
+		}
+	}
+
+	if b.queue == nil {
+		run(ctx)
+		return
+	}
+
+	// The change has to be mirrored, even if the request is canceled.
+	b.queue <- run
+}
+
+// flush waits until all queued changes are written to the secondary backend.
+func (b *Backend) flush(ctx context.Context) error {
+	if b.queue == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	select {
+	case b.queue <- func(context.Context) { close(done) }:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start starts the poll in both backends.
+func (b *Backend) Start(ctx context.Context, pollID int) error {
+	if err := b.primary.Start(ctx, pollID); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "start", pollID, func(ctx context.Context) error {
+		return b.secondary.Start(ctx, pollID)
+	})
+	return nil
+}
+
+// StartNamed starts a named poll in both backends.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) error {
+	if err := startNamed(ctx, b.primary, pollID); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "start", pollID, func(ctx context.Context) error {
+		return startNamed(ctx, b.secondary, pollID)
+	})
+	return nil
+}
+
+func startNamed(ctx context.Context, backend vote.Backend, pollID int) error {
+	if namedBackend, ok := backend.(vote.NamedBackend); ok {
+		return namedBackend.StartNamed(ctx, pollID)
+	}
+	return backend.Start(ctx, pollID)
+}
+
+// Vote saves the vote in both backends.
+func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byte) error {
+	if err := b.primary.Vote(ctx, pollID, userID, object); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "vote", pollID, func(ctx context.Context) error {
+		return b.secondary.Vote(ctx, pollID, userID, object)
+	})
+	return nil
+}
+
+// VoteIdempotent saves the vote with the idempotency key in both backends.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) error {
+	if err := voteIdempotent(ctx, b.primary, pollID, userID, object, key); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "vote", pollID, func(ctx context.Context) error {
+		return voteIdempotent(ctx, b.secondary, pollID, userID, object, key)
+	})
+	return nil
+}
+
+func voteIdempotent(ctx context.Context, backend vote.Backend, pollID int, userID int, object []byte, key string) error {
+	if idempotentBackend, ok := backend.(vote.IdempotentBackend); ok {
+		return idempotentBackend.VoteIdempotent(ctx, pollID, userID, object, key)
+	}
+	return backend.Vote(ctx, pollID, userID, object)
+}
+
+// Stop stops the poll in both backends and compares the results.
+//
+// If the voted users of one backend are a subset of the other, then the
+// backend with less users has lost votes and the result of the other backend
+// is returned. If the results differ in another way, an error is returned.
+func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error) {
+	primaryObjects, primaryUsers, primaryErr := b.primary.Stop(ctx, pollID)
+	if primaryErr != nil && !isDoesNotExist(primaryErr) {
+		return nil, nil, primaryErr
+	}
+
+	if err := b.flush(ctx); err != nil {
+		return nil, nil, fmt.Errorf("waiting for the mirror: %w", err)
+	}
+
+	secondaryObjects, secondaryUsers, secondaryErr := b.secondary.Stop(ctx, pollID)
+	if secondaryErr != nil && !isDoesNotExist(secondaryErr) {
+		log.Error("stopping poll %d in mirror %s: %v", pollID, b.secondary, secondaryErr)
+		return primaryObjects, primaryUsers, primaryErr
+	}
+
+	switch {
+	case primaryErr != nil && secondaryErr != nil:
+		return nil, nil, primaryErr
+
+	case secondaryErr != nil:
+		log.Info("Divergence: poll %d does not exist in mirror %s", pollID, b.secondary)
+		return primaryObjects, primaryUsers, nil
+
+	case primaryErr != nil:
+		log.Info("Divergence: poll %d does not exist in %s. Using the result of the mirror %s", pollID, b.primary, b.secondary)
+		return secondaryObjects, secondaryUsers, nil
+
+	case slices.Equal(primaryUsers, secondaryUsers) && len(primaryObjects) == len(secondaryObjects):
+		return primaryObjects, primaryUsers, nil
+
+	case isSubset(secondaryUsers, primaryUsers):
+		log.Info("Divergence: mirror %s has lost %d votes of poll %d", b.secondary, len(primaryUsers)-len(secondaryUsers), pollID)
+		return primaryObjects, primaryUsers, nil
+
+	case isSubset(primaryUsers, secondaryUsers):
+		log.Info("Divergence: %s has lost %d votes of poll %d. Using the result of the mirror %s", b.primary, len(secondaryUsers)-len(primaryUsers), pollID, b.secondary)
+		return secondaryObjects, secondaryUsers, nil
+
+	default:
+		return nil, nil, fmt.Errorf("results of poll %d in %s and %s diverge: %d and %d votes", pollID, b.primary, b.secondary, len(primaryUsers), len(secondaryUsers))
+	}
+}
+
+// Clear removes the poll from both backends.
+func (b *Backend) Clear(ctx context.Context, pollID int) error {
+	if err := b.primary.Clear(ctx, pollID); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "clear", pollID, func(ctx context.Context) error {
+		return b.secondary.Clear(ctx, pollID)
+	})
+	return nil
+}
+
+// ClearAll removes all data from both backends.
+func (b *Backend) ClearAll(ctx context.Context) error {
+	if err := b.primary.ClearAll(ctx); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "clear all", 0, func(ctx context.Context) error {
+		return b.secondary.ClearAll(ctx)
+	})
+	return nil
+}
+
+// SetMoved marks the poll as moved in both backends.
+//
+// It waits until the mark is also written to the secondary backend, so the
+// poll is not stopped before the mirror knows about the migration.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	if err := setMoved(ctx, b.primary, pollID, backend); err != nil {
+		return err
+	}
+
+	b.mirror(ctx, "move", pollID, func(ctx context.Context) error {
+		return setMoved(ctx, b.secondary, pollID, backend)
+	})
+
+	if err := b.flush(ctx); err != nil {
+		return fmt.Errorf("waiting for the mirror: %w", err)
+	}
+	return nil
+}
+
+func setMoved(ctx context.Context, backend vote.Backend, pollID int, name string) error {
+	if moveBackend, ok := backend.(vote.MoveBackend); ok {
+		return moveBackend.SetMoved(ctx, pollID, name)
+	}
+	return nil
+}
+
+// Moved returns the marked polls of both backends. The marks of the primary
+// backend are used, if a poll is marked in both backends.
+//
+// In the asynchronous mode, it waits for the queued changes first.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	if err := b.flush(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for the mirror: %w", err)
+	}
+
+	out := make(map[int]string)
+	for _, backend := range []vote.Backend{b.secondary, b.primary} {
+		moveBackend, ok := backend.(vote.MoveBackend)
+		if !ok {
+			continue
+		}
+
+		moved, err := moveBackend.Moved(ctx)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(out, moved)
+	}
+	return out, nil
+}
+
+// LiveVotes returns the live votes of the primary backend.
+func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
+	return b.primary.LiveVotes(ctx)
+}
+
+// listenerBackend is a Backend, whose primary backend implements
+// vote.ChangeListener.
+type listenerBackend struct {
+	*Backend
+}
+
+// ListenChanges listens for the changes of the primary backend.
+func (b listenerBackend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	return b.primary.(vote.ChangeListener).ListenChanges(ctx, ready, change)
+}
+
+// Close closes both backends.
+//
+// In the asynchronous mode, the changes, that are still in the queue, are
+// written to the secondary backend first. Close must not be called, while
+// other methods are running.
+func (b *Backend) Close() error {
+	if b.queue != nil {
+		close(b.stopLoop)
+		<-b.loopDone
+
+		for len(b.queue) > 0 {
+			f := <-b.queue
+			f(context.Background())
+		}
+	}
+
+	return errors.Join(vote.Close(b.primary), vote.Close(b.secondary))
+}
+
+// Ping checks, if the primary backend is reachable. In the synchronous mode,
+// the secondary backend has to be reachable too.
+func (b *Backend) Ping(ctx context.Context) error {
+	if err := vote.Ping(ctx, b.primary); err != nil {
+		return fmt.Errorf("primary %s: %w", b.primary, err)
+	}
+
+	if b.queue == nil {
+		if err := vote.Ping(ctx, b.secondary); err != nil {
+			return fmt.Errorf("secondary %s: %w", b.secondary, err)
+		}
+	}
+	return nil
+}
+
+// TakeToken takes a token from the token bucket of the primary backend.
+//
+// If the primary backend does not support it, it returns an error.
+func (b *Backend) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	type tokenBucket interface {
+		TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+	}
+
+	bucket, ok := b.primary.(tokenBucket)
+	if !ok {
+		return 0, fmt.Errorf("%s does not support token buckets", b.primary)
+	}
+	return bucket.TakeToken(ctx, key, rate, burst)
+}
+
+func isDoesNotExist(err error) bool {
+	var errDoesNotExist interface{ DoesNotExist() }
+	return errors.As(err, &errDoesNotExist)
+}
+
+// isSubset returns true, if all elements of the sorted slice sub are in the
+// sorted slice set.
+func isSubset(sub, set []int) bool {
+	for _, v := range sub {
+		if _, found := slices.BinarySearch(set, v); !found {
+			return false
+		}
+	}
+	return true
+}
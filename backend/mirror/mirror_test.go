@@ -0,0 +1,150 @@
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/backend/mirror"
+	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+func TestBackend(t *testing.T) {
+	t.Run("sync", func(t *testing.T) {
+		test.Backend(t, mirror.New(t.Context(), memory.New(), memory.New(), false))
+	})
+
+	t.Run("async", func(t *testing.T) {
+		test.Backend(t, mirror.New(t.Context(), memory.New(), memory.New(), true))
+	})
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.New()
+	secondary := memory.New()
+	b := mirror.New(t.Context(), primary, secondary, true)
+
+	b.Start(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+
+	// Stop has to wait for the mirror.
+	objects, userIDs, err := b.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if !reflect.DeepEqual(userIDs, []int{5}) || len(objects) != 1 {
+		t.Errorf("Stop returned %q and %v, expected one vote of user 5", objects, userIDs)
+	}
+
+	secondary.AssertUserHasVoted(t, 1, 5)
+}
+
+func TestStopDivergence(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("primary lost votes", func(t *testing.T) {
+		primary := memory.New()
+		secondary := memory.New()
+		b := mirror.New(t.Context(), primary, secondary, false)
+
+		b.Start(ctx, 1)
+		b.Vote(ctx, 1, 5, []byte(`"Y"`))
+
+		// Simulate a restart of the primary backend.
+		primary.ClearAll(ctx)
+
+		_, userIDs, err := b.Stop(ctx, 1)
+		if err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+
+		if !reflect.DeepEqual(userIDs, []int{5}) {
+			t.Errorf("Stop returned users %v, expected [5]", userIDs)
+		}
+	})
+
+	t.Run("mirror lost votes", func(t *testing.T) {
+		primary := memory.New()
+		secondary := memory.New()
+		b := mirror.New(t.Context(), primary, secondary, false)
+
+		b.Start(ctx, 1)
+		b.Vote(ctx, 1, 5, []byte(`"Y"`))
+		secondary.Clear(ctx, 1)
+		secondary.Start(ctx, 1)
+
+		_, userIDs, err := b.Stop(ctx, 1)
+		if err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+
+		if !reflect.DeepEqual(userIDs, []int{5}) {
+			t.Errorf("Stop returned users %v, expected [5]", userIDs)
+		}
+	})
+
+	t.Run("diverged", func(t *testing.T) {
+		primary := memory.New()
+		secondary := memory.New()
+		b := mirror.New(t.Context(), primary, secondary, false)
+
+		b.Start(ctx, 1)
+		primary.Vote(ctx, 1, 5, []byte(`"Y"`))
+		secondary.Vote(ctx, 1, 6, []byte(`"Y"`))
+
+		if _, _, err := b.Stop(ctx, 1); err == nil {
+			t.Errorf("Stop with diverged backends did not return an error")
+		}
+	})
+}
+
+// unreachable is a backend, that can not be reached.
+type unreachable struct {
+	*memory.Backend
+}
+
+func (unreachable) Ping(context.Context) error {
+	return errors.New("unreachable")
+}
+
+func TestPing(t *testing.T) {
+	ctx := t.Context()
+
+	if err := vote.Ping(ctx, mirror.New(ctx, memory.New(), memory.New(), false)); err != nil {
+		t.Errorf("Ping with reachable backends: %v", err)
+	}
+
+	if err := vote.Ping(ctx, mirror.New(ctx, unreachable{memory.New()}, memory.New(), true)); err == nil {
+		t.Errorf("Ping with unreachable primary returned no error")
+	}
+
+	if err := vote.Ping(ctx, mirror.New(ctx, memory.New(), unreachable{memory.New()}, false)); err == nil {
+		t.Errorf("Ping with unreachable secondary in sync mode returned no error")
+	}
+
+	if err := vote.Ping(ctx, mirror.New(ctx, memory.New(), unreachable{memory.New()}, true)); err != nil {
+		t.Errorf("Ping with unreachable secondary in async mode: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.New()
+	secondary := memory.New()
+	b := mirror.New(ctx, primary, secondary, true)
+
+	b.Start(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+
+	// Close has to write the queued changes.
+	if err := vote.Close(b); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	secondary.AssertUserHasVoted(t, 1, 5)
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OpenSlides/openslides-vote-service/audit"
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auditLockID is the key of the advisory lock, that is held while an entry is
+// added to the audit log. It makes sure, that the instances build one chain.
+const auditLockID = 0x61756474 // "audt"
+
+// AuditSink saves the audit log in the table vote_audit.log.
+//
+// Has to be created with Backend.AuditSink().
+type AuditSink struct {
+	pool *pgxpool.Pool
+}
+
+// AuditSink returns a sink for the audit log, that uses the connection pool of
+// the backend.
+func (b *Backend) AuditSink() *AuditSink {
+	return &AuditSink{pool: b.pool}
+}
+
+// Append adds an entry after the last entry in the table.
+func (s *AuditSink) Append(ctx context.Context, e audit.Entry) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		sql := "SELECT pg_advisory_xact_lock($1);"
+		log.Debug("SQL: `%s` (values: %d)", sql, auditLockID)
+		if _, err := tx.Exec(ctx, sql, auditLockID); err != nil {
+			return fmt.Errorf("acquiring audit lock: %w", err)
+		}
+
+		sql = `SELECT seq, time, operation, caller, poll_id, votes, outcome, prev_hash, hash
+		FROM vote_audit.log ORDER BY seq DESC LIMIT 1;`
+		log.Debug("SQL: `%s`", sql)
+
+		var prev audit.Entry
+		var prevPtr *audit.Entry
+		err := tx.QueryRow(ctx, sql).Scan(&prev.Seq, &prev.Time, &prev.Operation, &prev.Caller, &prev.PollID, &prev.Votes, &prev.Outcome, &prev.PrevHash, &prev.Hash)
+		switch {
+		case err == nil:
+			prevPtr = &prev
+		case errors.Is(err, pgx.ErrNoRows):
+		default:
+			return fmt.Errorf("fetching last entry: %w", err)
+		}
+
+		e = audit.Chain(prevPtr, e)
+
+		sql = `INSERT INTO vote_audit.log (seq, time, operation, caller, poll_id, votes, outcome, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+		log.Debug("SQL: `%s` (values: %d, ...)", sql, e.Seq)
+		if _, err := tx.Exec(ctx, sql, e.Seq, e.Time, e.Operation, e.Caller, e.PollID, e.Votes, e.Outcome, e.PrevHash, e.Hash); err != nil {
+			return fmt.Errorf("inserting entry: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Entries calls fn for each entry ordered by the sequence number.
+func (s *AuditSink) Entries(ctx context.Context, fn func(audit.Entry) error) error {
+	sql := `SELECT seq, time, operation, caller, poll_id, votes, outcome, prev_hash, hash
+	FROM vote_audit.log ORDER BY seq;`
+	log.Debug("SQL: `%s`", sql)
+
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("fetching entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e audit.Entry
+		if err := rows.Scan(&e.Seq, &e.Time, &e.Operation, &e.Caller, &e.PollID, &e.Votes, &e.Outcome, &e.PrevHash, &e.Hash); err != nil {
+			return fmt.Errorf("parsing entry: %w", err)
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading entries: %w", err)
+	}
+	return nil
+}
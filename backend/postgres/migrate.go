@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the advisory lock, that is held while the
+// migrations are applied. It prevents, that many instances migrate at the same
+// time.
+const migrationLockID = 0x766f7465 // "vote"
+
+// Migration is a change of the database schema.
+//
+// The migrations are embedded as files `migrations/NNNN_name.sql`, where NNNN
+// is the version. They are applied in the order of their version and can not
+// be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Applied bool
+
+	sql string
+}
+
+// migrations returns all embedded migrations sorted by version.
+func migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading migration files: %w", err)
+	}
+
+	out := make([]Migration, 0, len(files))
+	for _, file := range files {
+		rawVersion, name, ok := strings.Cut(strings.TrimSuffix(file.Name(), ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", file.Name())
+		}
+
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of migration file %s: %w", file.Name(), err)
+		}
+
+		sql, err := fs.ReadFile(migrationFiles, path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration file %s: %w", file.Name(), err)
+		}
+
+		out = append(out, Migration{Version: version, Name: name, sql: string(sql)})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+
+	for i, m := range out {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+	}
+
+	return out, nil
+}
+
+// createVersionTable creates the table, that stores the applied migrations.
+func (b *Backend) createVersionTable(ctx context.Context, tx pgx.Tx) error {
+	sql := b.sql(`
+	CREATE SCHEMA IF NOT EXISTS vote;
+
+	CREATE TABLE IF NOT EXISTS vote.schema_version(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`)
+	log.Debug("SQL: `%s`", sql)
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("creating version table: %w", err)
+	}
+	return nil
+}
+
+// schemaVersion returns the version of the last applied migration.
+func (b *Backend) schemaVersion(ctx context.Context, db interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}) (int, error) {
+	sql := b.sql("SELECT COALESCE(MAX(version), 0) FROM vote.schema_version;")
+	log.Debug("SQL: `%s`", sql)
+
+	var version int
+	if err := db.QueryRow(ctx, sql).Scan(&version); err != nil {
+		return 0, fmt.Errorf("fetching schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate applies all pending migrations.
+//
+// All migrations are applied in one transaction. An advisory lock makes sure,
+// that only one instance migrates the database at the same time.
+func (b *Backend) Migrate(ctx context.Context) error {
+	all, err := migrations()
+	if err != nil {
+		return fmt.Errorf("loading migrations: %w", err)
+	}
+
+	return pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+		sql := "SELECT pg_advisory_xact_lock($1);"
+		log.Debug("SQL: `%s` (values: %d)", sql, migrationLockID)
+		if _, err := tx.Exec(ctx, sql, migrationLockID); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+
+		if err := b.createVersionTable(ctx, tx); err != nil {
+			return err
+		}
+
+		version, err := b.schemaVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all[min(version, len(all)):] {
+			log.Info("Applying migration %d to %s: %s", m.Version, b.schema, m.Name)
+			migration := b.sql(m.sql)
+			log.Debug("SQL: `%s`", migration)
+			if _, err := tx.Exec(ctx, migration); err != nil {
+				return fmt.Errorf("applying migration %d %s: %w", m.Version, m.Name, err)
+			}
+
+			sql := b.sql("INSERT INTO vote.schema_version (version, name) VALUES ($1, $2);")
+			log.Debug("SQL: `%s` (values: %d, %s)", sql, m.Version, m.Name)
+			if _, err := tx.Exec(ctx, sql, m.Version, m.Name); err != nil {
+				return fmt.Errorf("saving version %d: %w", m.Version, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Migrations returns all known migrations and whether they are applied.
+func (b *Backend) Migrations(ctx context.Context) ([]Migration, error) {
+	all, err := migrations()
+	if err != nil {
+		return nil, fmt.Errorf("loading migrations: %w", err)
+	}
+
+	sql := b.sql("SELECT to_regclass('vote.schema_version') IS NOT NULL;")
+	log.Debug("SQL: `%s`", sql)
+
+	var hasVersionTable bool
+	if err := b.pool.QueryRow(ctx, sql).Scan(&hasVersionTable); err != nil {
+		return nil, fmt.Errorf("checking version table: %w", err)
+	}
+
+	var version int
+	if hasVersionTable {
+		version, err = b.schemaVersion(ctx, b.pool)
+		if err != nil {
+			return nil, fmt.Errorf("reading schema version: %w", err)
+		}
+	}
+
+	if version > len(all) {
+		return nil, fmt.Errorf("database has schema version %d, but only %d migrations are known", version, len(all))
+	}
+
+	for i := range all[:version] {
+		all[i].Applied = true
+	}
+	return all, nil
+}
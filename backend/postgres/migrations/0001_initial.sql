@@ -0,0 +1,37 @@
+-- The tables use IF NOT EXISTS, since databases from before the versioned
+-- migrations already have them.
+
+CREATE TABLE IF NOT EXISTS vote.poll(
+    id INTEGER UNIQUE NOT NULL,
+    stopped BOOLEAN NOT NULL,
+
+    -- user_ids is managed by the application. It stores all user ids in a way
+    -- that makes it impossible to see the sequence in which the users have
+    -- voted.
+    user_ids BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS vote.objects (
+    id SERIAL PRIMARY KEY,
+
+    -- There are many raws per poll.
+    poll_id INTEGER NOT NULL REFERENCES vote.poll(id) ON DELETE CASCADE,
+
+    -- The vote object.
+    vote BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS vote.idempotency (
+    poll_id INTEGER NOT NULL REFERENCES vote.poll(id) ON DELETE CASCADE,
+
+    -- The first part of the key. It is a hash of the user and the key, that
+    -- can only be calculated with the secret of the vote service. The user id
+    -- is not saved, so the row can not be linked to a user.
+    key_id TEXT NOT NULL,
+
+    -- The idempotency key of the vote. The vote service only saves a hash of
+    -- the key that can not be used to find out the vote.
+    key TEXT NOT NULL,
+
+    PRIMARY KEY (poll_id, key_id)
+);
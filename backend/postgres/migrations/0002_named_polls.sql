@@ -0,0 +1,7 @@
+-- named is true for named polls. Only for them, the link between a user and
+-- the vote is saved.
+ALTER TABLE vote.poll ADD COLUMN IF NOT EXISTS named BOOLEAN NOT NULL DEFAULT false;
+
+-- user_id is the user of the vote. It is only set for named polls. For all
+-- other polls, it is NULL, so the votes can not be linked to the users.
+ALTER TABLE vote.objects ADD COLUMN IF NOT EXISTS user_id INTEGER;
@@ -0,0 +1,28 @@
+-- The audit log is in its own schema, so it is not removed by ClearAll, which
+-- drops the vote schema. This migration runs again after ClearAll, so it has to
+-- be idempotent.
+CREATE SCHEMA IF NOT EXISTS vote_audit;
+
+-- log holds the hash chain of the audit log. See the package audit.
+CREATE TABLE IF NOT EXISTS vote_audit.log (
+    seq INTEGER PRIMARY KEY,
+    time TIMESTAMPTZ NOT NULL,
+    operation TEXT NOT NULL,
+    caller TEXT NOT NULL,
+    poll_id INTEGER NOT NULL,
+    votes INTEGER NOT NULL,
+    outcome TEXT NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+-- Entries can only be added.
+CREATE OR REPLACE FUNCTION vote_audit.append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'vote_audit.log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS append_only ON vote_audit.log;
+CREATE TRIGGER append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON vote_audit.log
+    FOR EACH STATEMENT EXECUTE FUNCTION vote_audit.append_only();
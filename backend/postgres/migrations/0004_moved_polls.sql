@@ -0,0 +1,3 @@
+-- moved is the name of the backend, the poll is moved to. It is only set, while
+-- the poll is migrated.
+ALTER TABLE vote.poll ADD COLUMN IF NOT EXISTS moved TEXT;
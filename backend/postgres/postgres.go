@@ -3,27 +3,54 @@ package postgres
 import (
 	"bytes"
 	"context"
-	_ "embed" // Needed for file embedding
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+	"github.com/OpenSlides/openslides-vote-service/vote"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-//go:embed schema.sql
-var schema string
+// defaultSchema is the schema of the backend from New. The channel to inform
+// all instances about changes is the schema with the suffix `_changes`.
+const defaultSchema = "vote"
+
+// maxNotifyPayload is the maximal size of a notification in postgres.
+const maxNotifyPayload = 7999
+
+const (
+	// listenProbePrefix is the start of a notification, that checks if the
+	// listening connection receives notifications. It is not a change.
+	listenProbePrefix = "probe:"
+
+	// listenProbeTimeout is the time to wait for the own probe after LISTEN.
+	listenProbeTimeout = 5 * time.Second
+)
 
 // Backend holds the state of the backend.
 //
 // Has to be initializes with New().
 type Backend struct {
 	pool *pgxpool.Pool
+
+	// schema is the postgres schema of the tables. channel is the channel for
+	// the notifications of this schema.
+	schema  string
+	channel string
+
+	// sharedPool is true for a backend from WithSchema. It does not close the
+	// pool.
+	sharedPool bool
 }
 
 // New creates a new connection pool.
@@ -46,20 +73,63 @@ func New(ctx context.Context, connString string) (*Backend, error) {
 	}
 
 	b := Backend{
-		pool: pool,
+		pool:    pool,
+		schema:  defaultSchema,
+		channel: defaultSchema + "_changes",
 	}
 
 	return &b, nil
 }
 
+// WithSchema returns a backend, that uses the same connection pool, but saves
+// its polls in another schema and uses another channel for the notifications.
+//
+// The polls of both backends are independent. The migrations have to be applied
+// to the returned backend. Closing it does not close the pool.
+func (b *Backend) WithSchema(schema string) (*Backend, error) {
+	if !validSchema.MatchString(schema) {
+		return nil, fmt.Errorf("invalid schema name %q", schema)
+	}
+
+	if schema == b.schema {
+		return nil, fmt.Errorf("schema %s is already used", schema)
+	}
+
+	return &Backend{
+		pool:       b.pool,
+		schema:     schema,
+		channel:    schema + "_changes",
+		sharedPool: true,
+	}, nil
+}
+
+// validSchema matches the schema names, that can be used in a query without
+// quoting.
+var validSchema = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// schemaRef matches the references to the default schema in a query.
+var schemaRef = regexp.MustCompile(`\bvote(\.[a-z]|;| CASCADE)`)
+
+// sql returns the query for the schema of the backend. The queries are written
+// for the default schema `vote`.
+func (b *Backend) sql(query string) string {
+	if b.schema == defaultSchema {
+		return query
+	}
+	return schemaRef.ReplaceAllString(query, b.schema+"$1")
+}
+
 func (b *Backend) String() string {
+	if b.schema != defaultSchema {
+		return "postgres(" + b.schema + ")"
+	}
 	return "postgres"
 }
 
 // Wait blocks until a connection to postgres can be established.
 func (b *Backend) Wait(ctx context.Context) {
 	for ctx.Err() == nil {
-		err := b.pool.Ping(ctx)
+		err := b.Ping(ctx)
 		if err == nil {
 			return
 		}
@@ -68,28 +138,52 @@ func (b *Backend) Wait(ctx context.Context) {
 	}
 }
 
-// Migrate creates the database schema.
-func (b *Backend) Migrate(ctx context.Context) error {
-	if _, err := b.pool.Exec(ctx, schema); err != nil {
-		return fmt.Errorf("creating schema: %w", err)
+// Ping checks, if postgres is reachable.
+func (b *Backend) Ping(ctx context.Context) error {
+	if err := b.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("sending ping: %w", err)
 	}
 	return nil
 }
 
 // Close closes all connections. It blocks, until all connection are closed.
-func (b *Backend) Close() {
+func (b *Backend) Close() error {
+	if b.sharedPool {
+		return nil
+	}
 	b.pool.Close()
+	return nil
 }
 
 // Start starts a poll.
 func (b *Backend) Start(ctx context.Context, pollID int) error {
-	sql := `INSERT INTO vote.poll (id, stopped) VALUES ($1, false) ON CONFLICT DO NOTHING;
-	`
-	log.Debug("SQL: `%s` (values: %d)", sql, pollID)
-	if _, err := b.pool.Exec(ctx, sql, pollID); err != nil {
-		return fmt.Errorf("insert poll: %w", err)
-	}
-	return nil
+	return b.start(ctx, pollID, false)
+}
+
+// StartNamed starts a named poll.
+//
+// For a named poll, the user is saved with the vote, so LiveVotes can return
+// the votes.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) error {
+	return b.start(ctx, pollID, true)
+}
+
+func (b *Backend) start(ctx context.Context, pollID int, named bool) error {
+	return pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+		sql := b.sql(`INSERT INTO vote.poll (id, stopped, named) VALUES ($1, false, $2) ON CONFLICT DO NOTHING;
+		`)
+		log.Debug("SQL: `%s` (values: %d, %t)", sql, pollID, named)
+		result, err := tx.Exec(ctx, sql, pollID, named)
+		if err != nil {
+			return fmt.Errorf("insert poll: %w", err)
+		}
+
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+
+		return notify(ctx, tx, b.channel, vote.BackendChange{Type: vote.ChangeStart, PollID: pollID})
+	})
 }
 
 // Vote adds a vote to a poll.
@@ -98,12 +192,20 @@ func (b *Backend) Start(ctx context.Context, pollID int) error {
 // either the vote is saved or the given context is canceled.
 func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byte) error {
 	return continueOnTransactionError(ctx, func() error {
-		return b.voteOnce(ctx, pollID, userID, object)
+		return b.voteOnce(ctx, pollID, userID, object, "")
+	})
+}
+
+// VoteIdempotent is like Vote but saves the idempotency key in the same
+// transaction.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) error {
+	return continueOnTransactionError(ctx, func() error {
+		return b.voteOnce(ctx, pollID, userID, object, key)
 	})
 }
 
 // voteOnce tries to add the vote once.
-func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object []byte) (err error) {
+func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object []byte, key string) (err error) {
 	log.Debug("SQL: Begin transaction for vote")
 	defer func() {
 		log.Debug("SQL: End transaction for vote with error: %v", err)
@@ -116,12 +218,13 @@ func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object [
 			IsoLevel: "REPEATABLE READ",
 		},
 		func(tx pgx.Tx) error {
-			sql := `SELECT stopped, user_ids FROM vote.poll	WHERE id = $1;`
+			sql := b.sql(`SELECT stopped, named, user_ids FROM vote.poll	WHERE id = $1;`)
 			log.Debug("SQL: `%s` (values: %d)", sql, pollID)
 
 			var stopped bool
+			var named bool
 			var uIDsRaw []byte
-			if err := tx.QueryRow(ctx, sql, pollID).Scan(&stopped, &uIDsRaw); err != nil {
+			if err := tx.QueryRow(ctx, sql, pollID).Scan(&stopped, &named, &uIDsRaw); err != nil {
 				if errors.Is(err, pgx.ErrNoRows) {
 					return doesNotExistError{fmt.Errorf("unknown poll")}
 				}
@@ -138,6 +241,15 @@ func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object [
 			}
 
 			if err := uIDs.add(int32(userID)); err != nil {
+				var errDoubleVote doubleVoteError
+				if errors.As(err, &errDoubleVote) {
+					savedKey, err := b.idempotencyKey(ctx, tx, pollID, key)
+					if err != nil {
+						return fmt.Errorf("fetching idempotency key: %w", err)
+					}
+					errDoubleVote.key = savedKey
+					return errDoubleVote
+				}
 				return fmt.Errorf("adding userID to voted users: %w", err)
 			}
 
@@ -146,19 +258,42 @@ func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object [
 				return fmt.Errorf("converting user ids to bytes: %w", err)
 			}
 
-			sql = "UPDATE vote.poll SET user_ids = $1 WHERE id = $2;"
+			sql = b.sql("UPDATE vote.poll SET user_ids = $1 WHERE id = $2;")
 			log.Debug("SQL: `%s` (values: [user_ids]), %d", sql, pollID)
 			if _, err := tx.Exec(ctx, sql, uIDsRaw, pollID); err != nil {
 				return fmt.Errorf("writing user ids: %w", err)
 			}
 
-			sql = "INSERT INTO vote.objects (poll_id, vote) VALUES ($1, $2);"
-			log.Debug("SQL: `%s` (values: %d, [vote]", sql, pollID)
-			if _, err := tx.Exec(ctx, sql, pollID, object); err != nil {
+			// Only for named polls, the vote is linked to the user.
+			var voteUserID *int
+			if named {
+				voteUserID = &userID
+			}
+
+			sql = b.sql("INSERT INTO vote.objects (poll_id, vote, user_id) VALUES ($1, $2, $3);")
+			log.Debug("SQL: `%s` (values: %d, [vote], [user_id])", sql, pollID)
+			if _, err := tx.Exec(ctx, sql, pollID, object, voteUserID); err != nil {
 				return fmt.Errorf("writing vote: %w", err)
 			}
 
-			return nil
+			if key != "" {
+				// The key is saved without the user. The key id identifies the
+				// user only with the secret of the vote service.
+				keyID, _, _ := strings.Cut(key, ":")
+				sql = b.sql("INSERT INTO vote.idempotency (poll_id, key_id, key) VALUES ($1, $2, $3);")
+				log.Debug("SQL: `%s` (values: %d, [key_id], [key])", sql, pollID)
+				if _, err := tx.Exec(ctx, sql, pollID, keyID, key); err != nil {
+					return fmt.Errorf("writing idempotency key: %w", err)
+				}
+			}
+
+			// For secret polls, the vote is not part of the message. The
+			// message would link the user to the vote.
+			change := vote.BackendChange{Type: vote.ChangeVote, PollID: pollID, UserID: userID}
+			if named {
+				change.Vote = object
+			}
+			return notify(ctx, tx, b.channel, change)
 		},
 	)
 	if err != nil {
@@ -167,6 +302,29 @@ func (b *Backend) voteOnce(ctx context.Context, pollID int, userID int, object [
 	return nil
 }
 
+// idempotencyKey returns the idempotency key that was saved with the same key
+// id as the given key. Returns an empty string, if there is no such key.
+//
+// The key id is the part of the key before the first `:`.
+func (b *Backend) idempotencyKey(ctx context.Context, tx pgx.Tx, pollID int, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	keyID, _, _ := strings.Cut(key, ":")
+
+	sql := b.sql("SELECT key FROM vote.idempotency WHERE poll_id = $1 AND key_id = $2;")
+	log.Debug("SQL: `%s` (values: %d, [key_id])", sql, pollID)
+
+	var savedKey string
+	if err := tx.QueryRow(ctx, sql, pollID, keyID).Scan(&savedKey); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("fetching key: %w", err)
+	}
+	return savedKey, nil
+}
+
 // Stop ends a poll and returns all vote objects and users who have voted.
 //
 // If an transaction error happens, the poll is stopped again. This is done
@@ -201,7 +359,7 @@ func (b *Backend) stopOnce(ctx context.Context, pollID int) (objects [][]byte, u
 			IsoLevel: "REPEATABLE READ",
 		},
 		func(tx pgx.Tx) error {
-			sql := "SELECT EXISTS(SELECT 1 FROM vote.poll WHERE id = $1);"
+			sql := b.sql("SELECT EXISTS(SELECT 1 FROM vote.poll WHERE id = $1);")
 			log.Debug("SQL: `%s` (values: %d", sql, pollID)
 
 			var exists bool
@@ -213,17 +371,17 @@ func (b *Backend) stopOnce(ctx context.Context, pollID int) (objects [][]byte, u
 				return doesNotExistError{fmt.Errorf("Poll does not exist")}
 			}
 
-			sql = "UPDATE vote.poll SET stopped = true WHERE id = $1;"
+			sql = b.sql("UPDATE vote.poll SET stopped = true WHERE id = $1;")
 			if _, err := tx.Exec(ctx, sql, pollID); err != nil {
 				return fmt.Errorf("setting poll %d to stopped: %w", pollID, err)
 			}
 
-			sql = `
+			sql = b.sql(`
 			SELECT Obj.vote
 			FROM vote.poll Poll
 			LEFT JOIN vote.objects Obj ON Obj.poll_id = Poll.id
 			WHERE Poll.id = $1;
-			`
+			`)
 			log.Debug("SQL: `%s` (values: %d", sql, pollID)
 			rows, err := tx.Query(ctx, sql, pollID)
 			if err != nil {
@@ -246,11 +404,11 @@ func (b *Backend) stopOnce(ctx context.Context, pollID int) (objects [][]byte, u
 				return fmt.Errorf("parsing query rows: %w", err)
 			}
 
-			sql = `
+			sql = b.sql(`
 			SELECT user_ids
 			FROM vote.poll
 			WHERE poll.id = $1;
-			`
+			`)
 			var rawUserIDs []byte
 			if err := tx.QueryRow(ctx, sql, pollID).Scan(&rawUserIDs); err != nil {
 				return fmt.Errorf("fetching poll data: %w", err)
@@ -265,7 +423,7 @@ func (b *Backend) stopOnce(ctx context.Context, pollID int) (objects [][]byte, u
 				users = append(users, int(id))
 			}
 
-			return nil
+			return notify(ctx, tx, b.channel, vote.BackendChange{Type: vote.ChangeStop, PollID: pollID})
 		},
 	)
 	if err != nil {
@@ -276,24 +434,67 @@ func (b *Backend) stopOnce(ctx context.Context, pollID int) (objects [][]byte, u
 
 // Clear removes all data about a poll from the database.
 func (b *Backend) Clear(ctx context.Context, pollID int) error {
-	sql := "DELETE FROM vote.poll WHERE id = $1"
-	log.Debug("SQL: `%s` (values: %d)", sql, pollID)
-	if _, err := b.pool.Exec(ctx, sql, pollID); err != nil {
-		return fmt.Errorf("deleting data of poll %d: %w", pollID, err)
+	return pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+		sql := b.sql("DELETE FROM vote.poll WHERE id = $1")
+		log.Debug("SQL: `%s` (values: %d)", sql, pollID)
+		if _, err := tx.Exec(ctx, sql, pollID); err != nil {
+			return fmt.Errorf("deleting data of poll %d: %w", pollID, err)
+		}
+
+		return notify(ctx, tx, b.channel, vote.BackendChange{Type: vote.ChangeClear, PollID: pollID})
+	})
+}
+
+// SetMoved marks a poll as moved to another backend.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	sql := b.sql("UPDATE vote.poll SET moved = NULLIF($1, '') WHERE id = $2;")
+	log.Debug("SQL: `%s` (values: %s, %d)", sql, backend, pollID)
+	result, err := b.pool.Exec(ctx, sql, backend, pollID)
+	if err != nil {
+		return fmt.Errorf("setting moved of poll %d: %w", pollID, err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return doesNotExistError{fmt.Errorf("Poll does not exist")}
 	}
 	return nil
 }
 
+// Moved returns the new backend of all marked polls.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	sql := b.sql("SELECT id, moved FROM vote.poll WHERE moved IS NOT NULL;")
+	log.Debug("SQL: `%s`", sql)
+	rows, err := b.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("fetching moved polls: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[int]string)
+	for rows.Next() {
+		var pollID int
+		var backend string
+		if err := rows.Scan(&pollID, &backend); err != nil {
+			return nil, fmt.Errorf("parsing row: %w", err)
+		}
+		out[pollID] = backend
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("parsing query rows: %w", err)
+	}
+	return out, nil
+}
+
 // ClearAll removes all vote related data from postgres.
 //
-// It does this by dropping vote vote-schema. If other services would write
+// It does this by dropping the schema of the backend. If other services would write
 // thinks in this schema or hava a relation to this schema, then this would also
 // delete this tables.
 //
-// Since the schema is deleted and afterwards recreated this command can also be
-// used, if the db-schema has changed. It is kind of a migration.
+// Afterwards, the schema is recreated with all migrations.
 func (b *Backend) ClearAll(ctx context.Context) error {
-	sql := "DROP SCHEMA IF EXISTS vote CASCADE"
+	sql := b.sql("DROP SCHEMA IF EXISTS vote CASCADE")
 	log.Debug("SQL: `%s`", sql)
 	if _, err := b.pool.Exec(ctx, sql); err != nil {
 		return fmt.Errorf("deleting vote schema: %w", err)
@@ -302,15 +503,135 @@ func (b *Backend) ClearAll(ctx context.Context) error {
 	if err := b.Migrate(ctx); err != nil {
 		return fmt.Errorf("recreate schema: %w", err)
 	}
+
+	if err := notify(ctx, b.pool, b.channel, vote.BackendChange{Type: vote.ChangeClearAll}); err != nil {
+		return fmt.Errorf("notify clear all: %w", err)
+	}
 	return nil
 }
 
+// notify sends a change to all instances. If it is called inside a
+// transaction, the message is only send, when the transaction is committed.
+func notify(ctx context.Context, db interface {
+	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+}, channel string, change vote.BackendChange) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("encoding change: %w", err)
+	}
+
+	if len(payload) > maxNotifyPayload {
+		// A change without the vote would look like an empty vote. The
+		// instances load the poll from the backend instead.
+		payload, err = json.Marshal(vote.BackendChange{Type: vote.ChangeReload, PollID: change.PollID})
+		if err != nil {
+			return fmt.Errorf("encoding reload: %w", err)
+		}
+	}
+
+	sql := "SELECT pg_notify($1, $2);"
+	log.Debug("SQL: `%s` (values: %s, %s)", sql, channel, log.Redacted(payload))
+	if _, err := db.Exec(ctx, sql, channel, string(payload)); err != nil {
+		return fmt.Errorf("sending notification: %w", err)
+	}
+	return nil
+}
+
+// ListenChanges calls change for each change of any instance.
+//
+// It uses its own connection from the pool. It blocks until the context is
+// done or the connection fails.
+//
+// After LISTEN, it sends a probe notification and returns an error, if the
+// probe is not received. This happens behind a connection pooler in
+// transaction mode, where LISTEN succeeds, but the notifications are sent to
+// another client.
+func (b *Backend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	poolConn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+
+	// The connection is not returned to the pool, since it still listens.
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	sql := "LISTEN " + b.channel
+	log.Debug("SQL: `%s`", sql)
+	if _, err := conn.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("listen to %s: %w", b.channel, err)
+	}
+
+	if err := b.probeListen(ctx, conn); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ready()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("waiting for notification: %w", err)
+		}
+
+		if strings.HasPrefix(notification.Payload, listenProbePrefix) {
+			// Probe of another instance.
+			continue
+		}
+
+		var c vote.BackendChange
+		if err := json.Unmarshal([]byte(notification.Payload), &c); err != nil {
+			log.Error("decoding change %s: %v", log.Redacted(notification.Payload), err)
+			continue
+		}
+		change(c)
+	}
+}
+
+// probeListen sends a notification with another connection and waits until the
+// listening connection receives it.
+//
+// Changes, that are received before the probe, are dropped. The caller loads
+// them with ready.
+func (b *Backend) probeListen(ctx context.Context, conn *pgx.Conn) error {
+	probe := listenProbePrefix + rand.Text()
+
+	sql := "SELECT pg_notify($1, $2);"
+	log.Debug("SQL: `%s` (values: %s, %s)", sql, b.channel, probe)
+	if _, err := b.pool.Exec(ctx, sql, b.channel, probe); err != nil {
+		return fmt.Errorf("sending probe notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, listenProbeTimeout)
+	defer cancel()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("probe notification was not received in %s. LISTEN does not work through a connection pooler in transaction mode", listenProbeTimeout)
+			}
+			return fmt.Errorf("waiting for probe notification: %w", err)
+		}
+
+		if notification.Payload == probe {
+			return nil
+		}
+	}
+}
+
 // LiveVotes returns all votes from each user.
 //
-// This this is impossible for the current implementation, it only returns nil
-// for each vote.
+// The votes are only returned for named polls. For all other polls, it
+// returns nil for each vote.
 func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
-	sql := `SELECT id, user_ids	FROM vote.poll;`
+	sql := b.sql(`SELECT id, user_ids	FROM vote.poll;`)
 
 	log.Debug("SQL: `%s`", sql)
 	rows, err := b.pool.Query(ctx, sql)
@@ -337,9 +658,45 @@ func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error)
 		}
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("parsing query rows: %w", err)
+	}
+
+	sql = b.sql(`
+	SELECT Obj.poll_id, Obj.user_id, Obj.vote
+	FROM vote.objects Obj
+	JOIN vote.poll Poll ON Poll.id = Obj.poll_id
+	WHERE Poll.named AND Obj.user_id IS NOT NULL;
+	`)
+
+	log.Debug("SQL: `%s`", sql)
+	rows, err = b.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("fetching votes of named polls: %w", err)
+	}
+
+	for rows.Next() {
+		var pid int
+		var uid int
+		var vote []byte
+		if err := rows.Scan(&pid, &uid, &vote); err != nil {
+			return nil, fmt.Errorf("parsing row: %w", err)
+		}
+
+		if _, ok := out[pid]; ok {
+			out[pid][uid] = vote
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("parsing query rows: %w", err)
+	}
+
 	return out, nil
 }
 
+var metricTransactionRetries = metrics.NewCounterVec("vote_postgres_transaction_retries_total", "Number of transactions, that were repeated because of a serialization error.")
+
 // ContinueOnTransactionError runs the given many times until is does not return
 // an transaction error. Also stopes, when the given context is canceled.
 func continueOnTransactionError(ctx context.Context, f func() error) error {
@@ -360,6 +717,7 @@ func continueOnTransactionError(ctx context.Context, f func() error) error {
 		if perr.Code != "40001" {
 			break
 		}
+		metricTransactionRetries.Inc()
 	}
 	return err
 }
@@ -390,7 +748,7 @@ func (u *userIDList) add(userID int32) error {
 	ints := []int32(*u)
 	idx := sort.Search(len(ints), func(i int) bool { return ints[i] >= userID })
 	if idx < len(ints) && ints[idx] == userID {
-		return doubleVoteError{fmt.Errorf("User has already voted")}
+		return doubleVoteError{error: fmt.Errorf("User has already voted")}
 	}
 
 	// Insert the index at the correct order.
@@ -418,10 +776,15 @@ func (doesNotExistError) DoesNotExist() {}
 
 type doubleVoteError struct {
 	error
+	key string
 }
 
 func (doubleVoteError) DoubleVote() {}
 
+func (err doubleVoteError) IdempotencyKey() string {
+	return err.key
+}
+
 type stoppedError struct {
 	error
 }
@@ -1,11 +1,16 @@
 package postgres_test
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"testing"
 
+	"github.com/OpenSlides/openslides-vote-service/audit"
 	"github.com/OpenSlides/openslides-vote-service/backend/postgres"
 	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"github.com/jackc/pgx/v5"
 	"github.com/ory/dockertest/v4"
 )
 
@@ -49,3 +54,263 @@ func TestImplementBackendInterface(t *testing.T) {
 
 	test.Backend(t, p)
 }
+
+func TestListenChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Postgres Test")
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	port := startPostgres(t)
+
+	addr := fmt.Sprintf(`user=postgres password='password' host=localhost port=%s dbname=database`, port)
+	p, err := postgres.New(ctx, addr)
+	if err != nil {
+		t.Fatalf("Creating postgres backend returned: %v", err)
+	}
+	defer p.Close()
+
+	p.Wait(ctx)
+	if err := p.Migrate(ctx); err != nil {
+		t.Fatalf("Creating db schema: %v", err)
+	}
+
+	ready := make(chan struct{})
+	received := make(chan vote.BackendChange)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.ListenChanges(
+			ctx,
+			func() { close(ready) },
+			func(change vote.BackendChange) { received <- change },
+		)
+	}()
+	<-ready
+
+	if err := p.Start(ctx, 1); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := p.Vote(ctx, 1, 5, []byte(`"Y"`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+
+	if _, _, err := p.Stop(ctx, 1); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if err := p.Clear(ctx, 1); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	expect := []vote.BackendChange{
+		{Type: vote.ChangeStart, PollID: 1},
+		{Type: vote.ChangeVote, PollID: 1, UserID: 5},
+		{Type: vote.ChangeStop, PollID: 1},
+		{Type: vote.ChangeClear, PollID: 1},
+	}
+
+	for _, e := range expect {
+		if got := <-received; !reflect.DeepEqual(got, e) {
+			t.Errorf("Got change %v, expected %v", got, e)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ListenChanges returned: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Postgres Test")
+	}
+
+	ctx := t.Context()
+	port := startPostgres(t)
+
+	addr := fmt.Sprintf(`user=postgres password='password' host=localhost port=%s dbname=database`, port)
+	p, err := postgres.New(ctx, addr)
+	if err != nil {
+		t.Fatalf("Creating postgres backend returned: %v", err)
+	}
+	defer p.Close()
+
+	p.Wait(ctx)
+
+	migrations, err := p.Migrations(ctx)
+	if err != nil {
+		t.Fatalf("Migrations before migrate: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.Applied {
+			t.Errorf("Migration %d is applied on an empty database", m.Version)
+		}
+	}
+
+	// Migrate a second time to check, that applied migrations are skipped.
+	for range 2 {
+		if err := p.Migrate(ctx); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	migrations, err = p.Migrations(ctx)
+	if err != nil {
+		t.Fatalf("Migrations after migrate: %v", err)
+	}
+
+	for _, m := range migrations {
+		if !m.Applied {
+			t.Errorf("Migration %d is not applied after migrate", m.Version)
+		}
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Postgres Test")
+	}
+
+	ctx := t.Context()
+	port := startPostgres(t)
+
+	addr := fmt.Sprintf(`user=postgres password='password' host=localhost port=%s dbname=database`, port)
+	p, err := postgres.New(ctx, addr)
+	if err != nil {
+		t.Fatalf("Creating postgres backend returned: %v", err)
+	}
+	defer p.Close()
+
+	p.Wait(ctx)
+	if err := p.Migrate(ctx); err != nil {
+		t.Fatalf("Creating db schema: %v", err)
+	}
+
+	other, err := p.WithSchema("vote_other")
+	if err != nil {
+		t.Fatalf("WithSchema: %v", err)
+	}
+	defer other.Close()
+
+	if err := other.Migrate(ctx); err != nil {
+		t.Fatalf("Creating other db schema: %v", err)
+	}
+
+	test.Backend(t, other)
+
+	t.Run("independent polls", func(t *testing.T) {
+		for _, b := range []*postgres.Backend{p, other} {
+			if err := b.Start(ctx, 100); err != nil {
+				t.Fatalf("Start in %s: %v", b, err)
+			}
+
+			if err := b.Vote(ctx, 100, 5, []byte(`"Y"`)); err != nil {
+				t.Fatalf("Vote in %s: %v", b, err)
+			}
+		}
+
+		if err := other.Clear(ctx, 100); err != nil {
+			t.Fatalf("Clear: %v", err)
+		}
+
+		ballots, _, err := p.Stop(ctx, 100)
+		if err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+
+		if len(ballots) != 1 {
+			t.Errorf("Got %d ballots, expected 1", len(ballots))
+		}
+	})
+
+	t.Run("close does not close the pool", func(t *testing.T) {
+		if err := other.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		if err := p.Ping(ctx); err != nil {
+			t.Errorf("Ping after closing the other backend: %v", err)
+		}
+	})
+}
+
+func TestWithSchemaInvalid(t *testing.T) {
+	p, err := postgres.New(t.Context(), "host=localhost")
+	if err != nil {
+		t.Fatalf("Creating postgres backend returned: %v", err)
+	}
+	defer p.Close()
+
+	for _, schema := range []string{"vote", "", "Vote", "vote; DROP", "vote-mirror"} {
+		if _, err := p.WithSchema(schema); err == nil {
+			t.Errorf("WithSchema(%q) did not return an error", schema)
+		}
+	}
+}
+
+func TestAuditSink(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Postgres Test")
+	}
+
+	ctx := t.Context()
+	port := startPostgres(t)
+
+	addr := fmt.Sprintf(`user=postgres password='password' host=localhost port=%s dbname=database`, port)
+	p, err := postgres.New(ctx, addr)
+	if err != nil {
+		t.Fatalf("Creating postgres backend returned: %v", err)
+	}
+	defer p.Close()
+
+	p.Wait(ctx)
+	if err := p.Migrate(ctx); err != nil {
+		t.Fatalf("Creating db schema: %v", err)
+	}
+
+	sink := p.AuditSink()
+	log := audit.New(sink)
+	log.Audit(ctx, "start", "internal/127.0.0.1", 1, 0, nil)
+	log.Audit(ctx, "stop", "internal/127.0.0.1", 1, 3, nil)
+
+	t.Run("survives clear all", func(t *testing.T) {
+		if err := p.ClearAll(ctx); err != nil {
+			t.Fatalf("ClearAll: %v", err)
+		}
+
+		log.Audit(ctx, "clear_all", "internal/127.0.0.1", 0, 0, nil)
+		log.Close()
+
+		count, err := audit.Verify(ctx, sink)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+
+		if count != 3 {
+			t.Errorf("Verify returned %d entries, expected 3", count)
+		}
+	})
+
+	t.Run("entries can not be changed", func(t *testing.T) {
+		conn, err := pgx.Connect(ctx, addr)
+		if err != nil {
+			t.Fatalf("connecting: %v", err)
+		}
+		defer conn.Close(ctx)
+
+		for _, sql := range []string{
+			`UPDATE vote_audit.log SET votes = 4 WHERE seq = 2;`,
+			`DELETE FROM vote_audit.log WHERE seq = 3;`,
+			`TRUNCATE vote_audit.log;`,
+		} {
+			if _, err := conn.Exec(ctx, sql); err == nil {
+				t.Errorf("%s: no error", sql)
+			}
+		}
+	})
+}
@@ -0,0 +1,374 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// clusterSlots is the number of hash slots of a redis cluster.
+	clusterSlots = 16384
+
+	// maxClusterRedirects is the number of redirects for one command, before
+	// an error is returned.
+	maxClusterRedirects = 5
+)
+
+// clusterConnector returns connections to the nodes of a redis cluster.
+//
+// It knows, which master node holds which hash slot. If a slot was moved, the
+// slot map is reloaded.
+type clusterConnector struct {
+	cfg Config
+
+	mu    sync.Mutex
+	pools map[string]*redis.Pool
+	slots [clusterSlots]string // slots holds the address of the master for each slot.
+}
+
+func newClusterConnector(cfg Config) (*clusterConnector, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, fmt.Errorf("cluster mode needs the address of at least one node")
+	}
+
+	return &clusterConnector{
+		cfg:   cfg,
+		pools: make(map[string]*redis.Pool),
+	}, nil
+}
+
+// pool returns the pool for the node with the given address.
+func (c *clusterConnector) pool(addr string) *redis.Pool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pool, ok := c.pools[addr]
+	if !ok {
+		pool = newPool(func() (redis.Conn, error) { return dial(addr, c.cfg.Username, c.cfg.Password) })
+		c.pools[addr] = pool
+	}
+	return pool
+}
+
+// close closes the pools of all nodes.
+func (c *clusterConnector) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for addr, pool := range c.pools {
+		if err := pool.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refresh loads the slot map from the first node, that answers.
+func (c *clusterConnector) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	addrs := append([]string{}, c.cfg.Addrs...)
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, addr := range addrs {
+		slots, err := c.loadSlots(ctx, addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", addr, err))
+			continue
+		}
+
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("loading cluster slots: %w", errors.Join(errs...))
+}
+
+func (c *clusterConnector) loadSlots(ctx context.Context, addr string) ([clusterSlots]string, error) {
+	var slots [clusterSlots]string
+
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return slots, fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	log.Debug("Redis: CLUSTER SLOTS")
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return slots, fmt.Errorf("getting slots: %w", err)
+	}
+
+	for _, rawRange := range ranges {
+		slotRange, err := redis.Values(rawRange, nil)
+		if err != nil || len(slotRange) < 3 {
+			return slots, fmt.Errorf("invalid slot range %v", rawRange)
+		}
+
+		start, err1 := redis.Int(slotRange[0], nil)
+		end, err2 := redis.Int(slotRange[1], nil)
+		master, err3 := redis.Values(slotRange[2], nil)
+		if err := errors.Join(err1, err2, err3); err != nil || len(master) < 2 || start < 0 || end >= clusterSlots {
+			return slots, fmt.Errorf("invalid slot range %v", rawRange)
+		}
+
+		host, err1 := redis.String(master[0], nil)
+		port, err2 := redis.Int(master[1], nil)
+		if err := errors.Join(err1, err2); err != nil {
+			return slots, fmt.Errorf("invalid master %v: %w", master, err)
+		}
+
+		// An empty host means the node, that was asked.
+		if host == "" {
+			host, _, _ = net.SplitHostPort(addr)
+		}
+
+		masterAddr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = masterAddr
+		}
+	}
+
+	return slots, nil
+}
+
+// nodeAddr returns the address of the master for a slot.
+func (c *clusterConnector) nodeAddr(ctx context.Context, slot int) (string, error) {
+	c.mu.Lock()
+	addr := c.slots[slot]
+	c.mu.Unlock()
+
+	if addr != "" {
+		return addr, nil
+	}
+
+	if err := c.refresh(ctx); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	addr = c.slots[slot]
+	c.mu.Unlock()
+
+	if addr == "" {
+		return "", fmt.Errorf("slot %d is not served by the cluster", slot)
+	}
+	return addr, nil
+}
+
+// masterAddrs returns the addresses of all master nodes.
+func (c *clusterConnector) masterAddrs(ctx context.Context) ([]string, error) {
+	if err := c.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+func (c *clusterConnector) get(ctx context.Context, key string) redis.Conn {
+	if key != "" {
+		return &clusterConn{cluster: c, ctx: ctx, slot: keySlot(key)}
+	}
+
+	// A connection without a key is used for commands like PING and SUBSCRIBE.
+	// It has to support redis.ConnWithContext for the pub/sub connection.
+	addr, err := c.nodeAddr(ctx, 0)
+	if err != nil {
+		return errorConn{err}
+	}
+
+	conn, _ := c.pool(addr).GetContext(ctx)
+	return conn
+}
+
+func (c *clusterConnector) masters(ctx context.Context) ([]redis.Conn, error) {
+	addrs, err := c.masterAddrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := make([]redis.Conn, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := c.pool(addr).GetContext(ctx)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// clusterConn is a redis.Conn for all keys of one hash slot.
+//
+// It follows MOVED and ASK redirects of the cluster. It only supports Do.
+type clusterConn struct {
+	cluster *clusterConnector
+	ctx     context.Context
+	slot    int
+
+	conn redis.Conn // conn is the connection to the master of the slot.
+}
+
+func (c *clusterConn) Do(cmd string, args ...any) (any, error) {
+	for range maxClusterRedirects {
+		if c.conn == nil {
+			addr, err := c.cluster.nodeAddr(c.ctx, c.slot)
+			if err != nil {
+				return nil, err
+			}
+
+			conn, err := c.cluster.pool(addr).GetContext(c.ctx)
+			if err != nil {
+				return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+			}
+			c.conn = conn
+		}
+
+		reply, err := c.conn.Do(cmd, args...)
+
+		var redisErr redis.Error
+		if !errors.As(err, &redisErr) {
+			return reply, err
+		}
+
+		kind, addr := parseRedirect(redisErr)
+		switch kind {
+		case "MOVED":
+			log.Debug("Redis: slot %d moved to %s", c.slot, addr)
+			c.conn.Close()
+			c.conn = nil
+			if err := c.cluster.refresh(c.ctx); err != nil {
+				return nil, err
+			}
+
+		case "ASK":
+			return c.ask(addr, cmd, args...)
+
+		case "TRYAGAIN", "CLUSTERDOWN":
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-c.ctx.Done():
+				return nil, c.ctx.Err()
+			}
+
+		default:
+			return reply, err
+		}
+	}
+
+	return nil, fmt.Errorf("too many cluster redirects for slot %d", c.slot)
+}
+
+// ask sends one command to the node, that imports the slot.
+func (c *clusterConn) ask(addr string, cmd string, args ...any) (any, error) {
+	conn, err := c.cluster.pool(addr).GetContext(c.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("ASKING"); err != nil {
+		return nil, fmt.Errorf("sending ASKING to %s: %w", addr, err)
+	}
+	return conn.Do(cmd, args...)
+}
+
+func (c *clusterConn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *clusterConn) Err() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Err()
+}
+
+func (c *clusterConn) Send(string, ...any) error {
+	return errors.New("send is not supported in cluster mode")
+}
+
+func (c *clusterConn) Flush() error {
+	return errors.New("flush is not supported in cluster mode")
+}
+
+func (c *clusterConn) Receive() (any, error) {
+	return nil, errors.New("receive is not supported in cluster mode")
+}
+
+// parseRedirect returns the kind of a cluster error and the address for
+// MOVED and ASK errors.
+//
+// For example `MOVED 3999 127.0.0.1:6381` returns "MOVED" and
+// "127.0.0.1:6381".
+func parseRedirect(err redis.Error) (kind string, addr string) {
+	fields := strings.Fields(string(err))
+	if len(fields) == 0 {
+		return "", ""
+	}
+
+	if (fields[0] == "MOVED" || fields[0] == "ASK") && len(fields) == 3 {
+		return fields[0], fields[2]
+	}
+	return fields[0], ""
+}
+
+// keySlot returns the hash slot of a key.
+//
+// If the key contains a hash tag like `{1}`, only the hash tag is used.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 implements CRC16-XMODEM, like it is used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
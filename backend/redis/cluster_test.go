@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestKeySlot(t *testing.T) {
+	for _, tt := range []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"{user1000}.following", keySlot("user1000")},
+	} {
+		if got := keySlot(tt.key); got != tt.slot {
+			t.Errorf("keySlot(%s) = %d, expected %d", tt.key, got, tt.slot)
+		}
+	}
+}
+
+func TestClusterPollKeysInOneSlot(t *testing.T) {
+	b := newBackend(nil, Config{Mode: ModeCluster})
+
+	slot := keySlot(b.pollKey(keyState, 42))
+	for _, format := range []string{keyVote, keyVoted, keyBallots, keyIdempotency} {
+		key := b.pollKey(format, 42)
+		if got := keySlot(key); got != slot {
+			t.Errorf("key %s has slot %d, expected %d", key, got, slot)
+		}
+	}
+
+	if got := b.pollKey(keyState, 42); got != "vote_state_{42}" {
+		t.Errorf("Got key %s, expected vote_state_{42}", got)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		err  string
+		kind string
+		addr string
+	}{
+		{"MOVED 3999 127.0.0.1:6381", "MOVED", "127.0.0.1:6381"},
+		{"ASK 3999 127.0.0.1:6381", "ASK", "127.0.0.1:6381"},
+		{"TRYAGAIN Multiple keys request during rehashing of slot", "TRYAGAIN", ""},
+		{"ERR unknown command", "ERR", ""},
+	} {
+		kind, addr := parseRedirect(redis.Error(tt.err))
+		if kind != tt.kind || addr != tt.addr {
+			t.Errorf("parseRedirect(%s) = %s, %s, expected %s, %s", tt.err, kind, addr, tt.kind, tt.addr)
+		}
+	}
+}
+
+func TestPollKeyPrefix(t *testing.T) {
+	b := newBackend(nil, Config{KeyPrefix: "os1:"})
+	if got := b.pollKey(keyState, 42); got != "os1:vote_state_42" {
+		t.Errorf("Got key %s, expected os1:vote_state_42", got)
+	}
+
+	b = newBackend(nil, Config{Mode: ModeCluster, KeyPrefix: "os1:"})
+	if got := b.pollKey(keyState, 42); got != "os1:vote_state_{42}" {
+		t.Errorf("Got key %s, expected os1:vote_state_{42}", got)
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	if got := escapeGlob(`a*b?[c]\`); got != `a\*b\?\[c\]\\` {
+		t.Errorf("Got %s", got)
+	}
+}
@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Mode is the way to connect to redis.
+type Mode string
+
+// The supported modes.
+const (
+	ModeStandalone Mode = "standalone"
+	ModeSentinel   Mode = "sentinel"
+	ModeCluster    Mode = "cluster"
+)
+
+// Config configures the connection to redis.
+type Config struct {
+	Mode Mode
+
+	// Addrs are the addresses of the redis server, of the sentinels or of some
+	// nodes of the cluster.
+	Addrs []string
+
+	// MasterName is the name of the master, that is monitored by the sentinels.
+	MasterName string
+
+	// Username and Password are used to authenticate on redis. Username is
+	// only used with a password. If it is empty, the default user is used.
+	Username string
+	Password string
+
+	// SentinelPassword is used to authenticate on the sentinels.
+	SentinelPassword string
+
+	// KeyPrefix is added to all keys and to the pub/sub channel. It is needed,
+	// when more then one OpenSlides instance uses the same redis.
+	KeyPrefix string
+}
+
+// connector returns connections to redis.
+type connector interface {
+	// get returns a connection to the node, that holds the key. An empty key
+	// returns a connection to any master node.
+	//
+	// Like redis.Pool.Get, errors are returned by the methods of the
+	// connection.
+	get(ctx context.Context, key string) redis.Conn
+
+	// masters returns a connection to each master node.
+	masters(ctx context.Context) ([]redis.Conn, error)
+
+	// close closes all connections.
+	close() error
+}
+
+func newPool(dial func() (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{
+		MaxActive:   100,
+		Wait:        true,
+		MaxIdle:     10,
+		IdleTimeout: 240 * time.Second,
+		Dial:        dial,
+	}
+}
+
+// dial connects to a redis server and authenticates, if a password is given.
+func dial(addr, username, password string) (redis.Conn, error) {
+	var options []redis.DialOption
+	if password != "" {
+		options = append(options, redis.DialPassword(password))
+		if username != "" {
+			options = append(options, redis.DialUsername(username))
+		}
+	}
+
+	return redis.Dial("tcp", addr, options...)
+}
+
+// poolConnector uses one pool for a standalone redis or for the master found
+// by the sentinels.
+type poolConnector struct {
+	pool *redis.Pool
+}
+
+func newStandaloneConnector(cfg Config) (*poolConnector, error) {
+	if len(cfg.Addrs) != 1 {
+		return nil, fmt.Errorf("standalone mode needs exactly one address, got %d", len(cfg.Addrs))
+	}
+
+	addr := cfg.Addrs[0]
+	return &poolConnector{
+		pool: newPool(func() (redis.Conn, error) { return dial(addr, cfg.Username, cfg.Password) }),
+	}, nil
+}
+
+// roleCheckInterval is the minimal time between two checks, if a connection
+// from the sentinel mode is still connected to the master.
+const roleCheckInterval = time.Second
+
+func newSentinelConnector(cfg Config) (*poolConnector, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, fmt.Errorf("sentinel mode needs the address of at least one sentinel")
+	}
+
+	if cfg.MasterName == "" {
+		return nil, fmt.Errorf("sentinel mode needs a master name")
+	}
+
+	pool := newPool(func() (redis.Conn, error) { return dialSentinelMaster(cfg) })
+
+	// After a failover, the old master becomes a replica. Its connections have
+	// to be closed, so new connections to the new master are created.
+	pool.TestOnBorrow = func(conn redis.Conn, lastUsed time.Time) error {
+		if time.Since(lastUsed) < roleCheckInterval {
+			return nil
+		}
+		return checkRole(conn, "master")
+	}
+
+	return &poolConnector{pool: pool}, nil
+}
+
+func (c *poolConnector) get(ctx context.Context, key string) redis.Conn {
+	// GetContext returns an errorConn on error.
+	conn, _ := c.pool.GetContext(ctx)
+	return conn
+}
+
+func (c *poolConnector) close() error {
+	return c.pool.Close()
+}
+
+func (c *poolConnector) masters(ctx context.Context) ([]redis.Conn, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []redis.Conn{conn}, nil
+}
+
+// dialSentinelMaster asks the sentinels for the address of the master and
+// connects to it.
+func dialSentinelMaster(cfg Config) (redis.Conn, error) {
+	var errs []error
+	for _, sentinelAddr := range cfg.Addrs {
+		addr, err := sentinelMasterAddr(sentinelAddr, cfg.MasterName, cfg.SentinelPassword)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sentinel %s: %w", sentinelAddr, err))
+			continue
+		}
+
+		conn, err := dial(addr, cfg.Username, cfg.Password)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("master %s from sentinel %s: %w", addr, sentinelAddr, err))
+			continue
+		}
+
+		// The sentinel could have an outdated view during a failover.
+		if err := checkRole(conn, "master"); err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("master %s from sentinel %s: %w", addr, sentinelAddr, err))
+			continue
+		}
+
+		log.Debug("Redis: connected to master %s from sentinel %s", addr, sentinelAddr)
+		return conn, nil
+	}
+
+	return nil, fmt.Errorf("no master found: %w", errors.Join(errs...))
+}
+
+func sentinelMasterAddr(sentinelAddr, masterName, password string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinelAddr, redis.DialPassword(password), redis.DialConnectTimeout(5*time.Second))
+	if err != nil {
+		return "", fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	hostPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", masterName))
+	if err != nil {
+		if err == redis.ErrNil {
+			return "", fmt.Errorf("unknown master %s", masterName)
+		}
+		return "", fmt.Errorf("getting master address: %w", err)
+	}
+
+	if len(hostPort) != 2 {
+		return "", fmt.Errorf("invalid master address %v", hostPort)
+	}
+
+	return net.JoinHostPort(hostPort[0], hostPort[1]), nil
+}
+
+// checkRole returns an error, if the redis server does not have the given
+// role.
+func checkRole(conn redis.Conn, role string) error {
+	values, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return fmt.Errorf("getting role: %w", err)
+	}
+
+	if len(values) == 0 {
+		return fmt.Errorf("empty role")
+	}
+
+	got, err := redis.String(values[0], nil)
+	if err != nil {
+		return fmt.Errorf("parsing role: %w", err)
+	}
+
+	if got != role {
+		return fmt.Errorf("server has role %s, expected %s", got, role)
+	}
+	return nil
+}
+
+// errorConn is a redis.Conn, that returns an error on each call.
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                   { return nil }
+func (c errorConn) Err() error                     { return c.err }
+func (c errorConn) Do(string, ...any) (any, error) { return nil, c.err }
+func (c errorConn) Send(string, ...any) error      { return c.err }
+func (c errorConn) Flush() error                   { return c.err }
+func (c errorConn) Receive() (any, error)          { return nil, c.err }
@@ -3,23 +3,55 @@
 // Is tries to save the votes as fast as possible. All necessary checkes are
 // done inside a lua-script so everything is done in one atomic step. It is
 // expected that there is no backup from the redis database. Everyone with
-// access to the redis database can see the vote results and how each user has
-// voted.
+// access to the redis database can see the vote results. For named polls, it is
+// also possible to see how each user has voted.
 //
-// It uses the keys `vote_state_X`, `vote_data_X` and `vote_polls` where X is a
-// pollID.
+// It uses the keys `vote_state_X`, `vote_data_X`, `vote_voted_X`,
+// `vote_ballots_X`, `vote_idempotency_X`, `vote_moved_X` and `vote_polls` where X
+// is a pollID.
 //
 // The key `vote_state_X` has type int. It is a number that tells the current
-// state of the poll. 1: Poll is started. 2: Poll is stopped.
+// state of the poll. 1: Named poll is started. 2: Poll is stopped. 3: Secret
+// poll is started.
 //
 // The key `vote_data_X` has type hash. The key is a user id and the value the
-// vote of the user.
+// vote of the user. It is only used for named polls.
+//
+// The key `vote_voted_X` has type set. It contains the user ids of all users,
+// that have voted on a secret poll.
+//
+// The key `vote_ballots_X` has type sorted set. It contains the votes of a
+// secret poll. The score is the number of users, that have sent this vote. The
+// order of this set does not depend on the order of the votes, so the votes can
+// not be linked to the users.
+//
+// The key `vote_idempotency_X` has type hash. The key is a user id and the value
+// the idempotency key that was send with the vote.
+//
+// The key `vote_moved_X` has type string. It is the name of the backend, the poll
+// is moved to. It only exists, while the poll is migrated.
 //
 // The key `vote_polls` has type set. It contains the pollIDs of all known polls.
+//
+// The keys `vote_rate_limit_X` are used for the rate limit of the http server.
+// X is the user or the ip of a client. They are removed automatically, when
+// they are not used.
+//
+// In cluster mode, the pollID in the keys is a hash tag like `vote_state_{X}`,
+// so all keys of a poll are in the same slot and can be used in one lua script.
+// The key `vote_polls` is not used in cluster mode. The polls are found by
+// scanning all master nodes for the state keys.
+//
+// After each change, a message is published on the channel `vote_changes`, so
+// all instances of the service can update their live votes.
+//
+// If a key prefix is configured, it is added to all keys and to the channel.
+// So more then one OpenSlides instance can use the same redis.
 package redis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -27,49 +59,107 @@ import (
 	"time"
 
 	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+	"github.com/OpenSlides/openslides-vote-service/vote"
 	"github.com/gomodule/redigo/redis"
 )
 
 const (
-	keyState = "vote_state_%d"
-	keyVote  = "vote_data_%d"
-	keyPolls = "vote_polls"
+	keyState       = "vote_state_%d"
+	keyVote        = "vote_data_%d"
+	keyVoted       = "vote_voted_%d"
+	keyBallots     = "vote_ballots_%d"
+	keyIdempotency = "vote_idempotency_%d"
+	keyMoved       = "vote_moved_%d"
+	keyPolls       = "vote_polls"
+	keyRateLimit   = "vote_rate_limit_%s"
+
+	channelChanges = "vote_changes"
 )
 
+var metricVoteScript = metrics.NewCounterVec("vote_redis_vote_script_total", "Results of the lua script to save a vote.", "result")
+
 // Backend is the vote-Backend.
 //
 // Has to be created with redis.New().
 type Backend struct {
-	pool *redis.Pool
-
-	luaScriptVote     *redis.Script
-	luaScriptClearAll *redis.Script
+	conns   connector
+	cluster bool
+	prefix  string
+
+	luaScriptVote      *redis.Script
+	luaScriptClearAll  *redis.Script
+	luaScriptTakeToken *redis.Script
+	luaScriptSetMoved  *redis.Script
 }
 
 // New creates an initializes Redis instance.
 func New(addr string) *Backend {
-	pool := redis.Pool{
-		MaxActive:   100,
-		Wait:        true,
-		MaxIdle:     10,
-		IdleTimeout: 240 * time.Second,
-		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+	cfg := Config{Addrs: []string{addr}}
+	conns, _ := newStandaloneConnector(cfg)
+	return newBackend(conns, cfg)
+}
+
+// NewWithConfig creates a Redis instance for a standalone redis, for redis
+// with sentinel or for a redis cluster.
+func NewWithConfig(cfg Config) (*Backend, error) {
+	switch cfg.Mode {
+	case ModeStandalone, "":
+		conns, err := newStandaloneConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newBackend(conns, cfg), nil
+
+	case ModeSentinel:
+		conns, err := newSentinelConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newBackend(conns, cfg), nil
+
+	case ModeCluster:
+		conns, err := newClusterConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newBackend(conns, cfg), nil
+
+	default:
+		return nil, fmt.Errorf("unknown redis mode %s", cfg.Mode)
 	}
+}
 
+func newBackend(conns connector, cfg Config) *Backend {
 	return &Backend{
-		pool: &pool,
+		conns:   conns,
+		cluster: cfg.Mode == ModeCluster,
+		prefix:  cfg.KeyPrefix,
+
+		luaScriptVote:      redis.NewScript(5, luaVoteScript),
+		luaScriptClearAll:  redis.NewScript(1, luaClearAll),
+		luaScriptTakeToken: redis.NewScript(1, luaTakeToken),
+		luaScriptSetMoved:  redis.NewScript(2, luaSetMoved),
+	}
+}
 
-		luaScriptVote:     redis.NewScript(2, luaVoteScript),
-		luaScriptClearAll: redis.NewScript(1, luaClearAll),
+// pollKey returns the key of a poll. In cluster mode, the pollID is a hash tag.
+func (b *Backend) pollKey(format string, pollID int) string {
+	if b.cluster {
+		return b.prefix + fmt.Sprintf(strings.Replace(format, "%d", "{%d}", 1), pollID)
 	}
+	return b.prefix + fmt.Sprintf(format, pollID)
+}
+
+// key returns a key or channel name with the prefix.
+func (b *Backend) key(name string) string {
+	return b.prefix + name
 }
 
 // Wait blocks until a connection to redis can be established.
 func (b *Backend) Wait(ctx context.Context) {
 	for ctx.Err() == nil {
-		conn := b.pool.Get()
-		_, err := conn.Do("PING")
-		conn.Close()
+		err := b.Ping(ctx)
 		if err == nil {
 			return
 		}
@@ -78,26 +168,67 @@ func (b *Backend) Wait(ctx context.Context) {
 	}
 }
 
+// Close closes all connections to redis.
+func (b *Backend) Close() error {
+	if err := b.conns.close(); err != nil {
+		return fmt.Errorf("closing redis connections: %w", err)
+	}
+	return nil
+}
+
+// Ping checks, if redis is reachable.
+func (b *Backend) Ping(ctx context.Context) error {
+	conn := b.conns.get(ctx, "")
+	defer conn.Close()
+
+	if _, err := conn.Do("PING"); err != nil {
+		return fmt.Errorf("sending ping: %w", err)
+	}
+	return nil
+}
+
 func (b *Backend) String() string {
 	return "redis"
 }
 
-// Start starts the poll.
+// Start starts a secret poll.
+//
+// The votes of the poll can not be linked to the users.
 func (b *Backend) Start(ctx context.Context, pollID int) error {
-	conn := b.pool.Get()
-	defer conn.Close()
+	return b.start(ctx, pollID, stateSecret)
+}
 
-	sKey := fmt.Sprintf(keyState, pollID)
+// StartNamed starts a named poll.
+//
+// The votes of the poll are saved for each user.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) error {
+	return b.start(ctx, pollID, stateNamed)
+}
 
-	log.Debug("Redis: SETNX %s 1", sKey)
-	if _, err := conn.Do("SETNX", sKey, 1); err != nil {
-		return fmt.Errorf("set state key to 1: %w", err)
+const (
+	stateNamed  = 1
+	stateSecret = 3
+)
+
+func (b *Backend) start(ctx context.Context, pollID int, state int) error {
+	sKey := b.pollKey(keyState, pollID)
+
+	conn := b.conns.get(ctx, sKey)
+	defer conn.Close()
+
+	log.Debug("Redis: SETNX %s %d", sKey, state)
+	if _, err := conn.Do("SETNX", sKey, state); err != nil {
+		return fmt.Errorf("set state key to %d: %w", state, err)
 	}
 
-	log.Debug("Redis: SADD %s %d", keyPolls, pollID)
-	if _, err := conn.Do("SADD", keyPolls, pollID); err != nil {
-		return fmt.Errorf("add poll ID to %s: %w", keyPolls, err)
+	if !b.cluster {
+		log.Debug("Redis: SADD %s %d", b.key(keyPolls), pollID)
+		if _, err := conn.Do("SADD", b.key(keyPolls), pollID); err != nil {
+			return fmt.Errorf("add poll ID to %s: %w", b.key(keyPolls), err)
+		}
 	}
+
+	b.publish(conn, vote.BackendChange{Type: vote.ChangeStart, PollID: pollID})
 	return nil
 }
 
@@ -105,55 +236,108 @@ func (b *Backend) Start(ctx context.Context, pollID int) error {
 //
 // KEYS[1] == state key
 // KEYS[2] == vote data
+// KEYS[3] == idempotency keys
+// KEYS[4] == voted users
+// KEYS[5] == ballots
 // ARGV[1] == userID
 // ARGV[2] == Vote object
+// ARGV[3] == idempotency key or an empty string
 //
-// Returns 0 on success
-// Returns 1 if the poll is not started.
-// Returns 2 if the poll was stopped.
-// Returns 3 if the user has already voted.
+// Returns {0} on success for a named poll.
+// Returns {0, 1} on success for a secret poll.
+// Returns {1} if the poll is not started.
+// Returns {2} if the poll was stopped.
+// Returns {3, idempotency key} if the user has already voted.
 const luaVoteScript = `
 local state = redis.call("GET",KEYS[1])
 if state == false then
-	return 1
+	return {1}
 end
 
 if state == "2" then
-	return 2
+	return {2}
+end
+
+local saved
+if state == "3" then
+	saved = redis.call("SADD",KEYS[4],ARGV[1])
+else
+	saved = redis.call("HSETNX",KEYS[2],ARGV[1],ARGV[2])
 end
 
-local saved = redis.call("HSETNX",KEYS[2],ARGV[1],ARGV[2])
 if saved == 0 then
-	return 3
+	return {3, redis.call("HGET",KEYS[3],ARGV[1])}
+end
+
+if state == "3" then
+	redis.call("ZINCRBY",KEYS[5],1,ARGV[2])
+end
+
+if ARGV[3] ~= "" then
+	redis.call("HSET",KEYS[3],ARGV[1],ARGV[3])
 end
 
-return 0`
+if state == "3" then
+	return {0, 1}
+end
+return {0}`
 
 // Vote saves a vote in redis.
 //
 // It also checks, that the user did not vote before and that the poll is open.
 func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byte) error {
-	conn := b.pool.Get()
-	defer conn.Close()
+	return b.vote(ctx, pollID, userID, object, "")
+}
+
+// VoteIdempotent saves a vote together with an idempotency key.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) error {
+	return b.vote(ctx, pollID, userID, object, key)
+}
+
+func (b *Backend) vote(ctx context.Context, pollID int, userID int, object []byte, key string) error {
+	vKey := b.pollKey(keyVote, pollID)
+	sKey := b.pollKey(keyState, pollID)
+	iKey := b.pollKey(keyIdempotency, pollID)
+	votedKey := b.pollKey(keyVoted, pollID)
+	bKey := b.pollKey(keyBallots, pollID)
 
-	vKey := fmt.Sprintf(keyVote, pollID)
-	sKey := fmt.Sprintf(keyState, pollID)
+	conn := b.conns.get(ctx, sKey)
+	defer conn.Close()
 
-	log.Debug("Redis: lua script vote: '%s' 2 %s %s [userID] [vote]", luaVoteScript, sKey, vKey)
-	result, err := redis.Int(b.luaScriptVote.Do(conn, sKey, vKey, userID, object))
+	log.Debug("Redis: lua script vote: '%s' 5 %s %s %s %s %s [userID] [vote] [key]", luaVoteScript, sKey, vKey, iKey, votedKey, bKey)
+	values, err := redis.Values(b.luaScriptVote.Do(conn, sKey, vKey, iKey, votedKey, bKey, userID, object, key))
 	if err != nil {
 		return fmt.Errorf("executing luaVoteScript: %w", err)
 	}
 
+	result, err := redis.Int(values[0], nil)
+	if err != nil {
+		return fmt.Errorf("parsing result of luaVoteScript: %w", err)
+	}
+
 	log.Debug("Redis: Returned %d", result)
 	switch result {
 	case 1:
+		metricVoteScript.Inc("not_started")
 		return doesNotExistError{fmt.Errorf("poll is not started")}
 	case 2:
+		metricVoteScript.Inc("stopped")
 		return stoppedError{fmt.Errorf("poll is stopped")}
 	case 3:
-		return doubleVoteError{fmt.Errorf("user has voted")}
+		metricVoteScript.Inc("double_vote")
+		var savedKey string
+		if len(values) > 1 {
+			savedKey, _ = redis.String(values[1], nil)
+		}
+		return doubleVoteError{fmt.Errorf("user has voted"), savedKey}
 	default:
+		metricVoteScript.Inc("saved")
+		change := vote.BackendChange{Type: vote.ChangeVote, PollID: pollID, UserID: userID, Vote: object}
+		if len(values) > 1 {
+			// The vote of a secret poll must not be linked to the user.
+			change.Vote = nil
+		}
+		b.publish(conn, change)
 		return nil
 	}
 }
@@ -162,11 +346,11 @@ func (b *Backend) Vote(ctx context.Context, pollID int, userID int, object []byt
 //
 // It returns all vote objects.
 func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error) {
-	conn := b.pool.Get()
-	defer conn.Close()
+	vKey := b.pollKey(keyVote, pollID)
+	sKey := b.pollKey(keyState, pollID)
 
-	vKey := fmt.Sprintf(keyVote, pollID)
-	sKey := fmt.Sprintf(keyState, pollID)
+	conn := b.conns.get(ctx, sKey)
+	defer conn.Close()
 
 	log.Debug("SET %s 2 XX", sKey)
 	_, err := redis.String(conn.Do("SET", sKey, "2", "XX"))
@@ -177,6 +361,8 @@ func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error)
 		return nil, nil, fmt.Errorf("set key %s to 2: %w", sKey, err)
 	}
 
+	b.publish(conn, vote.BackendChange{Type: vote.ChangeStop, PollID: pollID})
+
 	log.Debug("REDIS: HGETALL %s", vKey)
 	data, err := redis.StringMap(conn.Do("HGETALL", vKey))
 	if err != nil {
@@ -194,28 +380,66 @@ func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error)
 		voteObjects = append(voteObjects, []byte(vote))
 	}
 
+	votedKey := b.pollKey(keyVoted, pollID)
+	log.Debug("REDIS: SMEMBERS %s", votedKey)
+	voted, err := redis.Ints(conn.Do("SMEMBERS", votedKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting voted users from %s: %w", votedKey, err)
+	}
+	userIDs = append(userIDs, voted...)
+
+	bKey := b.pollKey(keyBallots, pollID)
+	log.Debug("REDIS: ZRANGE %s 0 -1 WITHSCORES", bKey)
+	ballots, err := redis.Values(conn.Do("ZRANGE", bKey, 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting vote objects from %s: %w", bKey, err)
+	}
+
+	for i := 0; i+1 < len(ballots); i += 2 {
+		ballot, err := redis.Bytes(ballots[i], nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid vote object in %s: %w", bKey, err)
+		}
+
+		count, err := redis.Int(ballots[i+1], nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid count in %s: %w", bKey, err)
+		}
+
+		for range count {
+			voteObjects = append(voteObjects, ballot)
+		}
+	}
+
 	sort.Ints(userIDs)
 	return voteObjects, userIDs, nil
 }
 
 // Clear delete all information from a poll.
 func (b *Backend) Clear(ctx context.Context, pollID int) error {
-	conn := b.pool.Get()
+	vKey := b.pollKey(keyVote, pollID)
+	sKey := b.pollKey(keyState, pollID)
+	iKey := b.pollKey(keyIdempotency, pollID)
+	votedKey := b.pollKey(keyVoted, pollID)
+	bKey := b.pollKey(keyBallots, pollID)
+	mKey := b.pollKey(keyMoved, pollID)
+
+	conn := b.conns.get(ctx, sKey)
 	defer conn.Close()
 
-	vKey := fmt.Sprintf(keyVote, pollID)
-	sKey := fmt.Sprintf(keyState, pollID)
-
-	log.Debug("REDIS: DEL %s %s", vKey, sKey)
-	if _, err := conn.Do("DEL", vKey, sKey); err != nil {
+	log.Debug("REDIS: DEL %s %s %s %s %s %s", vKey, sKey, iKey, votedKey, bKey, mKey)
+	if _, err := conn.Do("DEL", vKey, sKey, iKey, votedKey, bKey, mKey); err != nil {
 		return fmt.Errorf("removing keys: %w", err)
 	}
 
-	log.Debug("REDIS: SREM %s %d", keyPolls, pollID)
-	if _, err := conn.Do("SREM", keyPolls, pollID); err != nil {
-		return fmt.Errorf("remove pollID from %s: %w", keyPolls, err)
+	if !b.cluster {
+		log.Debug("REDIS: SREM %s %d", b.key(keyPolls), pollID)
+		if _, err := conn.Do("SREM", b.key(keyPolls), pollID); err != nil {
+			return fmt.Errorf("remove pollID from %s: %w", b.key(keyPolls), err)
+		}
 	}
 
+	b.publish(conn, vote.BackendChange{Type: vote.ChangeClear, PollID: pollID})
 	return nil
 }
 
@@ -225,65 +449,397 @@ func (b *Backend) Clear(ctx context.Context, pollID int) error {
 //
 // ARGV[1] == state key pattern
 // ARGV[2] == vote data pattern
+// ARGV[3] == idempotency key pattern
+// ARGV[4] == voted users pattern
+// ARGV[5] == ballots pattern
+// ARGV[6] == moved pattern
 const luaClearAll = `
 for _, pollID in ipairs(redis.call("SMEMBERS",KEYS[1])) do
 	redis.call("DEL", ARGV[1]..pollID)
 	redis.call("DEL", ARGV[2]..pollID)
+	redis.call("DEL", ARGV[3]..pollID)
+	redis.call("DEL", ARGV[4]..pollID)
+	redis.call("DEL", ARGV[5]..pollID)
+	redis.call("DEL", ARGV[6]..pollID)
 end
 redis.call("DEL", KEYS[1])
 `
 
 // ClearAll removes all data from all polls.
 func (b *Backend) ClearAll(ctx context.Context) error {
-	conn := b.pool.Get()
+	if b.cluster {
+		return b.clearAllCluster(ctx)
+	}
+
+	pollsKey := b.key(keyPolls)
+	conn := b.conns.get(ctx, pollsKey)
 	defer conn.Close()
 
-	voteKeyPattern := strings.ReplaceAll(keyVote, "%d", "")
-	stateKeyPattern := strings.ReplaceAll(keyState, "%d", "")
+	voteKeyPattern := b.key(strings.ReplaceAll(keyVote, "%d", ""))
+	stateKeyPattern := b.key(strings.ReplaceAll(keyState, "%d", ""))
+	idempotencyKeyPattern := b.key(strings.ReplaceAll(keyIdempotency, "%d", ""))
+	votedKeyPattern := b.key(strings.ReplaceAll(keyVoted, "%d", ""))
+	ballotsKeyPattern := b.key(strings.ReplaceAll(keyBallots, "%d", ""))
+	movedKeyPattern := b.key(strings.ReplaceAll(keyMoved, "%d", ""))
 
-	log.Debug("Redis: lua script clear all: '%s' 2 %s %s", luaClearAll, voteKeyPattern, stateKeyPattern)
-	if _, err := b.luaScriptClearAll.Do(conn, keyPolls, voteKeyPattern, stateKeyPattern); err != nil {
+	log.Debug("Redis: lua script clear all: '%s' 1 %s %s %s %s %s %s %s", luaClearAll, pollsKey, voteKeyPattern, stateKeyPattern, idempotencyKeyPattern, votedKeyPattern, ballotsKeyPattern, movedKeyPattern)
+	if _, err := b.luaScriptClearAll.Do(conn, pollsKey, voteKeyPattern, stateKeyPattern, idempotencyKeyPattern, votedKeyPattern, ballotsKeyPattern, movedKeyPattern); err != nil {
 		return fmt.Errorf("removing keys: %w", err)
 	}
 
+	b.publish(conn, vote.BackendChange{Type: vote.ChangeClearAll})
 	return nil
 }
 
-// LiveVotes returns all votes from each user. Returns nil on non named votes.
+// luaSetMoved sets or removes the mark of a poll, that is moved to another
+// backend. It returns 1, if the poll does not exist.
 //
-// This command is not atomic.
-func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
-	conn := b.pool.Get()
+// KEYS[1] == state key
+// KEYS[2] == moved key
+//
+// ARGV[1] == name of the backend or an empty string
+const luaSetMoved = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 1
+end
+
+if ARGV[1] == "" then
+	redis.call("DEL", KEYS[2])
+else
+	redis.call("SET", KEYS[2], ARGV[1])
+end
+return 0
+`
+
+// SetMoved marks a poll as moved to another backend.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	sKey := b.pollKey(keyState, pollID)
+	mKey := b.pollKey(keyMoved, pollID)
+
+	conn := b.conns.get(ctx, sKey)
 	defer conn.Close()
 
-	log.Debug("REDIS: SMEMBERS %s", keyPolls)
-	pollIDs, err := redis.Ints(conn.Do("SMEMBERS", keyPolls))
+	log.Debug("Redis: lua script set moved: '%s' 2 %s %s %s", luaSetMoved, sKey, mKey, backend)
+	result, err := redis.Int(b.luaScriptSetMoved.Do(conn, sKey, mKey, backend))
+	if err != nil {
+		return fmt.Errorf("setting moved key: %w", err)
+	}
+
+	if result == 1 {
+		return doesNotExistError{fmt.Errorf("Poll does not exist")}
+	}
+	return nil
+}
+
+// Moved returns the new backend of all marked polls.
+//
+// This command is not atomic.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	pollIDs, err := b.pollIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting all known pollIDs: %w", err)
+	}
+
+	out := make(map[int]string)
+	for _, pollID := range pollIDs {
+		mKey := b.pollKey(keyMoved, pollID)
+		conn := b.conns.get(ctx, mKey)
+
+		log.Debug("REDIS: GET %s", mKey)
+		backend, err := redis.String(conn.Do("GET", mKey))
+		conn.Close()
+		if err != nil {
+			if err == redis.ErrNil {
+				continue
+			}
+			return nil, fmt.Errorf("getting %s: %w", mKey, err)
+		}
+		out[pollID] = backend
+	}
+	return out, nil
+}
+
+// publish sends a change to all instances.
+//
+// The change is already saved, so an error is only logged. The other instances
+// get the change with their next full reload.
+func (b *Backend) publish(conn redis.Conn, change vote.BackendChange) {
+	message, err := json.Marshal(change)
+	if err != nil {
+		log.Error("encoding change: %v", err)
+		return
+	}
+
+	log.Debug("REDIS: PUBLISH %s %s", b.key(channelChanges), log.Redacted(message))
+	if _, err := conn.Do("PUBLISH", b.key(channelChanges), message); err != nil {
+		log.Error("publishing change: %v", err)
+	}
+}
+
+// ListenChanges calls change for each change of any instance.
+//
+// It blocks until the context is done or the connection to redis fails.
+func (b *Backend) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	conn := b.conns.get(ctx, "")
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return fmt.Errorf("getting connection: %w", err)
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	channel := b.key(channelChanges)
+	log.Debug("REDIS: SUBSCRIBE %s", channel)
+	if err := psc.Subscribe(channel); err != nil {
+		return fmt.Errorf("subscribing to %s: %w", channel, err)
+	}
+
+	for {
+		switch msg := psc.ReceiveContext(ctx).(type) {
+		case redis.Subscription:
+			if msg.Kind == "subscribe" {
+				ready()
+			}
+
+		case redis.Message:
+			var c vote.BackendChange
+			if err := json.Unmarshal(msg.Data, &c); err != nil {
+				log.Error("decoding change %s: %v", log.Redacted(msg.Data), err)
+				continue
+			}
+			change(c)
+
+		case error:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receiving change: %w", msg)
+		}
+	}
+}
+
+// LiveVotes returns all votes from each user. Returns nil on secret polls.
+//
+// This command is not atomic.
+func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
+	pollIDs, err := b.pollIDs(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("getting all known pollIDs: %w", err)
 	}
 
 	out := make(map[int]map[int][]byte, len(pollIDs))
 	for _, pollID := range pollIDs {
-		vKey := fmt.Sprintf(keyVote, pollID)
+		vKey := b.pollKey(keyVote, pollID)
+		if err := b.pollLiveVotes(ctx, pollID, vKey, out); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
 
-		log.Debug("REDIS: HGETALL %s", vKey)
-		data, err := redis.StringMap(conn.Do("HGETALL", vKey))
+// pollLiveVotes adds the votes of one poll to out.
+func (b *Backend) pollLiveVotes(ctx context.Context, pollID int, vKey string, out map[int]map[int][]byte) error {
+	conn := b.conns.get(ctx, vKey)
+	defer conn.Close()
+
+	log.Debug("REDIS: HGETALL %s", vKey)
+	data, err := redis.StringMap(conn.Do("HGETALL", vKey))
+	if err != nil {
+		return fmt.Errorf("getting vote objects from %s: %w", vKey, err)
+	}
+
+	out[pollID] = make(map[int][]byte, len(data))
+
+	for uidString, vote := range data {
+		userID, err := strconv.Atoi(uidString)
 		if err != nil {
-			return nil, fmt.Errorf("getting vote objects from %s: %w", vKey, err)
+			return fmt.Errorf("invalid userID %s: %w", uidString, err)
 		}
+		out[pollID][userID] = []byte(vote)
+	}
+
+	votedKey := b.pollKey(keyVoted, pollID)
+	log.Debug("REDIS: SMEMBERS %s", votedKey)
+	voted, err := redis.Ints(conn.Do("SMEMBERS", votedKey))
+	if err != nil {
+		return fmt.Errorf("getting voted users from %s: %w", votedKey, err)
+	}
+
+	for _, userID := range voted {
+		out[pollID][userID] = nil
+	}
+
+	return nil
+}
 
-		out[pollID] = make(map[int][]byte, len(data))
+// pollIDs returns the ids of all known polls.
+func (b *Backend) pollIDs(ctx context.Context) ([]int, error) {
+	if !b.cluster {
+		pollsKey := b.key(keyPolls)
+		conn := b.conns.get(ctx, pollsKey)
+		defer conn.Close()
 
-		for uidString, vote := range data {
-			userID, err := strconv.Atoi(uidString)
+		log.Debug("REDIS: SMEMBERS %s", pollsKey)
+		return redis.Ints(conn.Do("SMEMBERS", pollsKey))
+	}
+
+	statePrefix, stateSuffix, _ := strings.Cut(keyState, "%d")
+	statePrefix = b.key(statePrefix) + "{"
+	stateSuffix = "}" + stateSuffix
+	keys, err := b.scanMasters(ctx, escapeGlob(statePrefix)+"*"+escapeGlob(stateSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	pollIDs := make([]int, 0, len(keys))
+	for _, key := range keys {
+		pollID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(key, statePrefix), stateSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid state key %s: %w", key, err)
+		}
+		pollIDs = append(pollIDs, pollID)
+	}
+	return pollIDs, nil
+}
+
+// escapeGlob escapes the special characters of a redis glob pattern.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// scanMasters returns all keys from all master nodes, that match the pattern.
+func (b *Backend) scanMasters(ctx context.Context, pattern string) ([]string, error) {
+	conns, err := b.conns.masters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to master nodes: %w", err)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	var keys []string
+	for _, conn := range conns {
+		cursor := 0
+		for {
+			log.Debug("REDIS: SCAN %d MATCH %s COUNT 1000", cursor, pattern)
+			values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
 			if err != nil {
-				return nil, fmt.Errorf("invalid userID %s: %w", uidString, err)
+				return nil, fmt.Errorf("scanning keys: %w", err)
+			}
+
+			var found []string
+			if _, err := redis.Scan(values, &cursor, &found); err != nil {
+				return nil, fmt.Errorf("parsing scan result: %w", err)
+			}
+			keys = append(keys, found...)
+
+			if cursor == 0 {
+				break
 			}
-			out[pollID][userID] = []byte(vote)
 		}
 	}
+	return keys, nil
+}
+
+// clearAllCluster is like ClearAll in cluster mode. It removes the keys of all
+// polls, that are found on the master nodes.
+func (b *Backend) clearAllCluster(ctx context.Context) error {
+	pollIDs, err := b.pollIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("getting all known pollIDs: %w", err)
+	}
 
-	return out, nil
+	for _, pollID := range pollIDs {
+		keys := []any{
+			b.pollKey(keyVote, pollID),
+			b.pollKey(keyState, pollID),
+			b.pollKey(keyIdempotency, pollID),
+			b.pollKey(keyVoted, pollID),
+			b.pollKey(keyBallots, pollID),
+			b.pollKey(keyMoved, pollID),
+		}
+
+		conn := b.conns.get(ctx, b.pollKey(keyState, pollID))
+		log.Debug("REDIS: DEL %v", keys)
+		_, err := conn.Do("DEL", keys...)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("removing keys of poll %d: %w", pollID, err)
+		}
+	}
+
+	conn := b.conns.get(ctx, "")
+	defer conn.Close()
+
+	b.publish(conn, vote.BackendChange{Type: vote.ChangeClearAll})
+	return nil
+}
+
+// luaTakeToken takes a token from a token bucket.
+//
+// KEYS[1] == bucket key
+//
+// ARGV[1] == tokens per second
+// ARGV[2] == maximum tokens
+//
+// Returns 0 if a token was taken. Else the milliseconds until the next token is
+// available.
+const luaTakeToken = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens = burst
+	last = now
+end
+
+tokens = math.min(burst, tokens + (now - last) * rate / 1000)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait`
+
+// TakeToken takes a token from the token bucket with the given key.
+//
+// It is used for the rate limit of the http server, so all instances share
+// the same limits.
+func (b *Backend) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	bKey := b.key(fmt.Sprintf(keyRateLimit, key))
+
+	conn := b.conns.get(ctx, bKey)
+	defer conn.Close()
+
+	log.Debug("Redis: lua script take token: '%s' 1 %s %f %d", luaTakeToken, bKey, rate, burst)
+	wait, err := redis.Int64(b.luaScriptTakeToken.Do(conn, bKey, rate, burst))
+	if err != nil {
+		return 0, fmt.Errorf("executing luaTakeToken: %w", err)
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
 }
 
 type doesNotExistError struct {
@@ -294,10 +850,15 @@ func (doesNotExistError) DoesNotExist() {}
 
 type doubleVoteError struct {
 	error
+	key string
 }
 
 func (doubleVoteError) DoubleVote() {}
 
+func (err doubleVoteError) IdempotencyKey() string {
+	return err.key
+}
+
 type stoppedError struct {
 	error
 }
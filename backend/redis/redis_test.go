@@ -1,11 +1,17 @@
 package redis_test
 
 import (
+	"bytes"
 	"context"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/OpenSlides/openslides-vote-service/backend/redis"
 	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/ory/dockertest/v4"
 )
 
@@ -34,3 +40,252 @@ func TestImplementBackendInterface(t *testing.T) {
 
 	test.Backend(t, r)
 }
+
+func TestACL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Redis Test")
+	}
+
+	pool := dockertest.NewPoolT(t, "")
+	server := pool.RunT(t, "redis",
+		dockertest.WithTag("6.2"),
+		dockertest.WithoutReuse(),
+		dockertest.WithCmd([]string{"redis-server", "--user", "default", "off", "--user", "vote", "on", ">secret", "~*", "&*", "+@all"}),
+	)
+	addr := "localhost:" + server.GetPort("6379/tcp")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r, err := redis.NewWithConfig(redis.Config{
+		Mode:     redis.ModeStandalone,
+		Addrs:    []string{addr},
+		Username: "vote",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewWithConfig: %v", err)
+	}
+	r.Wait(ctx)
+
+	test.Backend(t, r)
+
+	t.Run("wrong password", func(t *testing.T) {
+		r, err := redis.NewWithConfig(redis.Config{
+			Mode:     redis.ModeStandalone,
+			Addrs:    []string{addr},
+			Username: "vote",
+			Password: "wrong",
+		})
+		if err != nil {
+			t.Fatalf("NewWithConfig: %v", err)
+		}
+
+		if err := r.Start(ctx, 1); err == nil {
+			t.Errorf("Start with a wrong password did not return an error")
+		}
+	})
+}
+
+func TestKeyPrefix(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Redis Test")
+	}
+
+	ctx := context.Background()
+	addr := "localhost:" + startRedis(t)
+
+	newBackend := func(prefix string) *redis.Backend {
+		r, err := redis.NewWithConfig(redis.Config{Addrs: []string{addr}, KeyPrefix: prefix})
+		if err != nil {
+			t.Fatalf("NewWithConfig: %v", err)
+		}
+		r.Wait(ctx)
+		return r
+	}
+
+	r1 := newBackend("os1:")
+	r2 := newBackend("os2:")
+
+	t.Run("Shared test", func(t *testing.T) {
+		test.Backend(t, newBackend("os3:"))
+	})
+
+	for _, r := range []*redis.Backend{r1, r2} {
+		if err := r.StartNamed(ctx, 1); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+	}
+
+	if err := r1.Vote(ctx, 1, 5, []byte(`"Y"`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+
+	if err := r2.Vote(ctx, 1, 5, []byte(`"N"`)); err != nil {
+		t.Errorf("Vote with the same user on the other prefix: %v", err)
+	}
+
+	if err := r2.ClearAll(ctx); err != nil {
+		t.Fatalf("ClearAll: %v", err)
+	}
+
+	liveVotes, err := r2.LiveVotes(ctx)
+	if err != nil {
+		t.Fatalf("LiveVotes: %v", err)
+	}
+
+	if len(liveVotes) != 0 {
+		t.Errorf("Got live votes %v after ClearAll, expected none", liveVotes)
+	}
+
+	objects, userIDs, err := r1.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if len(objects) != 1 || string(objects[0]) != `"Y"` || !slices.Equal(userIDs, []int{5}) {
+		t.Errorf("Got votes %s from users %v, expected [\"Y\"] from [5]", objects, userIDs)
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Redis Test")
+	}
+
+	ctx := context.Background()
+	port := startRedis(t)
+
+	r := redis.New("localhost:" + port)
+	r.Wait(ctx)
+
+	for i := range 2 {
+		wait, err := r.TakeToken(ctx, "user:1", 1, 2)
+		if err != nil {
+			t.Fatalf("TakeToken: %v", err)
+		}
+
+		if wait != 0 {
+			t.Fatalf("TakeToken %d returned wait %s, expected 0", i, wait)
+		}
+	}
+
+	wait, err := r.TakeToken(ctx, "user:1", 1, 2)
+	if err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("TakeToken on empty bucket returned wait %s, expected between 0 and 1s", wait)
+	}
+}
+
+func TestListenChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Redis Test")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := startRedis(t)
+
+	r := redis.New("localhost:" + port)
+	r.Wait(ctx)
+
+	ready := make(chan struct{})
+	received := make(chan vote.BackendChange)
+	done := make(chan error, 1)
+	go func() {
+		done <- r.ListenChanges(
+			ctx,
+			func() { close(ready) },
+			func(change vote.BackendChange) { received <- change },
+		)
+	}()
+	<-ready
+
+	if err := r.Start(ctx, 1); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := r.Vote(ctx, 1, 5, []byte(`"Y"`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+
+	if _, _, err := r.Stop(ctx, 1); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if err := r.Clear(ctx, 1); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	expect := []vote.BackendChange{
+		{Type: vote.ChangeStart, PollID: 1},
+		{Type: vote.ChangeVote, PollID: 1, UserID: 5, Vote: []byte(`"Y"`)},
+		{Type: vote.ChangeStop, PollID: 1},
+		{Type: vote.ChangeClear, PollID: 1},
+	}
+
+	for _, e := range expect {
+		if got := <-received; !reflect.DeepEqual(got, e) {
+			t.Errorf("Got change %v, expected %v", got, e)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ListenChanges returned: %v", err)
+	}
+}
+
+func TestSecretPoll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip Redis Test")
+	}
+
+	ctx := context.Background()
+	port := startRedis(t)
+
+	r := redis.New("localhost:" + port)
+	r.Wait(ctx)
+
+	if err := r.Start(ctx, 1); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	for userID, ballot := range map[int]string{5: `"A"`, 6: `"A"`, 7: `"B"`} {
+		if err := r.Vote(ctx, 1, userID, []byte(ballot)); err != nil {
+			t.Fatalf("Vote of user %d: %v", userID, err)
+		}
+	}
+
+	conn, err := redigo.Dial("tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	exists, err := redigo.Bool(conn.Do("EXISTS", "vote_data_1"))
+	if err != nil {
+		t.Fatalf("EXISTS: %v", err)
+	}
+	if exists {
+		t.Errorf("Key vote_data_1 exists for a secret poll")
+	}
+
+	objects, userIDs, err := r.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	slices.SortFunc(objects, bytes.Compare)
+	if expect := [][]byte{[]byte(`"A"`), []byte(`"A"`), []byte(`"B"`)}; !reflect.DeepEqual(objects, expect) {
+		t.Errorf("Stop returned votes %q, expected %q", objects, expect)
+	}
+
+	if expect := []int{5, 6, 7}; !reflect.DeepEqual(userIDs, expect) {
+		t.Errorf("Stop returned users %v, expected %v", userIDs, expect)
+	}
+}
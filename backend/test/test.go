@@ -159,6 +159,125 @@ func Backend(t *testing.T, backend vote.Backend) {
 		})
 	})
 
+	pollID++
+	t.Run("VoteIdempotent", func(t *testing.T) {
+		idempotentBackend, ok := backend.(vote.IdempotentBackend)
+		if !ok {
+			t.Skip("Backend does not support idempotency keys")
+		}
+
+		backend.Start(ctx, pollID)
+
+		if err := idempotentBackend.VoteIdempotent(ctx, pollID, 5, []byte("my vote"), "key5:vote"); err != nil {
+			t.Fatalf("VoteIdempotent returned unexpected error: %v", err)
+		}
+
+		if err := backend.Vote(ctx, pollID, 6, []byte("my vote")); err != nil {
+			t.Fatalf("Vote returned unexpected error: %v", err)
+		}
+
+		for _, tt := range []struct {
+			name      string
+			userID    int
+			key       string
+			expectKey string
+		}{
+			{"vote with key", 5, "key5:other", "key5:vote"},
+			{"vote without key", 6, "key6:vote", ""},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				err := idempotentBackend.VoteIdempotent(ctx, pollID, tt.userID, []byte("my vote"), tt.key)
+
+				var errDoubleVote interface {
+					DoubleVote()
+					IdempotencyKey() string
+				}
+				if !errors.As(err, &errDoubleVote) {
+					t.Fatalf("Second vote has to return a error with the methods DoubleVote and IdempotencyKey. Got: %v", err)
+				}
+
+				if got := errDoubleVote.IdempotencyKey(); got != tt.expectKey {
+					t.Errorf("IdempotencyKey() returned `%s`, expected `%s`", got, tt.expectKey)
+				}
+			})
+		}
+
+		data, _, err := backend.Stop(ctx, pollID)
+		if err != nil {
+			t.Fatalf("Stop returned unexpected error: %v", err)
+		}
+
+		if len(data) != 2 {
+			t.Errorf("Found %d vote objects, expected 2", len(data))
+		}
+	})
+
+	pollID++
+	t.Run("SetMoved", func(t *testing.T) {
+		moveBackend, ok := backend.(vote.MoveBackend)
+		if !ok {
+			t.Skip("Backend does not support moved polls")
+		}
+
+		t.Run("unknown poll", func(t *testing.T) {
+			err := moveBackend.SetMoved(ctx, pollID, "long")
+
+			var errDoesNotExist interface{ DoesNotExist() }
+			if !errors.As(err, &errDoesNotExist) {
+				t.Errorf("SetMoved on an unknown poll has to return an error with a method DoesNotExist(), got: %v", err)
+			}
+		})
+
+		backend.Start(ctx, pollID)
+
+		t.Run("set and remove", func(t *testing.T) {
+			if err := moveBackend.SetMoved(ctx, pollID, "long"); err != nil {
+				t.Fatalf("SetMoved returned unexpected error: %v", err)
+			}
+
+			moved, err := moveBackend.Moved(ctx)
+			if err != nil {
+				t.Fatalf("Moved returned unexpected error: %v", err)
+			}
+
+			if got := moved[pollID]; got != "long" {
+				t.Errorf("Moved returned `%s` for the poll, expected `long`", got)
+			}
+
+			if err := moveBackend.SetMoved(ctx, pollID, ""); err != nil {
+				t.Fatalf("SetMoved with an empty name returned unexpected error: %v", err)
+			}
+
+			moved, err = moveBackend.Moved(ctx)
+			if err != nil {
+				t.Fatalf("Moved returned unexpected error: %v", err)
+			}
+
+			if _, ok := moved[pollID]; ok {
+				t.Errorf("Poll is still marked after the mark was removed")
+			}
+		})
+
+		t.Run("clear removes the mark", func(t *testing.T) {
+			if err := moveBackend.SetMoved(ctx, pollID, "long"); err != nil {
+				t.Fatalf("SetMoved returned unexpected error: %v", err)
+			}
+
+			if err := backend.Clear(ctx, pollID); err != nil {
+				t.Fatalf("Clear returned unexpected error: %v", err)
+			}
+
+			moved, err := moveBackend.Moved(ctx)
+			if err != nil {
+				t.Fatalf("Moved returned unexpected error: %v", err)
+			}
+
+			if _, ok := moved[pollID]; ok {
+				t.Errorf("Poll is still marked after clear")
+			}
+		})
+	})
+
 	pollID++
 	t.Run("Clear removes vote data", func(t *testing.T) {
 		backend.Start(ctx, pollID)
@@ -262,6 +381,28 @@ func Backend(t *testing.T, backend vote.Backend) {
 		}
 	})
 
+	backend.ClearAll(ctx)
+	pollID++
+	t.Run("LiveVotes of named poll", func(t *testing.T) {
+		namedBackend, ok := backend.(vote.NamedBackend)
+		if !ok {
+			t.Skip("Backend does not implement vote.NamedBackend")
+		}
+
+		namedBackend.StartNamed(ctx, pollID)
+		backend.Vote(ctx, pollID, 5, []byte("my vote"))
+
+		got, err := backend.LiveVotes(ctx)
+		if err != nil {
+			t.Fatalf("LiveVotes returned unexpected error: %v", err)
+		}
+
+		expect := map[int]map[int][]byte{pollID: {5: []byte("my vote")}}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("LiveVotes returned %v, expected %v", got, expect)
+		}
+	})
+
 	backend.ClearAll(ctx)
 	pollID++
 	t.Run("Voted for many users", func(t *testing.T) {
@@ -430,8 +571,8 @@ func Backend(t *testing.T, backend vote.Backend) {
 // normalizeLiveVotes removes the vote from the data returned from
 // backend.LiveVotes.
 //
-// The postgres backend can not return a value and the current test-cases do not
-// need it.
+// The postgres backend can only return a value for named polls and the
+// current test-cases do not need it.
 func normalizeLiveVotes(in map[int]map[int][]byte) {
 	for _, m := range in {
 		for k := range m {
@@ -0,0 +1,687 @@
+// Package wal implements a vote.Backend for a single instance of the service,
+// that survives a restart.
+//
+// All data is held in memory. Before a change is applied, it is appended to
+// the write-ahead log `wal.log` in the data directory and the file is synced
+// to the disk. From time to time, the whole state is written to the file
+// `snapshot.json` and the log is truncated. On startup, the snapshot is loaded
+// and the log is replayed.
+//
+// Each record of the log starts with the length and the crc32 checksum of the
+// payload. An incomplete or corrupt record at the end of the log is the result
+// of a crash while writing. It is removed on startup.
+//
+// For named polls, a vote is saved together with the user id. For other polls,
+// only the ids of the users, that have voted, and the sorted ballots are saved.
+// The user and the ballot are written as two records, so no record contains
+// both. Since the records are in the order of the votes, the log is truncated
+// with a snapshot, when such a poll is stopped.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+const (
+	logFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	// snapshotEvery is the number of records, after that a new snapshot is
+	// written.
+	snapshotEvery = 10_000
+
+	// maxRecordSize is the maximal size of a record. Bigger records can only
+	// be the result of a corrupt log.
+	maxRecordSize = 64 << 20
+)
+
+const (
+	pollStateStarted = iota + 1
+	pollStateStopped
+)
+
+const (
+	opStart    = "start"
+	opVote     = "vote"
+	opVoted    = "voted"
+	opBallot   = "ballot"
+	opStop     = "stop"
+	opClear    = "clear"
+	opClearAll = "clear_all"
+	opMove     = "move"
+)
+
+// record is one entry of the write-ahead log.
+//
+// If More is true, the record is only applied together with the next record.
+type record struct {
+	Seq    uint64 `json:"seq"`
+	Op     string `json:"op"`
+	PollID int    `json:"poll_id,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+	Vote   []byte `json:"vote,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Target string `json:"target,omitempty"`
+	Named  bool   `json:"named,omitempty"`
+	More   bool   `json:"more,omitempty"`
+}
+
+// poll is the state of a poll.
+//
+// Votes is only used for named polls. For other polls, Voted contains the
+// sorted user ids and Ballots the sorted votes.
+type poll struct {
+	State   int            `json:"state"`
+	Named   bool           `json:"named,omitempty"`
+	Votes   map[int][]byte `json:"votes,omitempty"`
+	Voted   []int          `json:"voted,omitempty"`
+	Ballots [][]byte       `json:"ballots,omitempty"`
+	Keys    map[int]string `json:"keys,omitempty"`
+	Moved   string         `json:"moved,omitempty"`
+}
+
+// hasVoted tells, if the user has voted on the poll.
+func (p *poll) hasVoted(userID int) bool {
+	if p.Named {
+		_, ok := p.Votes[userID]
+		return ok
+	}
+
+	_, found := slices.BinarySearch(p.Voted, userID)
+	return found
+}
+
+func (p *poll) setKey(userID int, key string) {
+	if key == "" {
+		return
+	}
+
+	if p.Keys == nil {
+		p.Keys = make(map[int]string)
+	}
+	p.Keys[userID] = key
+}
+
+// snapshot is the content of the snapshot file.
+type snapshot struct {
+	// Seq is the sequence number of the last record, that is included in the
+	// snapshot.
+	Seq   uint64        `json:"seq"`
+	Polls map[int]*poll `json:"polls"`
+}
+
+// Backend is a vote backend that holds the data in memory and persists it in a
+// write-ahead log.
+//
+// Has to be created with wal.New().
+type Backend struct {
+	dir string
+
+	mu        sync.Mutex
+	polls     map[int]*poll
+	log       *os.File
+	seq       uint64
+	sinceSnap int
+
+	// err is set, when writing to the log failed. In this case, the log could
+	// contain an incomplete record and no other record can be appended.
+	err error
+}
+
+// New loads the data from the directory and opens the write-ahead log.
+func New(dir string) (*Backend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+
+	b := Backend{
+		dir:   dir,
+		polls: make(map[int]*poll),
+	}
+
+	if err := b.loadSnapshot(); err != nil {
+		return nil, fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	if err := b.replay(); err != nil {
+		return nil, fmt.Errorf("replaying log: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (b *Backend) String() string {
+	return "wal"
+}
+
+// Close closes the log file.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.log.Close()
+}
+
+func (b *Backend) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(b.dir, snapshotFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decoding snapshot: %w", err)
+	}
+
+	b.seq = snap.Seq
+	if snap.Polls != nil {
+		b.polls = snap.Polls
+	}
+	return nil
+}
+
+// replay applies all records from the log, that are newer then the snapshot.
+// It removes an incomplete record at the end of the log and opens the log for
+// appending.
+func (b *Backend) replay() error {
+	f, err := os.OpenFile(filepath.Join(b.dir, logFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening log: %w", err)
+	}
+
+	var valid, pendingSize int64
+	var pending []record
+	r := bufio.NewReader(f)
+	for {
+		rec, size, err := readRecord(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) || len(pending) > 0 {
+				// This is the end of a log after a crash.
+				log.Info("Removing incomplete record at offset %d of the write-ahead log: %v", valid, err)
+			}
+			break
+		}
+
+		pending = append(pending, rec)
+		pendingSize += size
+		if rec.More {
+			continue
+		}
+
+		valid += pendingSize
+		for _, rec := range pending {
+			if rec.Seq <= b.seq {
+				// The record is already part of the snapshot.
+				continue
+			}
+
+			b.apply(rec)
+			b.seq = rec.Seq
+			b.sinceSnap++
+		}
+		pending = pending[:0]
+		pendingSize = 0
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("removing incomplete records: %w", err)
+	}
+
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("seeking to the end of the log: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing log: %w", err)
+	}
+
+	b.log = f
+	return nil
+}
+
+// readRecord reads one record. It returns io.EOF, if there are no more
+// records and another error, if the record is incomplete or corrupt.
+func readRecord(r io.Reader) (record, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return record{}, 0, io.EOF
+		}
+		return record{}, 0, fmt.Errorf("reading header: %w", err)
+	}
+
+	size := binary.LittleEndian.Uint32(header[:4])
+	checksum := binary.LittleEndian.Uint32(header[4:])
+	if size > maxRecordSize {
+		return record{}, 0, fmt.Errorf("record has invalid size %d", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record{}, 0, fmt.Errorf("reading payload: %w", err)
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record{}, 0, fmt.Errorf("invalid checksum")
+	}
+
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return record{}, 0, fmt.Errorf("decoding record: %w", err)
+	}
+
+	return rec, int64(len(header)) + int64(size), nil
+}
+
+// write appends the records to the log and syncs it to the disk. Afterwards,
+// the records are applied.
+//
+// Many records are written as one change. On startup, they are only applied,
+// if all of them are in the log.
+//
+// b.mu has to be locked.
+func (b *Backend) write(recs ...record) error {
+	if b.err != nil {
+		return fmt.Errorf("log is broken: %w", b.err)
+	}
+
+	var buf []byte
+	for i := range recs {
+		recs[i].Seq = b.seq + uint64(i) + 1
+		recs[i].More = i < len(recs)-1
+
+		payload, err := json.Marshal(recs[i])
+		if err != nil {
+			return fmt.Errorf("encoding record: %w", err)
+		}
+
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+		buf = append(buf, payload...)
+	}
+
+	if _, err := b.log.Write(buf); err != nil {
+		b.err = err
+		return fmt.Errorf("writing record: %w", err)
+	}
+
+	if err := b.log.Sync(); err != nil {
+		b.err = err
+		return fmt.Errorf("syncing log: %w", err)
+	}
+
+	for _, rec := range recs {
+		b.apply(rec)
+		b.seq = rec.Seq
+		b.sinceSnap++
+	}
+
+	if b.sinceSnap >= snapshotEvery {
+		if err := b.snapshot(); err != nil {
+			// The record is saved in the log. The snapshot is tried again
+			// with the next record.
+			log.Error("writing snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// apply changes the state in memory. It does not check the record.
+//
+// b.mu has to be locked.
+func (b *Backend) apply(rec record) {
+	switch rec.Op {
+	case opStart:
+		if b.polls[rec.PollID] == nil {
+			b.polls[rec.PollID] = &poll{State: pollStateStarted, Named: rec.Named}
+		}
+
+	case opVote:
+		p := b.polls[rec.PollID]
+		if p == nil {
+			return
+		}
+
+		if p.Votes == nil {
+			p.Votes = make(map[int][]byte)
+		}
+		p.Votes[rec.UserID] = rec.Vote
+		p.setKey(rec.UserID, rec.Key)
+
+	case opVoted:
+		p := b.polls[rec.PollID]
+		if p == nil {
+			return
+		}
+
+		if i, found := slices.BinarySearch(p.Voted, rec.UserID); !found {
+			p.Voted = slices.Insert(p.Voted, i, rec.UserID)
+		}
+		p.setKey(rec.UserID, rec.Key)
+
+	case opBallot:
+		p := b.polls[rec.PollID]
+		if p == nil {
+			return
+		}
+
+		i, _ := slices.BinarySearchFunc(p.Ballots, rec.Vote, bytes.Compare)
+		p.Ballots = slices.Insert(p.Ballots, i, rec.Vote)
+
+	case opStop:
+		if p := b.polls[rec.PollID]; p != nil {
+			p.State = pollStateStopped
+		}
+
+	case opMove:
+		if p := b.polls[rec.PollID]; p != nil {
+			p.Moved = rec.Target
+		}
+
+	case opClear:
+		delete(b.polls, rec.PollID)
+
+	case opClearAll:
+		b.polls = make(map[int]*poll)
+	}
+}
+
+// Snapshot writes the whole state to the snapshot file and truncates the log.
+//
+// It is called automatically after some records.
+func (b *Backend) Snapshot() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.snapshot()
+}
+
+// snapshot writes the snapshot.
+//
+// b.mu has to be locked.
+func (b *Backend) snapshot() error {
+	if b.err != nil {
+		return fmt.Errorf("log is broken: %w", b.err)
+	}
+
+	data, err := json.Marshal(snapshot{Seq: b.seq, Polls: b.polls})
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	if err := writeFileSync(b.dir, snapshotFile, data); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+
+	// If the service crashes before the log is truncated, the records are
+	// skipped on the next start, since they are older then the snapshot.
+	if err := b.log.Truncate(0); err != nil {
+		b.err = err
+		return fmt.Errorf("truncating log: %w", err)
+	}
+
+	if _, err := b.log.Seek(0, io.SeekStart); err != nil {
+		b.err = err
+		return fmt.Errorf("seeking to the start of the log: %w", err)
+	}
+
+	if err := b.log.Sync(); err != nil {
+		b.err = err
+		return fmt.Errorf("syncing log: %w", err)
+	}
+
+	b.sinceSnap = 0
+	return nil
+}
+
+// writeFileSync writes a file atomically. It writes a temporary file and
+// renames it.
+func writeFileSync(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing temporary file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing directory: %w", err)
+	}
+	return nil
+}
+
+// Start opens a secret poll.
+func (b *Backend) Start(ctx context.Context, pollID int) error {
+	return b.start(pollID, false)
+}
+
+// StartNamed opens a named poll.
+func (b *Backend) StartNamed(ctx context.Context, pollID int) error {
+	return b.start(pollID, true)
+}
+
+func (b *Backend) start(pollID int, named bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.polls[pollID] != nil {
+		return nil
+	}
+
+	return b.write(record{Op: opStart, PollID: pollID, Named: named})
+}
+
+// Stop stopps a poll.
+func (b *Backend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.polls[pollID]
+	if p == nil {
+		return nil, nil, doesNotExistError{fmt.Errorf("Poll does not exist")}
+	}
+
+	if p.State != pollStateStopped {
+		if err := b.write(record{Op: opStop, PollID: pollID}); err != nil {
+			return nil, nil, err
+		}
+
+		if !p.Named {
+			// The order of the records could link the ballots to the users.
+			if err := b.snapshot(); err != nil {
+				log.Error("writing snapshot after stopping poll %d: %v", pollID, err)
+			}
+		}
+	}
+
+	if !p.Named {
+		return slices.Clone(p.Ballots), slices.Clone(p.Voted), nil
+	}
+
+	userIDs := slices.Collect(maps.Keys(p.Votes))
+	votes := slices.Collect(maps.Values(p.Votes))
+	sort.Ints(userIDs)
+	return votes, userIDs, nil
+}
+
+// Vote saves a vote.
+func (b *Backend) Vote(ctx context.Context, pollID int, userID int, vote []byte) error {
+	return b.vote(pollID, userID, vote, "")
+}
+
+// VoteIdempotent saves a vote together with an idempotency key.
+func (b *Backend) VoteIdempotent(ctx context.Context, pollID int, userID int, vote []byte, key string) error {
+	return b.vote(pollID, userID, vote, key)
+}
+
+func (b *Backend) vote(pollID int, userID int, vote []byte, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.polls[pollID]
+	if p == nil {
+		return doesNotExistError{fmt.Errorf("poll is not started")}
+	}
+
+	if p.State == pollStateStopped {
+		return stoppedError{fmt.Errorf("poll is stopped")}
+	}
+
+	if p.hasVoted(userID) {
+		return doubleVoteError{fmt.Errorf("user has already voted"), p.Keys[userID]}
+	}
+
+	if p.Named {
+		return b.write(record{Op: opVote, PollID: pollID, UserID: userID, Vote: vote, Key: key})
+	}
+
+	// The ballot of a secret poll is written without the user.
+	return b.write(
+		record{Op: opVoted, PollID: pollID, UserID: userID, Key: key},
+		record{Op: opBallot, PollID: pollID, Vote: vote},
+	)
+}
+
+// Clear removes all data for a poll.
+func (b *Backend) Clear(ctx context.Context, pollID int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.polls[pollID] == nil {
+		return nil
+	}
+
+	return b.write(record{Op: opClear, PollID: pollID})
+}
+
+// ClearAll removes all data for all polls.
+func (b *Backend) ClearAll(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.write(record{Op: opClearAll})
+}
+
+// SetMoved marks a poll as moved to another backend.
+func (b *Backend) SetMoved(ctx context.Context, pollID int, backend string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.polls[pollID]
+	if p == nil {
+		return doesNotExistError{fmt.Errorf("Poll does not exist")}
+	}
+
+	if p.Moved == backend {
+		return nil
+	}
+
+	return b.write(record{Op: opMove, PollID: pollID, Target: backend})
+}
+
+// Moved returns the new backend of all marked polls.
+func (b *Backend) Moved(ctx context.Context) (map[int]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make(map[int]string)
+	for pollID, p := range b.polls {
+		if p.Moved != "" {
+			out[pollID] = p.Moved
+		}
+	}
+	return out, nil
+}
+
+// LiveVotes returns all votes from each user. Returns nil for the votes of
+// secret polls.
+func (b *Backend) LiveVotes(ctx context.Context) (map[int]map[int][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make(map[int]map[int][]byte, len(b.polls))
+	for pollID, p := range b.polls {
+		if p.Named {
+			out[pollID] = maps.Clone(p.Votes)
+			if out[pollID] == nil {
+				out[pollID] = make(map[int][]byte)
+			}
+			continue
+		}
+
+		out[pollID] = make(map[int][]byte, len(p.Voted))
+		for _, userID := range p.Voted {
+			out[pollID][userID] = nil
+		}
+	}
+
+	return out, nil
+}
+
+type doesNotExistError struct {
+	error
+}
+
+func (doesNotExistError) DoesNotExist() {}
+
+type doubleVoteError struct {
+	error
+	key string
+}
+
+func (doubleVoteError) DoubleVote() {}
+
+func (err doubleVoteError) IdempotencyKey() string {
+	return err.key
+}
+
+type stoppedError struct {
+	error
+}
+
+func (stoppedError) Stopped() {}
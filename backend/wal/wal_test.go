@@ -0,0 +1,232 @@
+package wal_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/backend/test"
+	"github.com/OpenSlides/openslides-vote-service/backend/wal"
+)
+
+func TestBackend(t *testing.T) {
+	b, err := wal.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+
+	test.Backend(t, b)
+}
+
+func TestRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b, err := wal.New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	b.StartNamed(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"Y"`))
+	b.Start(ctx, 2)
+	b.Vote(ctx, 2, 5, []byte(`"N"`))
+	b.Stop(ctx, 2)
+	b.SetMoved(ctx, 2, "long")
+
+	if err := b.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	b.Vote(ctx, 1, 6, []byte(`"N"`))
+	b.SetMoved(ctx, 1, "long")
+	b.Start(ctx, 3)
+	b.Clear(ctx, 3)
+	b.Close()
+
+	b, err = wal.New(dir)
+	if err != nil {
+		t.Fatalf("New after restart: %v", err)
+	}
+	defer b.Close()
+
+	got, err := b.LiveVotes(ctx)
+	if err != nil {
+		t.Fatalf("LiveVotes: %v", err)
+	}
+
+	expect := map[int]map[int][]byte{
+		1: {5: []byte(`"Y"`), 6: []byte(`"N"`)},
+		2: {5: nil},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("After restart got %v, expected %v", got, expect)
+	}
+
+	var errStopped interface{ Stopped() }
+	if err := b.Vote(ctx, 2, 6, []byte(`"Y"`)); !errors.As(err, &errStopped) {
+		t.Errorf("Vote on stopped poll after restart returned %v, expected a stopped error", err)
+	}
+
+	ballots, userIDs, err := b.Stop(ctx, 2)
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if !reflect.DeepEqual(ballots, [][]byte{[]byte(`"N"`)}) || !reflect.DeepEqual(userIDs, []int{5}) {
+		t.Errorf("Stop after restart returned %q and %v, expected the vote of user 5", ballots, userIDs)
+	}
+
+	moved, err := b.Moved(ctx)
+	if err != nil {
+		t.Fatalf("Moved: %v", err)
+	}
+
+	if expect := map[int]string{1: "long", 2: "long"}; !reflect.DeepEqual(moved, expect) {
+		t.Errorf("After restart got moved polls %v, expected %v", moved, expect)
+	}
+}
+
+func TestTruncatedLog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b, err := wal.New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Poll 1 is named and poll 2 is secret.
+	const voteCount = 50
+	b.StartNamed(ctx, 1)
+	b.Start(ctx, 2)
+	for userID := 1; userID <= voteCount; userID++ {
+		if err := b.Vote(ctx, 1, userID, fmt.Appendf(nil, `"vote %d"`, userID)); err != nil {
+			t.Fatalf("Vote: %v", err)
+		}
+
+		if err := b.Vote(ctx, 2, userID, fmt.Appendf(nil, `"vote %d"`, userID)); err != nil {
+			t.Fatalf("Vote on secret poll: %v", err)
+		}
+	}
+	b.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("Reading log: %v", err)
+	}
+
+	for range 20 {
+		offset := rand.IntN(len(data))
+		t.Run(fmt.Sprintf("offset %d", offset), func(t *testing.T) {
+			crashDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(crashDir, "wal.log"), data[:offset], 0o600); err != nil {
+				t.Fatalf("Writing truncated log: %v", err)
+			}
+
+			b, err := wal.New(crashDir)
+			if err != nil {
+				t.Fatalf("New with truncated log: %v", err)
+			}
+			defer b.Close()
+
+			got, err := b.LiveVotes(ctx)
+			if err != nil {
+				t.Fatalf("LiveVotes: %v", err)
+			}
+
+			// The saved votes have to be the first votes without a gap.
+			votes := got[1]
+			for userID := 1; userID <= len(votes); userID++ {
+				if string(votes[userID]) != fmt.Sprintf(`"vote %d"`, userID) {
+					t.Fatalf("Vote of user %d is %q, expected the votes of the first %d users", userID, votes[userID], len(votes))
+				}
+			}
+
+			// A secret vote is only saved with its ballot.
+			if ballots, userIDs, err := b.Stop(ctx, 2); err == nil && len(ballots) != len(userIDs) {
+				t.Fatalf("Secret poll has %d ballots from %d users", len(ballots), len(userIDs))
+			}
+
+			if len(votes) == voteCount {
+				return
+			}
+
+			// After the recovery, new records have to be appended to the
+			// valid log.
+			b.StartNamed(ctx, 1)
+			if err := b.Vote(ctx, 1, voteCount+1, []byte(`"new"`)); err != nil {
+				t.Fatalf("Vote after recovery: %v", err)
+			}
+			b.Close()
+
+			b, err = wal.New(crashDir)
+			if err != nil {
+				t.Fatalf("New after recovery: %v", err)
+			}
+			defer b.Close()
+
+			got, err = b.LiveVotes(ctx)
+			if err != nil {
+				t.Fatalf("LiveVotes after recovery: %v", err)
+			}
+
+			if string(got[1][voteCount+1]) != `"new"` {
+				t.Errorf("Vote after recovery was lost: %v", got)
+			}
+		})
+	}
+}
+
+func TestSecretPoll(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b, err := wal.New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer b.Close()
+
+	b.Start(ctx, 1)
+	b.Vote(ctx, 1, 5, []byte(`"secret vote"`))
+
+	data, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("Reading log: %v", err)
+	}
+
+	for len(data) > 0 {
+		size := binary.LittleEndian.Uint32(data[:4])
+		var rec map[string]any
+		if err := json.Unmarshal(data[8:8+size], &rec); err != nil {
+			t.Fatalf("Decoding record: %v", err)
+		}
+		data = data[8+size:]
+
+		if rec["user_id"] != nil && rec["vote"] != nil {
+			t.Errorf("Record %v contains the user and the vote", rec)
+		}
+	}
+
+	if _, _, err := b.Stop(ctx, 1); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("Stat log: %v", err)
+	}
+
+	if info.Size() != 0 {
+		t.Errorf("Log has %d bytes after stopping a secret poll, expected it to be truncated", info.Size())
+	}
+}
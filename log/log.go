@@ -1,62 +1,188 @@
+// Package log writes leveled log messages as text or as json.
+//
+// The functions Info, Debug and Error take a format string like fmt.Printf. The
+// functions with the suffix Context take a message and key-value pairs like
+// log/slog. They also add the fields from the context, that were added with
+// With.
+//
+// Ballots must never be logged. Values, that could contain a ballot, have to be
+// wrapped with Redacted. All attributes with the key "ballot" are redacted.
 package log
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"sync"
 )
 
+// The supported output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// redactedText replaces redacted values.
+const redactedText = "[redacted]"
+
 var (
-	loggerMu    sync.RWMutex
-	debugLogger *log.Logger
-	infoLogger  *log.Logger
+	loggerMu sync.RWMutex
+	logger   *slog.Logger
+	debug    bool
 )
 
-// SetDebugLogger sets the debug logger. The default is no log at all.
+// Setup sets the global logger. The default is no log at all.
+//
+// format has to be FormatText or FormatJSON. If debug is false, debug messages
+// are not written.
 //
-// This function should only be started at the beginnen of the program before
-// the Debug was called for the frist time.
-func SetDebugLogger(l *log.Logger) {
+// This function should only be called at the beginning of the program or
+// before the logger is used.
+func Setup(w io.Writer, format string, withDebug bool) error {
+	level := slog.LevelInfo
+	if withDebug {
+		level = slog.LevelDebug
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+
 	loggerMu.Lock()
 	defer loggerMu.Unlock()
-	debugLogger = l
+	logger = slog.New(contextHandler{handler})
+	debug = withDebug
+	return nil
+}
+
+// Info prints output that is important for the user.
+func Info(format string, a ...any) {
+	logf(slog.LevelInfo, format, a...)
 }
 
-// SetInfoLogger sets the Logger for info messages. The default is log.Default()
+// Error prints an error, that could not be returned to a caller.
+func Error(format string, a ...any) {
+	logf(slog.LevelError, format, a...)
+}
+
+// Debug prints output that is important for development and debugging.
 //
-// This function should only be started at the beginnen of the program before
-// the Debug was called for the frist time.
-func SetInfoLogger(l *log.Logger) {
-	loggerMu.Lock()
-	defer loggerMu.Unlock()
-	infoLogger = l
+// If debug is not enabled, this function is a noop.
+func Debug(format string, a ...any) {
+	logf(slog.LevelDebug, format, a...)
 }
 
-// Info prints output that is important for the user.
-func Info(format string, a ...interface{}) {
+// InfoContext prints a message with the fields from the context and the given
+// key-value pairs.
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	logContext(ctx, slog.LevelInfo, msg, args...)
+}
+
+// ErrorContext prints an error with the fields from the context and the given
+// key-value pairs.
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	logContext(ctx, slog.LevelError, msg, args...)
+}
+
+// DebugContext prints a debug message with the fields from the context and the
+// given key-value pairs.
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	logContext(ctx, slog.LevelDebug, msg, args...)
+}
+
+// IsDebug returns if debug output is enabled.
+func IsDebug() bool {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return debug
+}
+
+func logf(level slog.Level, format string, a ...any) {
 	loggerMu.RLock()
 	defer loggerMu.RUnlock()
 
-	if infoLogger == nil {
+	if logger == nil || !logger.Enabled(context.Background(), level) {
 		return
 	}
-	infoLogger.Printf(format, a...)
+
+	logger.Log(context.Background(), level, fmt.Sprintf(format, a...))
 }
 
-// Debug prints output that is important for development and debugging.
-//
-// If EnableDebug() was not called, this function is a noop.
-func Debug(format string, a ...interface{}) {
+func logContext(ctx context.Context, level slog.Level, msg string, args ...any) {
 	loggerMu.RLock()
 	defer loggerMu.RUnlock()
 
-	if debugLogger == nil {
+	if logger == nil {
 		return
 	}
 
-	debugLogger.Printf(format, a...)
+	logger.Log(ctx, level, msg, args...)
 }
 
-// IsDebug returns if debug output is enabled.
-func IsDebug() bool {
-	return debugLogger != nil
+type contextKey int
+
+const fieldsKey contextKey = iota
+
+// With returns a context with additional fields, that are added to each
+// message logged with this context. args are key-value pairs like in
+// log/slog.
+func With(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey).([]any)
+	return context.WithValue(ctx, fieldsKey, append(fields[:len(fields):len(fields)], args...))
+}
+
+// contextHandler adds the fields from the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields, ok := ctx.Value(fieldsKey).([]any); ok {
+		record.Add(fields...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr hides all attributes with the key "ballot".
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == "ballot" {
+		return slog.String(attr.Key, redactedText)
+	}
+	return attr
+}
+
+// Redacted is a ballot or another value, that is hidden in the logs.
+//
+// It has to be used for all values, that could contain a ballot, for example
+// log.Debug("vote: %s", log.Redacted(ballot)).
+type Redacted []byte
+
+// Format writes the redacted text for every verb of the fmt package.
+func (r Redacted) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redactedText)
+}
+
+// LogValue implements slog.LogValuer.
+func (r Redacted) LogValue() slog.Value {
+	return slog.StringValue(redactedText)
 }
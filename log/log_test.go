@@ -0,0 +1,110 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+func TestJSONWithContextFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := log.Setup(buf, log.FormatJSON, false); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	ctx := log.With(context.Background(), "request_id", "abc")
+	ctx = log.With(ctx, "poll_id", 1)
+	log.InfoContext(ctx, "some message", "user_id", 5)
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding log line `%s`: %v", buf, err)
+	}
+
+	for key, expect := range map[string]any{
+		"level":      "INFO",
+		"msg":        "some message",
+		"request_id": "abc",
+		"poll_id":    1.0,
+		"user_id":    5.0,
+	} {
+		if got[key] != expect {
+			t.Errorf("Field %s is %v, expected %v", key, got[key], expect)
+		}
+	}
+}
+
+func TestWithDoesNotChangeParent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := log.Setup(buf, log.FormatJSON, false); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	parent := log.With(context.Background(), "a", 1, "b", 2)
+	log.With(parent, "c", 3)
+	log.With(parent, "d", 4)
+	log.InfoContext(parent, "parent")
+
+	if strings.Contains(buf.String(), `"c"`) || strings.Contains(buf.String(), `"d"`) {
+		t.Errorf("Log of parent context contains fields of a child: %s", buf)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := log.Setup(buf, log.FormatText, false); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	log.Debug("hidden")
+	log.DebugContext(context.Background(), "hidden")
+	if buf.Len() != 0 {
+		t.Errorf("Debug message was written without debug: %s", buf)
+	}
+
+	if err := log.Setup(buf, log.FormatText, true); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	log.Debug("visible %d", 1)
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "visible 1") {
+		t.Errorf("Debug message was not written: %s", buf)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	ballot := []byte(`{"value":"secret"}`)
+
+	for _, format := range []string{log.FormatText, log.FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := log.Setup(buf, format, true); err != nil {
+				t.Fatalf("Setup: %v", err)
+			}
+
+			log.Debug("%s %v %x %d %q", log.Redacted(ballot), log.Redacted(ballot), log.Redacted(ballot), log.Redacted(ballot), log.Redacted(ballot))
+			log.Error("%v", log.Redacted(ballot))
+			log.DebugContext(context.Background(), "vote", "value", log.Redacted(ballot))
+			log.InfoContext(context.Background(), "vote", "ballot", string(ballot))
+			log.InfoContext(log.With(context.Background(), "ballot", ballot), "vote")
+
+			if strings.Contains(buf.String(), "secret") || strings.Contains(buf.String(), "7365637265") {
+				t.Errorf("Log contains the ballot:\n%s", buf)
+			}
+
+			if got := strings.Count(buf.String(), "[redacted]"); got < 9 {
+				t.Errorf("Got %d redacted values, expected at least 9:\n%s", got, buf)
+			}
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if err := log.Setup(new(bytes.Buffer), "xml", false); err == nil {
+		t.Errorf("Setup with unknown format did not return an error")
+	}
+}
@@ -4,21 +4,27 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	golog "log"
 	"os"
 	"strconv"
 
 	"github.com/OpenSlides/openslides-go/auth"
 	"github.com/OpenSlides/openslides-go/environment"
 	messageBusRedis "github.com/OpenSlides/openslides-go/redis"
+	"github.com/OpenSlides/openslides-vote-service/audit"
 	"github.com/OpenSlides/openslides-vote-service/backend"
 	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/tracing"
 	"github.com/OpenSlides/openslides-vote-service/vote"
 	"github.com/OpenSlides/openslides-vote-service/vote/http"
 	"github.com/alecthomas/kong"
 )
 
-var envDebugLog = environment.NewVariable("VOTE_DEBUG_LOG", "false", "Show debug log.")
+var (
+	envDebugLog  = environment.NewVariable("VOTE_DEBUG_LOG", "false", "Show debug log.")
+	envLogFormat = environment.NewVariable("VOTE_LOG_FORMAT", "text", "Format of the log. One of `text` or `json`.")
+
+	envIdempotencySecretFile = environment.NewVariable("VOTE_IDEMPOTENCY_SECRET_FILE", "", "File with the secret to hash the idempotency keys. Has to be the same on all instances. Required, if VOTE_SINGLE_INSTANCE is not set. If empty, a random secret is used, that only works until a restart.")
+)
 
 //go:generate  sh -c "go run main.go build-doc > environment.md"
 
@@ -30,13 +36,20 @@ var cli struct {
 		Port     string `help:"Port of the service" short:"p" default:"9013" env:"VOTE_PORT"`
 		UseHTTPS bool   `help:"Use https to connect to the service" short:"s"`
 		Insecure bool   `help:"Accept invalid cert" short:"k"`
+		Cert     string `help:"Client certificate to present to the service" type:"path"`
+		Key      string `help:"Private key of the client certificate" type:"path"`
+		Ready    bool   `help:"Check the readiness, that includes the backends, the datastore and the auth service"`
 	} `cmd:"" help:"Runs a health check."`
+	Migrate struct {
+		Status bool `help:"Only show the status of the migrations."`
+	} `cmd:"" help:"Applies pending migrations of the postgres database."`
+	AuditVerify struct{} `cmd:"" help:"Verifies the hash chain of the audit log."`
 }
 
 func main() {
 	ctx, cancel := environment.InterruptContext()
 	defer cancel()
-	log.SetInfoLogger(golog.Default())
+	log.Setup(os.Stderr, log.FormatText, false)
 
 	kongCTX := kong.Parse(&cli, kong.UsageOnError())
 	switch kongCTX.Command() {
@@ -52,8 +65,20 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "migrate":
+		if err := contextDone(migrate(ctx, cli.Migrate.Status)); err != nil {
+			handleError(err)
+			os.Exit(1)
+		}
+
+	case "audit-verify":
+		if err := contextDone(auditVerify(ctx)); err != nil {
+			handleError(err)
+			os.Exit(1)
+		}
+
 	case "health":
-		if err := contextDone(http.HealthClient(ctx, cli.Health.UseHTTPS, cli.Health.Host, cli.Health.Port, cli.Health.Insecure)); err != nil {
+		if err := contextDone(http.HealthClient(ctx, cli.Health.UseHTTPS, cli.Health.Host, cli.Health.Port, cli.Health.Insecure, cli.Health.Cert, cli.Health.Key, cli.Health.Ready)); err != nil {
 			handleError(err)
 			os.Exit(1)
 		}
@@ -63,8 +88,8 @@ func main() {
 func run(ctx context.Context) error {
 	lookup := new(environment.ForProduction)
 
-	if debug, _ := strconv.ParseBool(envDebugLog.Value(lookup)); debug {
-		log.SetDebugLogger(golog.Default())
+	if err := setupLog(lookup); err != nil {
+		return fmt.Errorf("init log: %w", err)
 	}
 
 	service, err := initService(lookup)
@@ -75,9 +100,103 @@ func run(ctx context.Context) error {
 	return service(ctx)
 }
 
+// setupLog configures the logger from the environment.
+func setupLog(lookup environment.Environmenter) error {
+	debug, _ := strconv.ParseBool(envDebugLog.Value(lookup))
+
+	format := envLogFormat.Value(lookup)
+	if err := log.Setup(os.Stderr, format, debug); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", envLogFormat.Key, err)
+	}
+	return nil
+}
+
+// migrate shows the status of the postgres migrations and applies all pending
+// migrations.
+func migrate(ctx context.Context, statusOnly bool) error {
+	lookup := new(environment.ForProduction)
+
+	if err := setupLog(lookup); err != nil {
+		return fmt.Errorf("init log: %w", err)
+	}
+
+	connect, err := backend.BuildPostgres(lookup)
+	if err != nil {
+		return fmt.Errorf("init postgres: %w", err)
+	}
+
+	p, err := connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to postgres: %w", err)
+	}
+	defer p.Close()
+
+	migrations, err := p.Migrations(ctx)
+	if err != nil {
+		return fmt.Errorf("reading migrations: %w", err)
+	}
+
+	var pending int
+	for _, m := range migrations {
+		status := "applied"
+		if !m.Applied {
+			status = "pending"
+			pending++
+		}
+		fmt.Printf("%04d %s: %s\n", m.Version, m.Name, status)
+	}
+
+	if statusOnly || pending == 0 {
+		return nil
+	}
+
+	if err := p.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrating: %w", err)
+	}
+
+	fmt.Printf("Applied %d migrations\n", pending)
+	return nil
+}
+
+// auditVerify checks the hash chain of the audit log.
+func auditVerify(ctx context.Context) error {
+	lookup := new(environment.ForProduction)
+
+	if err := setupLog(lookup); err != nil {
+		return fmt.Errorf("init log: %w", err)
+	}
+
+	openSink, err := backend.BuildAudit(lookup)
+	if err != nil {
+		return fmt.Errorf("init audit log: %w", err)
+	}
+
+	if openSink == nil {
+		return fmt.Errorf("audit log is disabled")
+	}
+
+	sink, closeSink, err := openSink(ctx)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer closeSink()
+
+	count, err := audit.Verify(ctx, sink)
+	if err != nil {
+		return fmt.Errorf("audit log is invalid after %d entries: %w", count, err)
+	}
+
+	fmt.Printf("Audit log is valid: %d entries\n", count)
+	return nil
+}
+
 func buildDocu() error {
 	lookup := new(environment.ForDocu)
 
+	if err := setupLog(lookup); err != nil {
+		return fmt.Errorf("init log: %w", err)
+	}
+
 	if _, err := initService(lookup); err != nil {
 		return fmt.Errorf("init services: %w", err)
 	}
@@ -97,7 +216,16 @@ func buildDocu() error {
 func initService(lookup environment.Environmenter) (func(context.Context) error, error) {
 	var backgroundTasks []func(context.Context, func(error))
 
-	httpServer := http.New(lookup)
+	tracingBackground, err := tracing.New(lookup)
+	if err != nil {
+		return nil, fmt.Errorf("init tracing: %w", err)
+	}
+	backgroundTasks = append(backgroundTasks, tracingBackground)
+
+	httpServer, err := http.New(lookup)
+	if err != nil {
+		return nil, fmt.Errorf("init http server: %w", err)
+	}
 
 	// Redis as message bus for datastore and logout events.
 	messageBus := messageBusRedis.New(lookup)
@@ -120,6 +248,28 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 		return nil, fmt.Errorf("init vote backend: %w", err)
 	}
 
+	openAuditSink, err := backend.BuildAudit(lookup)
+	if err != nil {
+		return nil, fmt.Errorf("init audit log: %w", err)
+	}
+
+	var idempotencySecret string
+	switch {
+	case envIdempotencySecretFile.Value(lookup) != "":
+		idempotencySecret, err = environment.ReadSecret(lookup, envIdempotencySecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading idempotency secret: %w", err)
+		}
+
+	case !singleInstance:
+		// With a random secret, a retry on another instance would be a double
+		// vote.
+		return nil, fmt.Errorf("%s is required with more than one instance", envIdempotencySecretFile.Key)
+
+	default:
+		log.Info("No %s set. Idempotency keys do not work after a restart.", envIdempotencySecretFile.Key)
+	}
+
 	service := func(ctx context.Context) error {
 		fastBackend, err := fastBackendStarter(ctx)
 		if err != nil {
@@ -131,22 +281,82 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 			return fmt.Errorf("start long backend: %w", err)
 		}
 
-		voteService, voteBackground, err := vote.New(ctx, fastBackend, longBackend, database, singleInstance)
+		// Share the rate limit between all instances.
+		if tokenBucket, ok := findTokenBucket(fastBackend); ok {
+			httpServer.TokenBucket = tokenBucket
+		}
+
+		httpServer.ReadyChecks = readyChecks(lookup, fastBackend, longBackend, database)
+
+		voteService, voteBackground, err := vote.New(ctx, fastBackend, longBackend, database, singleInstance, []byte(idempotencySecret))
 		if err != nil {
 			return fmt.Errorf("starting service: %w", err)
 		}
 		backgroundTasks = append(backgroundTasks, voteBackground)
 
+		if openAuditSink != nil {
+			auditSink, closeAuditSink, err := openAuditSink(ctx)
+			if err != nil {
+				return fmt.Errorf("open audit log: %w", err)
+			}
+			defer closeAuditSink()
+
+			auditLog := audit.New(auditSink)
+			defer auditLog.Close()
+
+			voteService.Auditor = auditLog
+		}
+
 		for _, bg := range backgroundTasks {
 			go bg(ctx, handleError)
 		}
 
-		return httpServer.Run(ctx, authService, voteService)
+		runErr := httpServer.Run(ctx, authService, voteService)
+
+		// The backends are closed after the server, so the votes from the
+		// shutdown are saved.
+		if err := errors.Join(vote.Close(fastBackend), vote.Close(longBackend)); err != nil {
+			log.Error("closing backends: %v", err)
+		}
+
+		return runErr
 	}
 
 	return service, nil
 }
 
+// readyChecks returns the dependencies, that are checked by the readiness
+// route.
+func readyChecks(lookup environment.Environmenter, fastBackend, longBackend vote.Backend, database any) []http.ReadyCheck {
+	checks := []http.ReadyCheck{
+		{Name: "fast_backend", Check: func(ctx context.Context) error { return vote.Ping(ctx, fastBackend) }},
+		{Name: "long_backend", Check: func(ctx context.Context) error { return vote.Ping(ctx, longBackend) }},
+		{Name: "datastore", Check: func(ctx context.Context) error { return vote.Ping(ctx, database) }},
+	}
+
+	if authCheck := http.AuthServiceCheck(lookup); authCheck != nil {
+		checks = append(checks, http.ReadyCheck{Name: "auth", Check: authCheck})
+	}
+
+	return checks
+}
+
+// findTokenBucket returns the backend or a backend wrapped by it, that
+// implements http.TokenBucket.
+func findTokenBucket(backend vote.Backend) (http.TokenBucket, bool) {
+	for {
+		if tokenBucket, ok := backend.(http.TokenBucket); ok {
+			return tokenBucket, true
+		}
+
+		wrapper, ok := backend.(interface{ Unwrap() vote.Backend })
+		if !ok {
+			return nil, false
+		}
+		backend = wrapper.Unwrap()
+	}
+}
+
 // contextDone returns an empty error if the context is done or exceeded
 func contextDone(err error) error {
 	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -163,5 +373,5 @@ func handleError(err error) {
 		return
 	}
 
-	log.Info("Error: %v", err)
+	log.Error("%v", err)
 }
@@ -0,0 +1,265 @@
+// Package metrics collects counters and histograms and writes them in the
+// prometheus text format.
+//
+// The metrics are registered in a global registry, like the logger in the log
+// package. They are created once as package variables.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds for
+// latencies.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, existing := range registry {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s is registered twice", m.name()))
+		}
+	}
+	registry = append(registry, m)
+}
+
+// Write writes all metrics in the prometheus text format.
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	metrics := slices.Clone(registry)
+	registryMu.Unlock()
+
+	slices.SortFunc(metrics, func(a, b metric) int { return strings.Compare(a.name(), b.name()) })
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return fmt.Errorf("writing metric %s: %w", m.name(), err)
+		}
+	}
+	return nil
+}
+
+// labelKey joins label values to a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels returns the labels in the form `{a="1",b="2"}`.
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) error {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	help = strings.ReplaceAll(help, "\n", `\n`)
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	return err
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		values:     make(map[string]*counterValue),
+	}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", c.metricName, len(c.labels), len(labelValues)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := labelKey(labelValues)
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labels: slices.Clone(labelValues)}
+		c.values[key] = value
+	}
+	value.value += v
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := writeHeader(w, c.metricName, c.help, "counter"); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range slices.Sorted(maps.Keys(c.values)) {
+		value := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, value.labels), formatFloat(value.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // counts holds the observations for each bucket, not cumulative.
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram. buckets are the sorted
+// upper bounds of the buckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		values:     make(map[string]*histogramValue),
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+// Observe adds a value to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", h.metricName, len(h.labels), len(labelValues)))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := writeHeader(w, h.metricName, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range slices.Sorted(maps.Keys(h.values)) {
+		value := h.values[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, value.labels, "le", formatFloat(bound)), cumulative); err != nil {
+				return err
+			}
+		}
+
+		labels := formatLabels(h.labels, value.labels)
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.metricName, formatLabels(h.labels, value.labels, "le", "+Inf"), value.count,
+			h.metricName, labels, formatFloat(value.sum),
+			h.metricName, labels, value.count,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
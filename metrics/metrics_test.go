@@ -0,0 +1,47 @@
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+)
+
+var (
+	testCounter   = metrics.NewCounterVec("test_requests_total", "Number of requests.", "route")
+	testHistogram = metrics.NewHistogramVec("test_duration_seconds", "Duration of requests.", []float64{0.1, 1}, "route")
+)
+
+func TestWrite(t *testing.T) {
+	testCounter.Inc("/vote")
+	testCounter.Add(2, `/a"b`)
+	testHistogram.Observe(0.05, "/vote")
+	testHistogram.Observe(0.5, "/vote")
+	testHistogram.Observe(5, "/vote")
+
+	buf := new(bytes.Buffer)
+	if err := metrics.Write(buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/vote"} 1`,
+		`test_requests_total{route="/a\"b"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="/vote",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="/vote",le="1"} 2`,
+		`test_duration_seconds_bucket{route="/vote",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="/vote"} 5.55`,
+		`test_duration_seconds_count{route="/vote"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Output does not contain line `%s`:\n%s", line, buf)
+		}
+	}
+
+	if strings.Index(buf.String(), "test_duration_seconds") > strings.Index(buf.String(), "test_requests_total") {
+		t.Errorf("Metrics are not sorted by name:\n%s", buf)
+	}
+}
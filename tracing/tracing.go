@@ -0,0 +1,102 @@
+// Package tracing creates opentelemetry spans and exports them with OTLP.
+//
+// Like the log and metrics packages, it uses the global tracer provider. If no
+// exporter is configured, the spans are not recorded. The trace context is
+// still propagated.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer.
+const instrumentationName = "github.com/OpenSlides/openslides-vote-service"
+
+var (
+	envEndpoint    = environment.NewVariable("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "", "URL of the OTLP/HTTP endpoint for traces, for example `http://collector:4318/v1/traces`. If empty, no traces are exported.")
+	envServiceName = environment.NewVariable("OTEL_SERVICE_NAME", "vote", "Name of the service in the traces.")
+	envSampleRatio = environment.NewVariable("OTEL_TRACES_SAMPLER_ARG", "1", "Ratio of the traces, that are exported. Between 0 and 1. Traces started by another service use the decision of that service.")
+)
+
+// shutdownTimeout is the time to export the remaining spans, when the service
+// stops.
+const shutdownTimeout = 5 * time.Second
+
+// Propagator reads and writes the trace context from http headers.
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// New configures the global tracer provider from the environment.
+//
+// The returned function is a background task, that exports the remaining spans
+// when the context is done.
+func New(lookup environment.Environmenter) (func(context.Context, func(error)), error) {
+	otel.SetTextMapPropagator(Propagator)
+
+	endpoint := envEndpoint.Value(lookup)
+	serviceName := envServiceName.Value(lookup)
+
+	ratio, err := strconv.ParseFloat(envSampleRatio.Value(lookup), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("invalid value for %s: %s", envSampleRatio.Key, envSampleRatio.Value(lookup))
+	}
+
+	if endpoint == "" {
+		return func(context.Context, func(error)) {}, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating otlp exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	background := func(ctx context.Context, errorHandler func(error)) {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			errorHandler(fmt.Errorf("shutdown tracer provider: %w", err))
+		}
+	}
+
+	return background, nil
+}
+
+// Start creates a span and a context, that contains the span.
+//
+// The span has to be finished with End.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End finishes a span. If err is not nil, it is recorded and the span is marked
+// as failed.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,56 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/tracing"
+	"github.com/OpenSlides/openslides-vote-service/tracing/tracingtest"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestNewWithoutEndpoint(t *testing.T) {
+	background, err := tracing.New(environment.ForTests(nil))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	background(ctx, func(err error) { t.Errorf("background task: %v", err) })
+}
+
+func TestNewInvalidRatio(t *testing.T) {
+	for _, ratio := range []string{"abc", "-1", "2"} {
+		_, err := tracing.New(environment.ForTests(map[string]string{"OTEL_TRACES_SAMPLER_ARG": ratio}))
+		if err == nil {
+			t.Errorf("New with ratio %s did not return an error", ratio)
+		}
+	}
+}
+
+func TestEnd(t *testing.T) {
+	spans := tracingtest.Record(t)
+	ctx := context.Background()
+
+	_, span := tracing.Start(ctx, "ok")
+	tracing.End(span, nil)
+
+	_, span = tracing.Start(ctx, "failed")
+	tracing.End(span, errors.New("some error"))
+
+	got := spans.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("Got %d spans, expected 2", len(got))
+	}
+
+	if got[0].Status.Code != codes.Unset {
+		t.Errorf("Span ok has status %v, expected unset", got[0].Status.Code)
+	}
+
+	if got[1].Status.Code != codes.Error || got[1].Status.Description != "some error" {
+		t.Errorf("Span failed has status %v %q, expected error", got[1].Status.Code, got[1].Status.Description)
+	}
+}
@@ -0,0 +1,44 @@
+// Package tracingtest records the spans of the tracing package in tests.
+package tracingtest
+
+import (
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Record sets a global tracer provider, that saves all spans in memory.
+//
+// The old tracer provider is restored at the end of the test. Tests, that use
+// Record, can not run in parallel.
+func Record(t testing.TB) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	oldProvider := otel.GetTracerProvider()
+	oldPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracing.Propagator)
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	return exporter
+}
+
+// Names returns the names of the recorded spans in the order they ended.
+func Names(exporter *tracetest.InMemoryExporter) []string {
+	spans := exporter.GetSpans()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}
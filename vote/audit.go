@@ -0,0 +1,38 @@
+package vote
+
+import (
+	"context"
+)
+
+// Auditor records the state changing operations of the service.
+//
+// The caller is the client of an internal route, or `user/ID` for a vote. votes
+// is the number of votes of a stopped poll. err is the result of the operation.
+//
+// Votes are only recorded for named polls. For a secret poll, the user id and
+// the order of the entries could be used to find the ballot of a user.
+type Auditor interface {
+	Audit(ctx context.Context, operation string, caller string, pollID int, votes int, err error)
+}
+
+// WithCaller returns a context with the identity of the client, that calls an
+// internal route. It is recorded by the Auditor.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerContextKey, caller)
+}
+
+func callerFromContext(ctx context.Context) string {
+	caller, ok := ctx.Value(callerContextKey).(string)
+	if !ok {
+		return "unknown"
+	}
+	return caller
+}
+
+// audit records an operation, if an Auditor is set.
+func (v *Vote) audit(ctx context.Context, operation string, pollID int, votes int, err error) {
+	if v.Auditor == nil {
+		return
+	}
+	v.Auditor.Audit(ctx, operation, callerFromContext(ctx), pollID, votes, err)
+}
@@ -0,0 +1,159 @@
+package vote
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmodels"
+)
+
+// loadVotedFallbackInterval is the time between two calls of loadVoted, when
+// all backends inform about their changes.
+const loadVotedFallbackInterval = 30 * time.Second
+
+// backends returns the distinct backends.
+func (v *Vote) backends() []Backend {
+	if v.fastBackend == v.longBackend {
+		return []Backend{v.fastBackend}
+	}
+	return []Backend{v.fastBackend, v.longBackend}
+}
+
+// changeListeners returns the backends, that implement ChangeListener.
+func (v *Vote) changeListeners() []ChangeListener {
+	var listeners []ChangeListener
+	for _, backend := range v.backends() {
+		if listener, ok := backend.(ChangeListener); ok {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+// listenChanges applies the changes of a backend to the live votes until the
+// context is done.
+func (v *Vote) listenChanges(ctx context.Context, listener ChangeListener, errorHandler func(error)) {
+	ready := func() {
+		// Changes could have been missed while the listener was not connected.
+		if err := v.loadVoted(ctx); err != nil {
+			errorHandler(err)
+		}
+	}
+
+	change := func(change BackendChange) {
+		if reload := v.applyChange(ctx, change); reload {
+			if err := v.loadVoted(ctx); err != nil {
+				errorHandler(err)
+			}
+		}
+	}
+
+	for {
+		err := listener.ListenChanges(ctx, ready, change)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			errorHandler(fmt.Errorf("listening for changes: %w", err))
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyChange updates the live votes with a change from a backend.
+//
+// The changes of the own instance are already applied. So applying a change has
+// to be idempotent.
+//
+// It returns true, if the live votes have to be reloaded from the backends. This
+// is the case, when a started poll was cleared, since it was migrated to the
+// other backend, or when the change does not contain the vote of a named poll.
+func (v *Vote) applyChange(ctx context.Context, change BackendChange) (reload bool) {
+	// The poll is fetched before liveVotesMu is locked, so a slow datastore
+	// does not block the other requests. If the poll is unknown, the zero
+	// value is used.
+	var poll dsmodels.Poll
+	if change.PollID != 0 {
+		if p := v.fetchPolls(ctx, []int{change.PollID})[change.PollID]; p != nil {
+			poll = *p
+		}
+	}
+
+	v.liveVotesMu.Lock()
+	defer v.liveVotesMu.Unlock()
+
+	switch change.Type {
+	case ChangeStart:
+		if _, ok := v.liveVotes[change.PollID]; ok {
+			return
+		}
+
+		v.liveVotes[change.PollID] = make(map[int][]byte)
+		v.publish(liveVotesEvent{
+			pollID:      change.PollID,
+			meetingID:   poll.MeetingID,
+			userID2Vote: map[int]*string{},
+		})
+
+	case ChangeVote:
+		if _, ok := v.liveVotes[change.PollID][change.UserID]; ok {
+			return
+		}
+
+		var liveVote []byte
+		if poll.Type == "named" {
+			if change.Vote == nil {
+				// Without the vote, the user would be shown as voted without
+				// a vote.
+				return true
+			}
+			liveVote = change.Vote
+		}
+
+		if v.liveVotes[change.PollID] == nil {
+			v.liveVotes[change.PollID] = make(map[int][]byte)
+		}
+		v.liveVotes[change.PollID][change.UserID] = liveVote
+		v.publish(liveVotesEvent{
+			pollID:      change.PollID,
+			meetingID:   poll.MeetingID,
+			userID2Vote: map[int]*string{change.UserID: liveVoteValue(poll, liveVote)},
+		})
+
+	case ChangeStop:
+		v.notify()
+
+	case ChangeClear:
+		delete(v.pinned, change.PollID)
+		if poll.State == "started" {
+			return true
+		}
+
+		if _, ok := v.liveVotes[change.PollID]; !ok {
+			return
+		}
+
+		delete(v.liveVotes, change.PollID)
+		v.publish(liveVotesEvent{pollID: change.PollID})
+
+	case ChangeReload:
+		return true
+
+	case ChangeClearAll:
+		events := make([]liveVotesEvent, 0, len(v.liveVotes))
+		for pollID := range v.liveVotes {
+			events = append(events, liveVotesEvent{pollID: pollID})
+		}
+		v.liveVotes = make(map[int]map[int][]byte)
+		v.pinned = make(map[int]Backend)
+		v.publish(events...)
+	}
+	return false
+}
@@ -28,6 +28,10 @@ const (
 
 	// ErrStopped happens when a user tries to vote on a stopped poll.
 	ErrStopped
+
+	// ErrConflict happens on a vote request, when the idempotency key was
+	// already used with a different ballot.
+	ErrConflict
 )
 
 // TypeError is an error that can happend in this API.
@@ -54,6 +58,9 @@ func (err TypeError) Type() string {
 	case ErrStopped:
 		return "stopped"
 
+	case ErrConflict:
+		return "conflict"
+
 	default:
 		return "internal"
 	}
@@ -80,6 +87,9 @@ func (err TypeError) Error() string {
 	case ErrNotAllowed:
 		msg = "You are not allowed to vote"
 
+	case ErrConflict:
+		msg = "The idempotency key was used with a different vote"
+
 	default:
 		msg = "Ups, something went wrong!"
 
@@ -1,15 +1,19 @@
 package vote
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/OpenSlides/openslides-go/datastore"
 	"github.com/OpenSlides/openslides-go/datastore/cache"
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
 	"github.com/OpenSlides/openslides-go/datastore/flow"
 	"github.com/OpenSlides/openslides-go/environment"
 )
 
 // Flow initializes a cached connection to postgres.
+//
+// The flow implements Pinger.
 func Flow(lookup environment.Environmenter, messageBus flow.Updater) (flow.Flow, error) {
 	err := datastore.WaitPostgresAvailable(lookup)
 	if err != nil {
@@ -21,7 +25,23 @@ func Flow(lookup environment.Environmenter, messageBus flow.Updater) (flow.Flow,
 		return nil, fmt.Errorf("init postgres: %w", err)
 	}
 
-	cache := cache.New(postgres)
+	return pingFlow{
+		Cache:    cache.New(postgres),
+		postgres: postgres,
+	}, nil
+}
+
+// pingFlow is a cached flow, that can check the connection to postgres.
+type pingFlow struct {
+	*cache.Cache
+	postgres *datastore.FlowPostgres
+}
 
-	return cache, nil
+// Ping reads a key from postgres. It does not use the cache, since the cache
+// would answer without postgres.
+func (f pingFlow) Ping(ctx context.Context) error {
+	if _, err := f.postgres.Get(ctx, dskey.MustKey("organization/1/id")); err != nil {
+		return fmt.Errorf("reading from postgres: %w", err)
+	}
+	return nil
 }
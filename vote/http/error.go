@@ -27,12 +27,12 @@ func resolveError(handler Handler, internalRoute bool) http.HandlerFunc {
 			return
 		}
 
-		writeStatusCode(w, err)
-		writeFormattedError(w, err, internalRoute)
+		writeStatusCode(r.Context(), w, err)
+		writeFormattedError(r.Context(), w, err, internalRoute)
 	}
 }
 
-func writeStatusCode(w http.ResponseWriter, err error) {
+func writeStatusCode(ctx context.Context, w http.ResponseWriter, err error) {
 	statusCode := 400
 	var errStatusCode statusCodeError
 	if errors.As(err, &errStatusCode) {
@@ -46,11 +46,31 @@ func writeStatusCode(w http.ResponseWriter, err error) {
 		statusCode = 500
 	}
 
-	log.Debug("HTTP: Returning status %d", statusCode)
+	log.DebugContext(ctx, "Returning status", "status", statusCode)
 	w.WriteHeader(statusCode)
 }
 
-func writeFormattedError(w io.Writer, err error, internalRoute bool) {
+func writeFormattedError(ctx context.Context, w io.Writer, err error, internalRoute bool) {
+	errType, msg := formatError(ctx, err, internalRoute)
+
+	out := struct {
+		Error string `json:"error"`
+		MSG   string `json:"message"`
+	}{
+		errType,
+		msg,
+	}
+
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.ErrorContext(ctx, "Encoding error message", "error", err)
+		fmt.Fprint(w, `{"error":"internal", "message":"Something went wrong encoding the error message"}`)
+	}
+}
+
+// formatError returns the type and the message of an error, that can be sent to
+// the client. Internal errors are logged and on external routes, their message
+// is hidden.
+func formatError(ctx context.Context, err error, internalRoute bool) (string, string) {
 	errType := "internal"
 	var errTyped interface {
 		error
@@ -62,24 +82,13 @@ func writeFormattedError(w io.Writer, err error, internalRoute bool) {
 
 	msg := err.Error()
 	if errType == "internal" {
-		log.Info("Error: %s", msg)
+		log.ErrorContext(ctx, msg)
 		if !internalRoute {
 			msg = vote.ErrInternal.Error()
 		}
 	}
 
-	out := struct {
-		Error string `json:"error"`
-		MSG   string `json:"message"`
-	}{
-		errType,
-		msg,
-	}
-
-	if err := json.NewEncoder(w).Encode(out); err != nil {
-		log.Info("Error encoding error message: %v", err)
-		fmt.Fprint(w, `{"error":"internal", "message":"Something went wrong encoding the error message"}`)
-	}
+	return errType, msg
 }
 
 type statusCodeError struct {
@@ -4,17 +4,23 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/OpenSlides/openslides-go/environment"
 	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/metrics"
+	"github.com/OpenSlides/openslides-vote-service/tracing"
 	"github.com/OpenSlides/openslides-vote-service/vote"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var envVotePort = environment.NewVariable("VOTE_PORT", "9013", "Port on which the service listen on.")
@@ -23,13 +29,67 @@ var envVotePort = environment.NewVariable("VOTE_PORT", "9013", "Port on which th
 type Server struct {
 	Addr string
 	lst  net.Listener
+
+	// TokenBucket is used for the rate limit. The default saves the buckets in
+	// memory. If the service is scaled horizontally, it has to be set to a
+	// shared storage.
+	TokenBucket TokenBucket
+
+	// ReadyChecks are the dependencies, that are checked by the readiness
+	// route.
+	ReadyChecks []ReadyCheck
+
+	userRateLimit   rateLimit
+	ipRateLimit     rateLimit
+	internalAuth    internalAuth
+	tls             *certReloader
+	shutdownTimeout time.Duration
 }
 
 // New initializes a new Server.
-func New(lookup environment.Environmenter) Server {
-	return Server{
-		Addr: ":" + envVotePort.Value(lookup),
+func New(lookup environment.Environmenter) (Server, error) {
+	addr := ":" + envVotePort.Value(lookup)
+
+	userRateLimit, err := parseRateLimit(lookup, envRateLimitUser, envRateLimitUserBurst)
+	if err != nil {
+		return Server{}, fmt.Errorf("user rate limit: %w", err)
+	}
+
+	ipRateLimit, err := parseRateLimit(lookup, envRateLimitIP, envRateLimitIPBurst)
+	if err != nil {
+		return Server{}, fmt.Errorf("ip rate limit: %w", err)
+	}
+
+	internalAuth, err := newInternalAuth(lookup)
+	if err != nil {
+		return Server{}, fmt.Errorf("internal auth: %w", err)
+	}
+
+	tls, err := newCertReloader(lookup)
+	if err != nil {
+		return Server{}, fmt.Errorf("tls: %w", err)
+	}
+
+	// Without a client CA for TLS, the client certificates are not requested
+	// and each internal request would be rejected.
+	if internalAuth.mode == "mtls" && (tls == nil || tls.clientCAFile == "") {
+		return Server{}, fmt.Errorf("internal auth: %s=mtls requires %s, %s and %s", envInternalAuth.Key, envTLSCertFile.Key, envTLSKeyFile.Key, envTLSClientCAFile.Key)
+	}
+
+	shutdownTimeout, err := parseShutdownTimeout(lookup)
+	if err != nil {
+		return Server{}, fmt.Errorf("shutdown: %w", err)
 	}
+
+	return Server{
+		Addr:            addr,
+		TokenBucket:     newMemoryTokenBucket(),
+		userRateLimit:   userRateLimit,
+		ipRateLimit:     ipRateLimit,
+		internalAuth:    internalAuth,
+		tls:             tls,
+		shutdownTimeout: shutdownTimeout,
+	}, nil
 }
 
 // StartListener starts the listener where the server will listen on.
@@ -47,27 +107,46 @@ func (s *Server) StartListener() error {
 }
 
 // Run starts the http service.
+//
+// When the context is done, the server shuts down gracefully. See
+// Server.shutdown.
 func (s *Server) Run(ctx context.Context, auth authenticater, service *vote.Vote) error {
 	ticketProvider := func() (<-chan time.Time, func()) {
 		ticker := time.NewTicker(time.Second)
 		return ticker.C, ticker.Stop
 	}
 
-	mux := registerHandlers(service, auth, ticketProvider)
+	heartbeatProvider := func() (<-chan time.Time, func()) {
+		ticker := time.NewTicker(sseHeartbeatInterval)
+		return ticker.C, ticker.Stop
+	}
+
+	limitedAuth := rateLimitAuth{
+		authenticater: auth,
+		bucket:        s.TokenBucket,
+		user:          s.userRateLimit,
+		ip:            s.ipRateLimit,
+	}
+
+	mux := registerHandlers(ctx, service, limitedAuth, s.internalAuth, s.ReadyChecks, ticketProvider, heartbeatProvider)
 
+	// The requests do not use ctx, so they are not canceled, when the
+	// shutdown starts. Their context is canceled, if they do not finish in
+	// time.
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+
+	var requests sync.WaitGroup
 	srv := &http.Server{
-		Handler:     mux,
-		BaseContext: func(net.Listener) context.Context { return ctx },
+		Handler:     countRequests(&requests, mux),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 
 	// Shutdown logic in separate goroutine.
 	wait := make(chan error)
 	go func() {
 		<-ctx.Done()
-		if err := srv.Shutdown(context.Background()); err != nil {
-			wait <- fmt.Errorf("HTTP server shutdown: %w", err)
-			return
-		}
+		s.shutdown(srv, &requests, cancelRequests)
 		wait <- nil
 	}()
 
@@ -77,7 +156,19 @@ func (s *Server) Run(ctx context.Context, auth authenticater, service *vote.Vote
 		}
 	}
 
-	log.Info("Listen on %s\n", s.Addr)
+	if s.tls != nil {
+		srv.TLSConfig = s.tls.serverConfig()
+		go s.tls.watch(ctx, certReloadInterval)
+
+		log.Info("Listen with TLS on %s", s.Addr)
+		if err := srv.ServeTLS(s.lst, "", ""); err != http.ErrServerClosed {
+			return fmt.Errorf("HTTP Server failed: %v", err)
+		}
+
+		return <-wait
+	}
+
+	log.Info("Listen on %s", s.Addr)
 	if err := srv.Serve(s.lst); err != http.ErrServerClosed {
 		return fmt.Errorf("HTTP Server failed: %v", err)
 	}
@@ -90,9 +181,11 @@ type voteService interface {
 	stopper
 	clearer
 	clearAller
-	allLiveVotes
+	pollMigrater
+	liveVotesSubscriber
 	voter
 	haveIvoteder
+	votablePollser
 }
 
 type authenticater interface {
@@ -100,22 +193,41 @@ type authenticater interface {
 	FromContext(context.Context) int
 }
 
-func registerHandlers(service voteService, auth authenticater, ticketProvider func() (<-chan time.Time, func())) *http.ServeMux {
+func registerHandlers(ctx context.Context, service voteService, auth rateLimitAuth, internalAuth internalAuth, readyChecks []ReadyCheck, ticketProvider, heartbeatProvider func() (<-chan time.Time, func())) *http.ServeMux {
 	const (
 		internal = "/internal/vote"
 		external = "/system/vote"
 	)
 
+	history := newLiveVotesHistory(service, vote.LiveVotesFilter{})
+	history.start(ctx)
+
+	liveVotes := acceptEventStream(
+		handleAllVotedIDsSSE(service, history, heartbeatProvider, ctx.Done()),
+		handleAllVotedIDs(service, ctx.Done()),
+	)
+
 	mux := http.NewServeMux()
 
-	mux.Handle(internal+"/start", handleInternal(handleStart(service)))
-	mux.Handle(internal+"/stop", handleInternal(handleStop(service)))
-	mux.Handle(internal+"/clear", handleInternal(handleClear(service)))
-	mux.Handle(internal+"/clear_all", handleInternal(handleClearAll(service)))
-	mux.Handle(internal+"/live_votes", handleInternal(handleAllVotedIDs(service, ticketProvider)))
-	mux.Handle(external+"", handleExternal(handleVote(service, auth)))
-	mux.Handle(external+"/voted", handleExternal(handleVoted(service, auth)))
-	mux.Handle(external+"/health", handleExternal(handleHealth()))
+	// handle registers a handler, that creates a span and a correlation id for
+	// each request.
+	handle := func(pattern string, handler http.Handler) {
+		mux.Handle(pattern, otelhttp.NewHandler(withRequestID(pattern, handler), pattern, otelhttp.WithPropagators(tracing.Propagator)))
+	}
+
+	handle(internal+"/start", handleInternal(internalAuth.wrap(handleStart(service))))
+	handle(internal+"/stop", handleInternal(internalAuth.wrap(handleStop(service))))
+	handle(internal+"/clear", handleInternal(internalAuth.wrap(handleClear(service))))
+	handle(internal+"/clear_all", handleInternal(internalAuth.wrap(handleClearAll(service))))
+	handle(internal+"/migrate", handleInternal(internalAuth.wrap(handleMigrate(service))))
+	handle(internal+"/live_votes", handleInternal(internalAuth.wrap(liveVotes)))
+	handle(internal+"/ready", handleInternal(internalAuth.wrap(handleReady(readyChecks, true))))
+	handle(external+"", handleExternal(rejectOnShutdown(ctx.Done(), handleVote(service, auth))))
+	handle(external+"/voted", handleExternal(handleVoted(service, auth)))
+	handle(external+"/websocket", handleExternal(handleWebsocket(service, auth, auth, ticketProvider, ctx.Done())))
+	handle(external+"/health", handleExternal(handleHealth()))
+	handle(external+"/ready", handleExternal(handleReady(readyChecks, false)))
+	handle("/metrics", handleInternal(internalAuth.wrap(handleMetrics())))
 
 	return mux
 }
@@ -126,7 +238,7 @@ type starter interface {
 
 func handleStart(start starter) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving start request")
+		log.InfoContext(r.Context(), "Receiving start request")
 		w.Header().Set("Content-Type", "application/json")
 
 		id, err := pollID(r)
@@ -146,7 +258,7 @@ type stopper interface {
 
 func handleStop(stop stopper) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving stop request")
+		log.InfoContext(r.Context(), "Receiving stop request")
 		w.Header().Set("Content-Type", "application/json")
 
 		id, err := pollID(r)
@@ -190,7 +302,7 @@ type clearer interface {
 
 func handleClear(clear clearer) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving clear request")
+		log.InfoContext(r.Context(), "Receiving clear request")
 		w.Header().Set("Content-Type", "application/json")
 
 		id, err := pollID(r)
@@ -208,20 +320,47 @@ type clearAller interface {
 
 func handleClearAll(clear clearAller) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving clear all request")
+		log.InfoContext(r.Context(), "Receiving clear all request")
 		w.Header().Set("Content-Type", "application/json")
 
 		return clear.ClearAll(r.Context())
 	}
 }
 
+// pollMigrater moves a started poll to another backend.
+type pollMigrater interface {
+	MigratePoll(ctx context.Context, pollID int, backend string) error
+}
+
+func handleMigrate(migrate pollMigrater) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.InfoContext(r.Context(), "Receiving migrate request")
+		w.Header().Set("Content-Type", "application/json")
+
+		id, err := pollID(r)
+		if err != nil {
+			return vote.WrapError(vote.ErrInvalid, err)
+		}
+
+		backend := r.URL.Query().Get("backend")
+		if backend == "" {
+			return vote.MessageError(vote.ErrInvalid, "Query argument backend is required")
+		}
+
+		return migrate.MigratePoll(r.Context(), id, backend)
+	}
+}
+
+// maxIdempotencyKeyLength is the maximal length of the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
 type voter interface {
 	Vote(ctx context.Context, pollID, requestUser int, r io.Reader) error
 }
 
 func handleVote(service voter, auth authenticater) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving vote request")
+		log.InfoContext(r.Context(), "Receiving vote request")
 		w.Header().Set("Content-Type", "application/json")
 
 		ctx, err := auth.Authenticate(w, r)
@@ -238,8 +377,23 @@ func handleVote(service voter, auth authenticater) HandlerFunc {
 		if err != nil {
 			return vote.WrapError(vote.ErrInvalid, err)
 		}
+		ctx = log.With(ctx, "poll_id", id, "user_id", uid)
+
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			if len(key) > maxIdempotencyKeyLength {
+				return vote.MessageErrorf(vote.ErrInvalid, "Idempotency-Key has to be at most %d characters", maxIdempotencyKeyLength)
+			}
+			ctx = vote.WithIdempotencyKey(ctx, key)
+		}
+
+		if err := service.Vote(ctx, id, uid, r.Body); err != nil {
+			if errors.Is(err, vote.ErrConflict) {
+				return statusCode(409, err)
+			}
+			return err
+		}
 
-		return service.Vote(ctx, id, uid, r.Body)
+		return nil
 	}
 }
 
@@ -249,7 +403,7 @@ type haveIvoteder interface {
 
 func handleVoted(voted haveIvoteder, auth authenticater) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving has voted request")
+		log.InfoContext(r.Context(), "Receiving has voted request")
 		w.Header().Set("Content-Type", "application/json")
 
 		ctx, err := auth.Authenticate(w, r)
@@ -280,8 +434,8 @@ func handleVoted(voted haveIvoteder, auth authenticater) HandlerFunc {
 	}
 }
 
-type allLiveVotes interface {
-	AllLiveVotes(ctx context.Context) map[int]map[int]*string
+type liveVotesSubscriber interface {
+	SubscribeLiveVotes(ctx context.Context, filter vote.LiveVotesFilter) (map[int]map[int]*string, <-chan map[int]map[int]*string, func())
 }
 
 // handleAllVotedIDs opens an http connection, that the server never closes.
@@ -289,80 +443,144 @@ type allLiveVotes interface {
 // When the connection is established, it returns for all active polls the votes
 // of each user.
 //
-// Every second, it checks for new votes or polls. If there is new data, it
-// returns an dictonary from poll id to user id to there vote.
+// Each time a vote is saved or a poll is started or cleared, it returns an
+// dictonary from poll id to user id to there vote.
 //
 // If an poll is not active anymore, it returns a `null`-value for it.
 //
-// This system can only add users. It can fail, if a poll is resettet and
-// started in less then a second.
-func handleAllVotedIDs(voteCounter allLiveVotes, eventer func() (<-chan time.Time, func())) HandlerFunc {
+// With the query arguments `ids` and `meeting_id`, only the polls with the
+// given ids or from the given meeting are returned.
+//
+// This system can only add users.
+//
+// When the shutdown starts, the response is ended.
+func handleAllVotedIDs(service liveVotesSubscriber, shutdown <-chan struct{}) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		log.Info("Receiving all voted ids")
+		log.InfoContext(r.Context(), "Receiving all voted ids")
+
+		filter, err := liveVotesFilter(r)
+		if err != nil {
+			return vote.WrapError(vote.ErrInvalid, err)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 
 		encoder := json.NewEncoder(w)
 
-		event, cancel := eventer()
-		defer cancel()
+		snapshot, changes, unsubscribe := service.SubscribeLiveVotes(r.Context(), filter)
+		defer func() { unsubscribe() }()
+
+		voterMemory := make(map[int]map[int]*string)
+		if err := encoder.Encode(liveVotesDiff(voterMemory, snapshot)); err != nil {
+			return err
+		}
+		w.(http.Flusher).Flush()
 
-		var voterMemory map[int]map[int]*string
-		firstData := true
 		for {
-			newLiveVotes := voteCounter.AllLiveVotes(r.Context())
-			diff := make(map[int]map[int]*string)
-
-			if voterMemory == nil {
-				voterMemory = newLiveVotes
-				diff = newLiveVotes
-			} else {
-				for pollID, userID2Vote := range newLiveVotes {
-					if oldUserID2Vote, ok := voterMemory[pollID]; !ok {
-						voterMemory[pollID] = userID2Vote
-						diff[pollID] = userID2Vote
-					} else {
-
-						for newUserID, vote := range userID2Vote {
-							if _, contains := oldUserID2Vote[newUserID]; !contains {
-								if _, ok := diff[pollID]; !ok {
-									diff[pollID] = make(map[int]*string)
-								}
-								voterMemory[pollID][newUserID] = vote
-								diff[pollID][newUserID] = vote
-							}
-						}
-					}
-				}
-				for pollID := range voterMemory {
-					if _, ok := newLiveVotes[pollID]; !ok {
-						delete(voterMemory, pollID)
-						diff[pollID] = nil
-					}
+			var diff map[int]map[int]*string
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					// The connection was too slow. Subscribe again and
+					// send everything, that was missed.
+					unsubscribe()
+					snapshot, changes, unsubscribe = service.SubscribeLiveVotes(r.Context(), filter)
+					diff = liveVotesDiff(voterMemory, snapshot)
+					break
 				}
+
+				diff = applyLiveVotesChange(voterMemory, change)
+
+			case <-r.Context().Done():
+				return nil
+
+			case <-shutdown:
+				return nil
 			}
 
-			if firstData || len(diff) > 0 {
-				firstData = false
-				if err := encoder.Encode(diff); err != nil {
-					return err
-				}
+			if len(diff) == 0 {
+				continue
 			}
 
-			// This could be in the if(count) block, but the Flush is used
-			// in the tests and has to be called, even when there is no data
-			// to sent.
+			if err := encoder.Encode(diff); err != nil {
+				return err
+			}
 			w.(http.Flusher).Flush()
+		}
+	}
+}
 
-			select {
-			case _, ok := <-event:
-				if !ok {
-					return nil
+// applyLiveVotesChange adds a change from SubscribeLiveVotes to the memory and
+// returns the part of the change, that was not already in the memory.
+func applyLiveVotesChange(memory, change map[int]map[int]*string) map[int]map[int]*string {
+	diff := make(map[int]map[int]*string)
+	for pollID, userID2Vote := range change {
+		oldUserID2Vote, ok := memory[pollID]
+
+		if userID2Vote == nil {
+			if ok {
+				delete(memory, pollID)
+				diff[pollID] = nil
+			}
+			continue
+		}
+
+		if !ok {
+			// The maps of the change are shared and are not allowed to be
+			// changed.
+			memory[pollID] = maps.Clone(userID2Vote)
+			diff[pollID] = userID2Vote
+			continue
+		}
+
+		for userID, vote := range userID2Vote {
+			if _, contains := oldUserID2Vote[userID]; !contains {
+				if _, ok := diff[pollID]; !ok {
+					diff[pollID] = make(map[int]*string)
 				}
-			case <-r.Context().Done():
-				return nil
+				oldUserID2Vote[userID] = vote
+				diff[pollID][userID] = vote
 			}
 		}
 	}
+
+	return diff
+}
+
+// liveVotesDiff updates the memory with the new live votes and returns the
+// changes.
+//
+// New polls and new users are added to the memory. Polls that are not in
+// newLiveVotes are removed from the memory and returned with a nil value.
+func liveVotesDiff(memory, newLiveVotes map[int]map[int]*string) map[int]map[int]*string {
+	diff := make(map[int]map[int]*string)
+	for pollID, userID2Vote := range newLiveVotes {
+		oldUserID2Vote, ok := memory[pollID]
+		if !ok {
+			memory[pollID] = userID2Vote
+			diff[pollID] = userID2Vote
+			continue
+		}
+
+		for newUserID, vote := range userID2Vote {
+			if _, contains := oldUserID2Vote[newUserID]; !contains {
+				if _, ok := diff[pollID]; !ok {
+					diff[pollID] = make(map[int]*string)
+				}
+				memory[pollID][newUserID] = vote
+				diff[pollID][newUserID] = vote
+			}
+		}
+	}
+
+	for pollID := range memory {
+		if _, ok := newLiveVotes[pollID]; !ok {
+			delete(memory, pollID)
+			diff[pollID] = nil
+		}
+	}
+
+	return diff
 }
 
 func handleHealth() HandlerFunc {
@@ -374,33 +592,68 @@ func handleHealth() HandlerFunc {
 	}
 }
 
+// handleMetrics writes all metrics in the prometheus text format.
+func handleMetrics() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := metrics.Write(w); err != nil {
+			return fmt.Errorf("writing metrics: %w", err)
+		}
+		return nil
+	}
+}
+
 // HealthClient sends a http request to a server to fetch the health status.
-func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecure bool) error {
+//
+// If ready is true, it fetches the readiness status instead, that also checks
+// the dependencies of the service.
+//
+// If certFile and keyFile are not empty, the client presents the certificate
+// to the server.
+func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecure bool, certFile, keyFile string, ready bool) error {
 	proto := "http"
 	if useHTTPS {
 		proto = "https"
 	}
 
+	route := "health"
+	if ready {
+		route = "ready"
+	}
+
 	req, err := http.NewRequestWithContext(
 		ctx,
 		"GET",
-		fmt.Sprintf("%s://%s:%s/system/vote/health", proto, host, port),
+		fmt.Sprintf("%s://%s:%s/system/vote/%s", proto, host, port, route),
 		nil,
 	)
 	if err != nil {
 		return fmt.Errorf("creating request: %w", err)
 	}
 
-	if insecure {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = tlsConfig
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if ready {
+		return readyResponse(resp)
+	}
+
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("health returned status %s", resp.Status)
 	}
@@ -421,6 +674,29 @@ func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecur
 	return nil
 }
 
+// readyResponse checks the response of the public readiness route. It returns
+// an error, if the service is not ready.
+func readyResponse(resp *http.Response) error {
+	if resp.StatusCode != 200 && resp.StatusCode != http.StatusServiceUnavailable {
+		return fmt.Errorf("ready returned status %s", resp.Status)
+	}
+
+	var body struct {
+		Ready   bool   `json:"ready"`
+		Service string `json:"service"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("reading and parsing response body: %w", err)
+	}
+
+	if !body.Ready || body.Service != "vote" {
+		return fmt.Errorf("Server is not ready")
+	}
+
+	return nil
+}
+
 func pollID(r *http.Request) (int, error) {
 	rawID := r.URL.Query().Get("id")
 	if rawID == "" {
@@ -453,6 +729,30 @@ func pollsID(r *http.Request) ([]int, error) {
 	return ids, nil
 }
 
+// liveVotesFilter reads the filter for the live votes from the query arguments
+// `ids` and `meeting_id`.
+func liveVotesFilter(r *http.Request) (vote.LiveVotesFilter, error) {
+	var filter vote.LiveVotesFilter
+
+	if r.URL.Query().Has("ids") {
+		ids, err := pollsID(r)
+		if err != nil {
+			return vote.LiveVotesFilter{}, err
+		}
+		filter.PollIDs = ids
+	}
+
+	if rawMeetingID := r.URL.Query().Get("meeting_id"); rawMeetingID != "" {
+		meetingID, err := strconv.Atoi(rawMeetingID)
+		if err != nil {
+			return vote.LiveVotesFilter{}, fmt.Errorf("meeting_id invalid. Expected int, got %s", rawMeetingID)
+		}
+		filter.MeetingID = meetingID
+	}
+
+	return filter, nil
+}
+
 // Handler is like http.Handler but returns an error
 type Handler interface {
 	ServeHTTP(w http.ResponseWriter, r *http.Request) error
@@ -11,6 +11,7 @@ import (
 	"github.com/OpenSlides/openslides-go/datastore/dsmock"
 	"github.com/OpenSlides/openslides-go/environment"
 	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/tracing/tracingtest"
 	"github.com/OpenSlides/openslides-vote-service/vote"
 	votehttp "github.com/OpenSlides/openslides-vote-service/vote/http"
 )
@@ -44,8 +45,11 @@ func TestRun(t *testing.T) {
 
 	backend := memory.New()
 	ds := dsmock.NewFlow(nil)
-	service, _, _ := vote.New(ctx, backend, backend, ds, true)
-	httpServer := votehttp.New(environment.ForTests(map[string]string{"VOTE_PORT": "0"}))
+	service, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+	httpServer, err := votehttp.New(environment.ForTests(map[string]string{"VOTE_PORT": "0"}))
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
 
 	if err := httpServer.StartListener(); err != nil {
 		t.Fatalf("start listening: %v", err)
@@ -82,4 +86,39 @@ func TestRun(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Trace context", func(t *testing.T) {
+		spans := tracingtest.Record(t)
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/system/vote/health", httpServer.Addr), nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		resp.Body.Close()
+
+		// The span ends after the response was sent.
+		got := spans.GetSpans()
+		for i := 0; len(got) == 0 && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			got = spans.GetSpans()
+		}
+
+		if len(got) != 1 {
+			t.Fatalf("Got %d spans, expected 1", len(got))
+		}
+
+		if traceID := got[0].SpanContext.TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Got trace id %s, expected the one from the header", traceID)
+		}
+
+		if parentID := got[0].Parent.SpanID().String(); parentID != "00f067aa0ba902b7" {
+			t.Errorf("Got parent span id %s, expected the one from the header", parentID)
+		}
+	})
 }
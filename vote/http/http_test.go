@@ -303,6 +303,67 @@ func TestHandleClearAll(t *testing.T) {
 	})
 }
 
+type pollMigraterStub struct {
+	id        int
+	backend   string
+	expectErr error
+}
+
+func (m *pollMigraterStub) MigratePoll(ctx context.Context, pollID int, backend string) error {
+	m.id = pollID
+	m.backend = backend
+	return m.expectErr
+}
+
+func TestHandleMigrate(t *testing.T) {
+	migrater := &pollMigraterStub{}
+
+	url := "/vote/migrate"
+	mux := handleInternal(handleMigrate(migrater))
+
+	t.Run("No id", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url+"?backend=long", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("Got status %s, expected 400 - Bad Request", resp.Result().Status)
+		}
+	})
+
+	t.Run("No backend", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url+"?id=1", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("Got status %s, expected 400 - Bad Request", resp.Result().Status)
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url+"?id=1&backend=long", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Errorf("Got status %s, expected 200 - OK", resp.Result().Status)
+		}
+
+		if migrater.id != 1 || migrater.backend != "long" {
+			t.Errorf("Migrater was called with id %d and backend %s, expected 1 and long", migrater.id, migrater.backend)
+		}
+	})
+
+	t.Run("Invalid error", func(t *testing.T) {
+		migrater.expectErr = vote.ErrInvalid
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url+"?id=1&backend=other", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("Got status %s, expected 400", resp.Result().Status)
+		}
+	})
+}
+
 type voterStub struct {
 	id        int
 	user      int
@@ -460,6 +521,48 @@ func TestHandleVote(t *testing.T) {
 			t.Errorf("Voter was called with body `%s` expected `request body`", voter.body)
 		}
 	})
+
+	t.Run("Idempotency key too long", func(t *testing.T) {
+		auther.userID = 5
+		voter.expectErr = nil
+
+		req := httptest.NewRequest("POST", url+"?id=1", strings.NewReader("request body"))
+		req.Header.Set("Idempotency-Key", strings.Repeat("x", 256))
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("Got status %s, expected 400", resp.Result().Status)
+		}
+	})
+
+	t.Run("Idempotency conflict", func(t *testing.T) {
+		auther.userID = 5
+		voter.expectErr = vote.ErrConflict
+
+		req := httptest.NewRequest("POST", url+"?id=1", strings.NewReader("request body"))
+		req.Header.Set("Idempotency-Key", "my-key")
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		if resp.Result().StatusCode != 409 {
+			t.Errorf("Got status %s, expected 409", resp.Result().Status)
+		}
+
+		var body struct {
+			Error string `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding resp body: %v", err)
+		}
+
+		if body.Error != "conflict" {
+			t.Errorf("Got error `%s`, expected `conflict`", body.Error)
+		}
+	})
 }
 
 type votederStub struct {
@@ -597,20 +700,33 @@ func TestHandleVoted(t *testing.T) {
 
 type allLiveVotesStub struct {
 	expectCount map[int]map[int]*string
+	filter      vote.LiveVotesFilter
+	changes     chan map[int]map[int]*string
 }
 
-func (v *allLiveVotesStub) AllLiveVotes(ctx context.Context) map[int]map[int]*string {
-	return v.expectCount
+func (v *allLiveVotesStub) SubscribeLiveVotes(ctx context.Context, filter vote.LiveVotesFilter) (map[int]map[int]*string, <-chan map[int]map[int]*string, func()) {
+	v.filter = filter
+	if v.changes == nil {
+		v.changes = make(chan map[int]map[int]*string)
+	}
+
+	if len(filter.PollIDs) == 0 {
+		return v.expectCount, v.changes, func() {}
+	}
+
+	out := make(map[int]map[int]*string)
+	for _, pollID := range filter.PollIDs {
+		if userID2Vote, ok := v.expectCount[pollID]; ok {
+			out[pollID] = userID2Vote
+		}
+	}
+	return out, v.changes, func() {}
 }
 
 func TestHandleAllVotedIDs_first_data(t *testing.T) {
 	voteCounter := &allLiveVotesStub{}
 
-	eventer := func() (<-chan time.Time, func()) {
-		return make(chan time.Time), func() {}
-	}
-
-	mux := handleAllVotedIDs(voteCounter, eventer)
+	mux := handleAllVotedIDs(voteCounter, nil)
 
 	ctx := t.Context()
 
@@ -643,11 +759,7 @@ func TestHandleAllVotedIDs_first_data(t *testing.T) {
 func TestHandleAllVotedIDs_first_data_empty(t *testing.T) {
 	voteCounter := &allLiveVotesStub{}
 
-	eventer := func() (<-chan time.Time, func()) {
-		return make(chan time.Time), func() {}
-	}
-
-	mux := handleAllVotedIDs(voteCounter, eventer)
+	mux := handleAllVotedIDs(voteCounter, nil)
 
 	ctx := t.Context()
 
@@ -678,25 +790,27 @@ func TestHandleAllVotedIDs_first_data_empty(t *testing.T) {
 func TestHandleAllVotedIDs_second_data(t *testing.T) {
 	voteCounter := &allLiveVotesStub{}
 
-	event := make(chan time.Time, 1)
-	eventer := func() (<-chan time.Time, func()) {
-		return event, func() {}
-	}
-
-	mux := handleAllVotedIDs(voteCounter, eventer)
+	mux := handleAllVotedIDs(voteCounter, nil)
 
 	ctx := context.Background()
 
 	vote1Str := "vote"
 	vote2Str := "vote2"
 
-	data := []map[int]map[int]*string{
-		{1: {1: nil, 2: nil}, 2: {20: &vote1Str}},
-		{1: {1: nil, 2: nil, 3: nil}, 2: {20: &vote1Str}}, // Change only 1
-		{1: {1: nil, 2: nil, 3: nil}, 2: {20: &vote1Str}}, // No Change
-		{1: {1: nil, 2: nil, 3: nil}},                     // Remove 2
-		{1: {1: nil, 2: nil, 3: nil}, 3: {30: &vote2Str}}, // Add 3
-		{1: {1: nil, 2: nil, 3: nil}},                     // Remove 3 (that was not there at the beginning)
+	voteCounter.expectCount = map[int]map[int]*string{1: {1: nil, 2: nil}, 2: {20: &vote1Str}}
+	changes := []map[int]map[int]*string{
+		{1: {3: nil}},        // Change only 1
+		{2: {20: &vote1Str}}, // No Change
+		{2: nil},             // Remove 2
+		{3: {30: &vote2Str}}, // Add 3
+		{3: nil},             // Remove 3 (that was not there at the beginning)
+		{4: nil},             // Remove 4 (that is unknown)
+		{},                   // Empty change
+	}
+
+	voteCounter.changes = make(chan map[int]map[int]*string, len(changes))
+	for _, change := range changes {
+		voteCounter.changes <- change
 	}
 
 	url := "/vote/vote_count"
@@ -707,19 +821,7 @@ func TestHandleAllVotedIDs_second_data(t *testing.T) {
 	defer cancel()
 	req, _ := http.NewRequestWithContext(reqCtx, "GET", url, nil)
 
-	voteCounter.expectCount = data[0]
-	i := 0
-	flushResp := onFlush{resp, func() {
-		i++
-		if i >= len(data) {
-			close(event)
-			return
-		}
-		voteCounter.expectCount = data[i]
-		event <- time.Now()
-	}}
-
-	mux.ServeHTTP(flushResp, req)
+	mux.ServeHTTP(resp, req)
 
 	if resp.Result().StatusCode != 200 {
 		t.Fatalf("Got status %s, expected 200", resp.Result().Status)
@@ -748,6 +850,58 @@ func TestHandleAllVotedIDs_second_data(t *testing.T) {
 			t.Errorf("Data %d: Got %v, expected %v", i+1, got, expect[i])
 		}
 	}
+
+	if decoder.More() {
+		t.Errorf("Got more packages, expected %d", len(expect))
+	}
+}
+
+func TestHandleAllVotedIDs_filter(t *testing.T) {
+	voteCounter := &allLiveVotesStub{}
+
+	mux := handleInternal(handleAllVotedIDs(voteCounter, nil))
+
+	voteCounter.expectCount = map[int]map[int]*string{1: {1: nil}, 2: {2: nil}, 3: {3: nil}}
+
+	t.Run("ids and meeting", func(t *testing.T) {
+		// TODO: find a better way then a timeout
+		reqCtx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(reqCtx, "GET", "/vote/live_votes?ids=1,3&meeting_id=7", nil)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, req)
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("Got status %s, expected 200", resp.Result().Status)
+		}
+
+		expectFilter := vote.LiveVotesFilter{PollIDs: []int{1, 3}, MeetingID: 7}
+		if !reflect.DeepEqual(voteCounter.filter, expectFilter) {
+			t.Errorf("Got filter %v, expected %v", voteCounter.filter, expectFilter)
+		}
+
+		var got map[int]map[int]*string
+		if err := json.NewDecoder(resp.Result().Body).Decode(&got); err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+
+		expect := map[int]map[int]*string{1: {1: nil}, 3: {3: nil}}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got %v, expected %v", got, expect)
+		}
+	})
+
+	t.Run("invalid meeting id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/vote/live_votes?meeting_id=abc", nil)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, req)
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("Got status %s, expected 400", resp.Result().Status)
+		}
+	})
 }
 
 func resolvePointers(in map[int]map[int]*string) map[int]map[int]string {
@@ -786,14 +940,38 @@ func TestHandleHealth(t *testing.T) {
 	}
 }
 
-type onFlush struct {
-	http.ResponseWriter
-	f func()
-}
+func TestHandleMetrics(t *testing.T) {
+	mux := handleInternal(handleMetrics())
 
-func (f onFlush) Flush() {
-	f.f()
-	if flusher, ok := f.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Errorf("Got status %s, expected 200 - OK", resp.Result().Status)
 	}
+
+	if got := resp.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Got content type %s, expected prometheus text format", got)
+	}
+
+	t.Run("with internal auth", func(t *testing.T) {
+		secret := []byte("my secret")
+		mux := handleInternal(internalAuth{mode: "hmac", secret: secret, nonces: newNonceCache(), now: time.Now}.wrap(handleMetrics()))
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Errorf("Got status %s without signature, expected 401", resp.Result().Status)
+		}
+
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		signRequest(req, secret, time.Now())
+		resp = httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		if resp.Result().StatusCode != 200 {
+			t.Errorf("Got status %s with signature, expected 200 - OK", resp.Result().Status)
+		}
+	})
 }
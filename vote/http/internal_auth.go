@@ -0,0 +1,252 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+var (
+	envInternalAuth           = environment.NewVariable("VOTE_INTERNAL_AUTH", "none", "Authentication of the internal routes. One of `none`, `hmac` or `mtls`.")
+	envInternalAuthSecretFile = environment.NewVariable("VOTE_INTERNAL_AUTH_SECRET_FILE", "/run/secrets/internal_auth_password", "Shared secret to sign requests to the internal routes. Only used with VOTE_INTERNAL_AUTH=hmac.")
+	envInternalAuthCAFile     = environment.NewVariable("VOTE_INTERNAL_AUTH_CA_FILE", "", "CA to verify client certificates on the internal routes. Only used with VOTE_INTERNAL_AUTH=mtls.")
+)
+
+const (
+	headerInternalTimestamp = "X-Vote-Timestamp"
+	headerInternalNonce     = "X-Vote-Nonce"
+	headerInternalSignature = "X-Vote-Signature"
+
+	// internalAuthMaxSkew is the maximal difference between the timestamp of
+	// a signed request and the time of the server.
+	internalAuthMaxSkew = 30 * time.Second
+
+	// internalAuthMaxNonceLength is the maximal length of the header
+	// X-Vote-Nonce.
+	internalAuthMaxNonceLength = 128
+)
+
+// internalAuth checks requests to the internal routes.
+//
+// With mode `hmac`, each request needs the header X-Vote-Timestamp with the
+// current unix time, the header X-Vote-Nonce with a random value and the header
+// X-Vote-Signature with the hex encoded HMAC-SHA256 of the method, the path
+// with query, the timestamp and the nonce, each separated by a newline. Each
+// nonce can only be used once. The used nonces are only known to one instance,
+// so a request could be replayed once to each other instance of the service.
+//
+// With mode `mtls`, each request needs a client certificate signed by the
+// configured CA.
+type internalAuth struct {
+	mode   string
+	secret []byte
+	nonces *nonceCache
+	caPool *x509.CertPool
+	now    func() time.Time
+}
+
+func newInternalAuth(lookup environment.Environmenter) (internalAuth, error) {
+	// All environment variables have to be called in this function and not in
+	// a sub function. In other case they will not be included in the generated
+	// file environment.md.
+	mode := envInternalAuth.Value(lookup)
+	envInternalAuthSecretFile.Value(lookup)
+	caFile := envInternalAuthCAFile.Value(lookup)
+
+	auth := internalAuth{
+		mode: mode,
+		now:  time.Now,
+	}
+
+	switch mode {
+	case "none":
+		return auth, nil
+
+	case "hmac":
+		secret, err := environment.ReadSecret(lookup, envInternalAuthSecretFile)
+		if err != nil {
+			return internalAuth{}, fmt.Errorf("reading internal auth secret: %w", err)
+		}
+
+		if secret == "" {
+			return internalAuth{}, fmt.Errorf("internal auth secret is empty")
+		}
+
+		auth.secret = []byte(secret)
+		auth.nonces = newNonceCache()
+		return auth, nil
+
+	case "mtls":
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return internalAuth{}, fmt.Errorf("reading internal auth ca: %w", err)
+		}
+
+		auth.caPool = x509.NewCertPool()
+		if !auth.caPool.AppendCertsFromPEM(pem) {
+			return internalAuth{}, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		return auth, nil
+
+	default:
+		return internalAuth{}, fmt.Errorf("invalid value for %s: %s", envInternalAuth.Key, mode)
+	}
+}
+
+// wrap returns a handler that checks the request before calling the given
+// handler.
+//
+// It also adds the caller to the context for the audit log.
+func (a internalAuth) wrap(handler Handler) Handler {
+	return HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if a.mode != "none" && a.mode != "" {
+			if err := a.check(r); err != nil {
+				log.InfoContext(r.Context(), "Rejected internal request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+				return statusCode(401, vote.MessageError(vote.ErrNotAllowed, "Request to the internal route is not authenticated"))
+			}
+		}
+
+		ctx := vote.WithCaller(r.Context(), a.caller(r))
+		return handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// caller returns the identity of an authenticated request.
+//
+// With mtls, it is the common name of the client certificate. The other modes
+// have no identity, so the ip address of the client is used.
+func (a internalAuth) caller(r *http.Request) string {
+	if a.mode == "mtls" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "mtls/" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	mode := a.mode
+	if mode == "" {
+		mode = "none"
+	}
+	return mode + "/" + clientIP(r)
+}
+
+func (a internalAuth) check(r *http.Request) error {
+	switch a.mode {
+	case "hmac":
+		return a.checkHMAC(r)
+	case "mtls":
+		return a.checkClientCert(r)
+	default:
+		return fmt.Errorf("unknown mode %s", a.mode)
+	}
+}
+
+func (a internalAuth) checkHMAC(r *http.Request) error {
+	rawTimestamp := r.Header.Get(headerInternalTimestamp)
+	nonce := r.Header.Get(headerInternalNonce)
+	rawSignature := r.Header.Get(headerInternalSignature)
+	if rawTimestamp == "" || nonce == "" || rawSignature == "" {
+		return fmt.Errorf("missing signature headers")
+	}
+
+	if len(nonce) > internalAuthMaxNonceLength {
+		return fmt.Errorf("nonce is longer then %d bytes", internalAuthMaxNonceLength)
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", rawTimestamp)
+	}
+
+	skew := a.now().Sub(time.Unix(timestamp, 0))
+	if skew > internalAuthMaxSkew || skew < -internalAuthMaxSkew {
+		return fmt.Errorf("timestamp %d is outside of the allowed window", timestamp)
+	}
+
+	signature, err := hex.DecodeString(rawSignature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	if !hmac.Equal(signature, internalSignature(a.secret, r.Method, r.URL.RequestURI(), rawTimestamp, nonce)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	// The nonce is saved after the signature is checked, so unsigned requests
+	// can not fill the cache.
+	if !a.nonces.use(nonce, a.now()) {
+		return fmt.Errorf("nonce %s was already used", nonce)
+	}
+
+	return nil
+}
+
+func (a internalAuth) checkClientCert(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("no client certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.caPool,
+		Intermediates: intermediates,
+		CurrentTime:   a.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("verify client certificate: %w", err)
+	}
+
+	return nil
+}
+
+// internalSignature returns the HMAC-SHA256 for a request to an internal route.
+func internalSignature(secret []byte, method, requestURI, timestamp, nonce string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, timestamp, nonce)
+	return mac.Sum(nil)
+}
+
+// nonceCache remembers the nonces of the signed requests.
+//
+// A nonce is only needed as long as its timestamp is valid. Since the timestamp
+// is not saved, each nonce is kept for two times internalAuthMaxSkew.
+type nonceCache struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{used: make(map[string]time.Time)}
+}
+
+// use saves the nonce. Returns false, if the nonce was already used.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n, expires := range c.used {
+		if now.After(expires) {
+			delete(c.used, n)
+		}
+	}
+
+	if _, ok := c.used[nonce]; ok {
+		return false
+	}
+
+	c.used[nonce] = now.Add(2 * internalAuthMaxSkew)
+	return true
+}
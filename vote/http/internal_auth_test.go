@@ -0,0 +1,307 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+)
+
+func signRequest(r *http.Request, secret []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonce := rand.Text()
+	r.Header.Set(headerInternalTimestamp, timestamp)
+	r.Header.Set(headerInternalNonce, nonce)
+	r.Header.Set(headerInternalSignature, hex.EncodeToString(internalSignature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce)))
+}
+
+func TestInternalAuthHMAC(t *testing.T) {
+	now := time.Now()
+	secret := []byte("my secret")
+	auth := internalAuth{
+		mode:   "hmac",
+		secret: secret,
+		nonces: newNonceCache(),
+		now:    func() time.Time { return now },
+	}
+
+	starter := &starterStub{}
+	mux := handleInternal(auth.wrap(handleStart(starter)))
+
+	for _, tt := range []struct {
+		name         string
+		request      func() *http.Request
+		expectStatus int
+	}{
+		{
+			"no signature",
+			func() *http.Request {
+				return httptest.NewRequest("POST", "/vote/start?id=1", nil)
+			},
+			401,
+		},
+		{
+			"valid signature",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+				signRequest(r, secret, now)
+				return r
+			},
+			200,
+		},
+		{
+			"wrong secret",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+				signRequest(r, []byte("other secret"), now)
+				return r
+			},
+			401,
+		},
+		{
+			"old timestamp",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+				signRequest(r, secret, now.Add(-time.Minute))
+				return r
+			},
+			401,
+		},
+		{
+			"changed query",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+				signRequest(r, secret, now)
+				r.URL.RawQuery = "id=2"
+				return r
+			},
+			401,
+		},
+		{
+			"changed nonce",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+				signRequest(r, secret, now)
+				r.Header.Set(headerInternalNonce, "other")
+				return r
+			},
+			401,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, tt.request())
+
+			if resp.Result().StatusCode != tt.expectStatus {
+				t.Errorf("Got status %s, expected %d", resp.Result().Status, tt.expectStatus)
+			}
+		})
+	}
+}
+
+func TestInternalAuthHMACReplay(t *testing.T) {
+	now := time.Now()
+	secret := []byte("my secret")
+	auth := internalAuth{
+		mode:   "hmac",
+		secret: secret,
+		nonces: newNonceCache(),
+		now:    func() time.Time { return now },
+	}
+
+	mux := handleInternal(auth.wrap(handleStart(&starterStub{})))
+
+	r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+	signRequest(r, secret, now)
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, r.Clone(r.Context()))
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("Got status %s on first request, expected 200", resp.Result().Status)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, r.Clone(r.Context()))
+	if resp.Result().StatusCode != 401 {
+		t.Errorf("Got status %s on replayed request, expected 401", resp.Result().Status)
+	}
+
+	// After the timestamp is invalid, the nonce is removed from the cache.
+	now = now.Add(3 * internalAuthMaxSkew)
+	auth.nonces.use("other", now)
+	if len(auth.nonces.used) != 1 {
+		t.Errorf("Nonce cache has %d entries, expected 1", len(auth.nonces.used))
+	}
+}
+
+func TestInternalAuthMTLS(t *testing.T) {
+	ca, caKey := createCert(t, nil, nil, true)
+	clientCert, _ := createCert(t, ca, caKey, false)
+	otherCA, otherCAKey := createCert(t, nil, nil, true)
+	otherClientCert, _ := createCert(t, otherCA, otherCAKey, false)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	auth := internalAuth{
+		mode:   "mtls",
+		caPool: caPool,
+		now:    time.Now,
+	}
+
+	starter := &starterStub{}
+	mux := handleInternal(auth.wrap(handleStart(starter)))
+
+	for _, tt := range []struct {
+		name         string
+		cert         *x509.Certificate
+		expectStatus int
+	}{
+		{"no certificate", nil, 401},
+		{"valid certificate", clientCert, 200},
+		{"certificate from other ca", otherClientCert, 401},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, r)
+
+			if resp.Result().StatusCode != tt.expectStatus {
+				t.Errorf("Got status %s, expected %d", resp.Result().Status, tt.expectStatus)
+			}
+		})
+	}
+}
+
+// createCert creates a certificate. If parent is nil, the certificate is self
+// signed.
+func createCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+
+	return cert, key
+}
+
+func TestInternalAuthCaller(t *testing.T) {
+	ca, caKey := createCert(t, nil, nil, true)
+	clientCert, _ := createCert(t, ca, caKey, false)
+
+	for _, tt := range []struct {
+		name   string
+		mode   string
+		cert   *x509.Certificate
+		expect string
+	}{
+		{"none", "none", nil, "none/192.0.2.1"},
+		{"hmac", "hmac", nil, "hmac/192.0.2.1"},
+		{"mtls", "mtls", clientCert, "mtls/test"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/vote/start?id=1", nil)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+
+			if got := (internalAuth{mode: tt.mode}).caller(r); got != tt.expect {
+				t.Errorf("caller() == %q, expected %q", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestNewInternalAuthMTLSRequiresTLS(t *testing.T) {
+	ca, caKey := createCert(t, nil, nil, true)
+	serverCert, serverKey := createCert(t, ca, caKey, false)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertFiles(t, caFile, filepath.Join(dir, "ca-key.pem"), ca, caKey, time.Now())
+	writeCertFiles(t, certFile, keyFile, serverCert, serverKey, time.Now())
+
+	for _, tt := range []struct {
+		name      string
+		env       map[string]string
+		expectErr bool
+	}{
+		{
+			"without tls",
+			map[string]string{},
+			true,
+		},
+		{
+			"without client ca",
+			map[string]string{"VOTE_TLS_CERT_FILE": certFile, "VOTE_TLS_KEY_FILE": keyFile},
+			true,
+		},
+		{
+			"with client ca",
+			map[string]string{"VOTE_TLS_CERT_FILE": certFile, "VOTE_TLS_KEY_FILE": keyFile, "VOTE_TLS_CLIENT_CA_FILE": caFile},
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["VOTE_INTERNAL_AUTH"] = "mtls"
+			tt.env["VOTE_INTERNAL_AUTH_CA_FILE"] = caFile
+
+			_, err := New(environment.ForTests(tt.env))
+			if gotErr := err != nil; gotErr != tt.expectErr {
+				t.Errorf("New returned error %v, expected error: %t", err, tt.expectErr)
+			}
+		})
+	}
+}
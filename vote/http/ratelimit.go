@@ -0,0 +1,227 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+var (
+	envRateLimitUser      = environment.NewVariable("VOTE_RATE_LIMIT_USER", "10", "Requests per second, that a user can send to the vote and voted routes. 0 disables the limit.")
+	envRateLimitUserBurst = environment.NewVariable("VOTE_RATE_LIMIT_USER_BURST", "20", "Number of requests, that a user can send at once before the rate limit is used.")
+	envRateLimitIP        = environment.NewVariable("VOTE_RATE_LIMIT_IP", "0", "Requests per second, that a client IP can send to the vote and voted routes. 0 disables the limit.")
+	envRateLimitIPBurst   = environment.NewVariable("VOTE_RATE_LIMIT_IP_BURST", "100", "Number of requests, that a client IP can send at once before the rate limit is used.")
+)
+
+// TokenBucket is a storage for token buckets used for rate limiting.
+type TokenBucket interface {
+	// TakeToken takes a token from the bucket with the given key. The bucket
+	// gets rate tokens per second and can hold at most burst tokens.
+	//
+	// Returns 0, if a token was taken. If the bucket was empty, it returns the
+	// duration until the next token is available.
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+}
+
+// rateLimit is the configuration for one kind of rate limit.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+func (l rateLimit) enabled() bool {
+	return l.rate > 0
+}
+
+func parseRateLimit(lookup environment.Environmenter, rateVar, burstVar environment.Variable) (rateLimit, error) {
+	rate, err := strconv.ParseFloat(rateVar.Value(lookup), 64)
+	if err != nil {
+		return rateLimit{}, fmt.Errorf("invalid value for %s: %w", rateVar.Key, err)
+	}
+
+	burst, err := strconv.Atoi(burstVar.Value(lookup))
+	if err != nil {
+		return rateLimit{}, fmt.Errorf("invalid value for %s: %w", burstVar.Key, err)
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rateLimit{rate: rate, burst: burst}, nil
+}
+
+// rateLimitAuth is an authenticater that rejects requests, when the client or
+// the user sends too many requests.
+//
+// The ip limit is checked before the request is authenticated. The user limit
+// afterwards.
+type rateLimitAuth struct {
+	authenticater
+	bucket TokenBucket
+	user   rateLimit
+	ip     rateLimit
+}
+
+func (a rateLimitAuth) Authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	if a.ip.enabled() {
+		if err := a.takeToken(w, r.Context(), "ip:"+clientIP(r), a.ip); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, err := a.authenticater.Authenticate(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid := a.FromContext(ctx); uid != 0 && a.user.enabled() {
+		if err := a.takeToken(w, ctx, "user:"+strconv.Itoa(uid), a.user); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctx, nil
+}
+
+func (a rateLimitAuth) takeToken(w http.ResponseWriter, ctx context.Context, key string, limit rateLimit) error {
+	wait := a.wait(ctx, key, limit)
+	if wait == 0 {
+		return nil
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return statusCode(429, rateLimitError{})
+}
+
+// takeUserToken takes a token from the bucket of the user. It is used for
+// requests, that are not http requests, like the messages of a websocket.
+func (a rateLimitAuth) takeUserToken(ctx context.Context, uid int) error {
+	if !a.user.enabled() {
+		return nil
+	}
+
+	if a.wait(ctx, "user:"+strconv.Itoa(uid), a.user) != 0 {
+		return rateLimitError{}
+	}
+	return nil
+}
+
+// wait takes a token and returns the duration until the next token is
+// available, if the bucket was empty.
+func (a rateLimitAuth) wait(ctx context.Context, key string, limit rateLimit) time.Duration {
+	wait, err := a.bucket.TakeToken(ctx, key, limit.rate, limit.burst)
+	if err != nil {
+		// Do not block the users, if the rate limit storage is not available.
+		log.ErrorContext(ctx, "Taking rate limit token", "key", key, "error", err)
+		return 0
+	}
+
+	if wait != 0 {
+		log.DebugContext(ctx, "Rate limit reached", "key", key)
+	}
+	return wait
+}
+
+// clientIP returns the ip address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type rateLimitError struct{}
+
+func (rateLimitError) Error() string {
+	return "Too many requests"
+}
+
+func (rateLimitError) Type() string {
+	return "too-many-requests"
+}
+
+// memoryTokenBucket implements the TokenBucket interface by saving the buckets
+// in memory.
+//
+// It can only be used, if the service is not scaled horizontally.
+type memoryTokenBucket struct {
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+// bucket is a token bucket. It saves its rate and burst, so it can be checked
+// without the limit of the caller.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// full tells, if the bucket would be full at the given time.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= float64(b.burst)
+}
+
+func newMemoryTokenBucket() *memoryTokenBucket {
+	return &memoryTokenBucket{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// memoryBucketCleanupInterval is the time between two removals of the full
+// buckets.
+const memoryBucketCleanupInterval = time.Minute
+
+func (m *memoryTokenBucket) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	if now.Sub(m.lastCleanup) >= memoryBucketCleanupInterval {
+		m.removeFull(now)
+		m.lastCleanup = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+
+	b.rate = rate
+	b.burst = burst
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+// removeFull removes all buckets that would be full at the given time. A
+// removed bucket is the same as a new bucket.
+func (m *memoryTokenBucket) removeFull(now time.Time) {
+	for key, b := range m.buckets {
+		if b.full(now) {
+			delete(m.buckets, key)
+		}
+	}
+}
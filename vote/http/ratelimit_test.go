@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	bucket := newMemoryTokenBucket()
+	bucket.now = func() time.Time { return now }
+
+	for i := range 3 {
+		wait, err := bucket.TakeToken(ctx, "key", 1, 3)
+		if err != nil {
+			t.Fatalf("TakeToken: %v", err)
+		}
+
+		if wait != 0 {
+			t.Fatalf("TakeToken %d returned wait %s, expected 0", i, wait)
+		}
+	}
+
+	wait, err := bucket.TakeToken(ctx, "key", 1, 3)
+	if err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+
+	if wait != time.Second {
+		t.Errorf("TakeToken on empty bucket returned wait %s, expected 1s", wait)
+	}
+
+	wait, _ = bucket.TakeToken(ctx, "other key", 1, 3)
+	if wait != 0 {
+		t.Errorf("TakeToken with other key returned wait %s, expected 0", wait)
+	}
+
+	now = now.Add(time.Second)
+
+	wait, _ = bucket.TakeToken(ctx, "key", 1, 3)
+	if wait != 0 {
+		t.Errorf("TakeToken after one second returned wait %s, expected 0", wait)
+	}
+}
+
+func TestMemoryTokenBucketCleanup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	bucket := newMemoryTokenBucket()
+	bucket.now = func() time.Time { return now }
+
+	// The first call removes nothing, but sets the time of the cleanup.
+	bucket.TakeToken(ctx, "user", 1, 1)
+	for range 50 {
+		bucket.TakeToken(ctx, "ip", 0.1, 100)
+	}
+
+	// The user bucket is full again. The ip bucket is still half empty, even
+	// with the limit of the user.
+	now = now.Add(memoryBucketCleanupInterval)
+	bucket.TakeToken(ctx, "other", 1, 1)
+
+	if _, ok := bucket.buckets["user"]; ok {
+		t.Errorf("Full bucket was not removed")
+	}
+
+	if _, ok := bucket.buckets["ip"]; !ok {
+		t.Errorf("Half empty bucket was removed")
+	}
+}
+
+func TestRateLimitAuth(t *testing.T) {
+	auther := rateLimitAuth{
+		authenticater: &autherStub{userID: 5},
+		bucket:        newMemoryTokenBucket(),
+		user:          rateLimit{rate: 1, burst: 1},
+	}
+
+	mux := handleExternal(handleVote(&voterStub{}, auther))
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/vote?id=1", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Errorf("First request returned status %s, expected 200", resp.Result().Status)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/vote?id=1", nil))
+
+	if resp.Result().StatusCode != 429 {
+		t.Errorf("Second request returned status %s, expected 429", resp.Result().Status)
+	}
+
+	if got := resp.Result().Header.Get("Retry-After"); got != "1" {
+		t.Errorf("Got Retry-After `%s`, expected `1`", got)
+	}
+}
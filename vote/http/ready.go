@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+// readyCheckTimeout is the maximal time of one check of the readiness endpoint.
+const readyCheckTimeout = 2 * time.Second
+
+// ReadyCheck checks, if a dependency of the service is reachable.
+type ReadyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// readyStatus is the result of one ReadyCheck.
+type readyStatus struct {
+	Ready     bool    `json:"ready"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// handleReady runs all checks at the same time. If one dependency is not
+// reachable, it returns the status code 503.
+//
+// With details, the status of each dependency is returned. This is only used
+// on the internal route. The errors are only logged.
+func handleReady(checks []ReadyCheck, details bool) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+
+		status := make(map[string]readyStatus, len(checks))
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range checks {
+			wg.Go(func() {
+				ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+				defer cancel()
+
+				start := time.Now()
+				err := check.Check(ctx)
+				latency := time.Since(start)
+
+				if err != nil {
+					log.InfoContext(r.Context(), "Dependency is not ready", "dependency", check.Name, "error", err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				status[check.Name] = readyStatus{
+					Ready:     err == nil,
+					LatencyMS: float64(latency.Microseconds()) / 1000,
+				}
+			})
+		}
+		wg.Wait()
+
+		ready := true
+		for _, s := range status {
+			ready = ready && s.Ready
+		}
+
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if !details {
+			status = nil
+		}
+
+		out := struct {
+			Ready   bool                   `json:"ready"`
+			Service string                 `json:"service"`
+			Checks  map[string]readyStatus `json:"checks,omitempty"`
+		}{
+			Ready:   ready,
+			Service: "vote",
+			Checks:  status,
+		}
+
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			return fmt.Errorf("encoding and sending ready status: %w", err)
+		}
+		return nil
+	}
+}
+
+// AuthServiceCheck returns a check, that sends a request to the health route of
+// the auth service. It returns nil, if the auth service is not used.
+//
+// The environment variables are defined and documented by the auth package of
+// openslides-go, so they are read directly.
+func AuthServiceCheck(lookup environment.Environmenter) func(ctx context.Context) error {
+	if fake, _ := strconv.ParseBool(lookup.Getenv("AUTH_FAKE")); fake {
+		return nil
+	}
+
+	url := fmt.Sprintf(
+		"%s://%s:%s/system/auth/health",
+		valueOrDefault(lookup.Getenv("AUTH_PROTOCOL"), "http"),
+		valueOrDefault(lookup.Getenv("AUTH_HOST"), "localhost"),
+		valueOrDefault(lookup.Getenv("AUTH_PORT"), "9004"),
+	)
+
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("sending request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("auth service returned status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
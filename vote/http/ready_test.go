@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandleReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused to secret-host") }
+
+	t.Run("All ready", func(t *testing.T) {
+		mux := handleInternal(handleReady([]ReadyCheck{{"fast_backend", ok}, {"datastore", ok}}, true))
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/internal/vote/ready", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Errorf("Got status %s, expected 200 - OK", resp.Result().Status)
+		}
+
+		var body struct {
+			Ready  bool                   `json:"ready"`
+			Checks map[string]readyStatus `json:"checks"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding body: %v", err)
+		}
+
+		if !body.Ready || len(body.Checks) != 2 || !body.Checks["fast_backend"].Ready || !body.Checks["datastore"].Ready {
+			t.Errorf("Got body %+v, expected everything ready", body)
+		}
+	})
+
+	t.Run("One not ready", func(t *testing.T) {
+		mux := handleInternal(handleReady([]ReadyCheck{{"fast_backend", ok}, {"datastore", fail}}, true))
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/internal/vote/ready", nil))
+
+		if resp.Result().StatusCode != 503 {
+			t.Errorf("Got status %s, expected 503 - Service Unavailable", resp.Result().Status)
+		}
+
+		if strings.Contains(resp.Body.String(), "secret-host") {
+			t.Errorf("Body contains the error: %s", resp.Body.String())
+		}
+
+		var body struct {
+			Ready  bool                   `json:"ready"`
+			Checks map[string]readyStatus `json:"checks"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding body: %v", err)
+		}
+
+		if body.Ready || !body.Checks["fast_backend"].Ready || body.Checks["datastore"].Ready {
+			t.Errorf("Got body %+v, expected only the datastore not ready", body)
+		}
+	})
+
+	t.Run("Without details", func(t *testing.T) {
+		mux := handleExternal(handleReady([]ReadyCheck{{"fast_backend", ok}, {"datastore", fail}}, false))
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/vote/ready", nil))
+
+		if resp.Result().StatusCode != 503 {
+			t.Errorf("Got status %s, expected 503 - Service Unavailable", resp.Result().Status)
+		}
+
+		expect := `{"ready":false,"service":"vote"}` + "\n"
+		if got := resp.Body.String(); got != expect {
+			t.Errorf("Got body `%s`, expected `%s`", got, expect)
+		}
+	})
+}
+
+func TestHealthClientReady(t *testing.T) {
+	var notReady atomic.Bool
+	srv := httptest.NewServer(handleExternal(handleReady([]ReadyCheck{
+		{"datastore", func(context.Context) error {
+			if notReady.Load() {
+				return errors.New("not ready")
+			}
+			return nil
+		}},
+	}, false)))
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("parsing address: %v", err)
+	}
+
+	if err := HealthClient(t.Context(), false, host, port, false, "", "", true); err != nil {
+		t.Errorf("HealthClient returned: %v", err)
+	}
+
+	notReady.Store(true)
+	if err := HealthClient(t.Context(), false, host, port, false, "", "", true); err == nil {
+		t.Errorf("HealthClient returned no error for a service, that is not ready")
+	}
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerRequestID is the header with the correlation id of a request.
+const headerRequestID = "X-Request-ID"
+
+// maxRequestIDLength is the maximal length of a request id from the client.
+const maxRequestIDLength = 64
+
+// withRequestID gives each request a correlation id.
+//
+// The id is taken from the X-Request-ID header or created. It is returned in
+// the same header and added to all logs of the request.
+func withRequestID(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(headerRequestID)
+		if !validRequestID(requestID) {
+			requestID = rand.Text()
+		}
+		w.Header().Set(headerRequestID, requestID)
+
+		fields := []any{"request_id", requestID, "route", route}
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			fields = append(fields, "trace_id", spanContext.TraceID().String())
+		}
+		ctx := log.With(r.Context(), fields...)
+
+		start := time.Now()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		log.DebugContext(ctx, "Request done", "duration", time.Since(start))
+	})
+}
+
+// validRequestID returns true, if the request id from a client can be used.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+func TestWithRequestID(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := log.Setup(buf, log.FormatText, false); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	t.Cleanup(func() { log.Setup(io.Discard, log.FormatText, false) })
+
+	handler := withRequestID("/system/vote", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.InfoContext(r.Context(), "in handler")
+	}))
+
+	t.Run("From client", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest("POST", "/system/vote", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if got := resp.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("Got request id %q, expected abc-123", got)
+		}
+
+		if !strings.Contains(buf.String(), "request_id=abc-123") || !strings.Contains(buf.String(), "route=/system/vote") {
+			t.Errorf("Log does not contain the fields of the request: %s", buf)
+		}
+	})
+
+	for _, header := range []string{"", "with space", "a\nb", strings.Repeat("a", 65)} {
+		t.Run("Created for "+header, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("POST", "/system/vote", nil)
+			req.Header.Set("X-Request-ID", header)
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			got := resp.Header().Get("X-Request-ID")
+			if got == "" || got == header {
+				t.Fatalf("Got request id %q, expected a new one", got)
+			}
+
+			if !strings.Contains(buf.String(), "request_id="+got) {
+				t.Errorf("Log does not contain the request id %s: %s", got, buf)
+			}
+		})
+	}
+}
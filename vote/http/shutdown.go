@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+var envShutdownTimeout = environment.NewVariable("VOTE_SHUTDOWN_TIMEOUT", "20s", "Time to finish the running requests on shutdown, for example `20s`. After it, the requests are canceled.")
+
+// shutdownRetryAfter is the value of the header Retry-After, when a vote is
+// rejected during the shutdown. Another instance or the restarted instance
+// should be available by then.
+const shutdownRetryAfter = 5 * time.Second
+
+func parseShutdownTimeout(lookup environment.Environmenter) (time.Duration, error) {
+	rawTimeout := envShutdownTimeout.Value(lookup)
+	timeout, err := time.ParseDuration(rawTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %w", envShutdownTimeout.Key, err)
+	}
+
+	if timeout < 0 {
+		return 0, fmt.Errorf("invalid value for %s: has to be positive", envShutdownTimeout.Key)
+	}
+
+	return timeout, nil
+}
+
+// shutdown stops the server gracefully.
+//
+// When it is called, the context of Run is already done. So the votes are
+// rejected and the streams are closed by the handlers. The other requests can
+// finish until the shutdown timeout. After that, their context is canceled.
+//
+// requests has to count all running requests. http.Server.Shutdown does not
+// wait for hijacked connections like websockets.
+func (s *Server) shutdown(srv *http.Server, requests *sync.WaitGroup, cancelRequests func()) {
+	log.Info("Shutting down, waiting up to %s for running requests", s.shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = waitGroupContext(ctx, requests)
+	}
+
+	if err != nil {
+		log.Info("Canceling requests, that did not finish in %s", s.shutdownTimeout)
+		cancelRequests()
+		srv.Close()
+		requests.Wait()
+	}
+}
+
+// waitGroupContext waits until the wait group is done or the context is done.
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// countRequests adds each request to the wait group until it is done.
+func countRequests(requests *sync.WaitGroup, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		defer requests.Done()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// rejectOnShutdown returns the status code 503, after the shutdown has started.
+func rejectOnShutdown(shutdown <-chan struct{}, handler Handler) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		select {
+		case <-shutdown:
+			w.Header().Set("Retry-After", strconv.Itoa(int(shutdownRetryAfter.Seconds())))
+			return statusCode(http.StatusServiceUnavailable, shutdownError{})
+		default:
+		}
+
+		return handler.ServeHTTP(w, r)
+	}
+}
+
+type shutdownError struct{}
+
+func (shutdownError) Error() string {
+	return "The service is shutting down. Please try again."
+}
+
+func (shutdownError) Type() string {
+	return "shutting-down"
+}
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+func TestRejectOnShutdown(t *testing.T) {
+	handler := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	t.Run("Running", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		handleExternal(rejectOnShutdown(make(chan struct{}), handler)).ServeHTTP(resp, httptest.NewRequest("POST", "/system/vote", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Errorf("Got status %s, expected 200 - OK", resp.Result().Status)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		shutdown := make(chan struct{})
+		close(shutdown)
+
+		resp := httptest.NewRecorder()
+		handleExternal(rejectOnShutdown(shutdown, handler)).ServeHTTP(resp, httptest.NewRequest("POST", "/system/vote", nil))
+
+		if resp.Result().StatusCode != 503 {
+			t.Errorf("Got status %s, expected 503 - Service Unavailable", resp.Result().Status)
+		}
+
+		if got := resp.Result().Header.Get("Retry-After"); got != "5" {
+			t.Errorf("Got Retry-After `%s`, expected `5`", got)
+		}
+
+		if !strings.Contains(resp.Body.String(), "shutting-down") {
+			t.Errorf("Got body `%s`, expected error type shutting-down", resp.Body.String())
+		}
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	// startServer starts a server with a handler, that blocks until release is
+	// closed or the request is canceled.
+	startServer := func(t *testing.T, release <-chan struct{}) (*http.Server, *sync.WaitGroup, func(), string, <-chan struct{}) {
+		t.Helper()
+
+		requestCtx, cancelRequests := context.WithCancel(context.Background())
+		t.Cleanup(cancelRequests)
+
+		started := make(chan struct{})
+		var requests sync.WaitGroup
+		srv := &http.Server{
+			Handler: countRequests(&requests, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-release:
+					w.Write([]byte("done"))
+				case <-r.Context().Done():
+				}
+			})),
+			BaseContext: func(net.Listener) context.Context { return requestCtx },
+		}
+
+		lst, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go srv.Serve(lst)
+
+		return srv, &requests, cancelRequests, lst.Addr().String(), started
+	}
+
+	t.Run("Request finishes", func(t *testing.T) {
+		release := make(chan struct{})
+		srv, requests, cancelRequests, addr, started := startServer(t, release)
+
+		result := make(chan string, 1)
+		go func() {
+			resp, err := http.Get("http://" + addr)
+			if err != nil {
+				result <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			result <- string(body)
+		}()
+		<-started
+
+		shutdownDone := make(chan struct{})
+		go func() {
+			(&Server{shutdownTimeout: 5 * time.Second}).shutdown(srv, requests, cancelRequests)
+			close(shutdownDone)
+		}()
+
+		select {
+		case <-shutdownDone:
+			t.Fatalf("shutdown returned before the request was done")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		<-shutdownDone
+
+		if got := <-result; got != "done" {
+			t.Errorf("Got response `%s`, expected `done`", got)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		srv, requests, cancelRequests, addr, started := startServer(t, nil)
+
+		go func() {
+			resp, err := http.Get("http://" + addr)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+
+		start := time.Now()
+		(&Server{shutdownTimeout: 50 * time.Millisecond}).shutdown(srv, requests, cancelRequests)
+
+		if time.Since(start) > time.Second {
+			t.Errorf("shutdown took %s, expected about 50ms", time.Since(start))
+		}
+	})
+}
+
+func TestHandleAllVotedIDsSSEShutdown(t *testing.T) {
+	voteCounter := &allLiveVotesStub{
+		expectCount: map[int]map[int]*string{},
+		changes:     make(chan map[int]map[int]*string),
+	}
+
+	history := newLiveVotesHistory(voteCounter, vote.LiveVotesFilter{})
+	history.start(t.Context())
+
+	heartbeater := func() (<-chan time.Time, func()) {
+		return nil, func() {}
+	}
+
+	shutdown := make(chan struct{})
+	ts := httptest.NewServer(handleInternal(handleAllVotedIDsSSE(voteCounter, history, heartbeater, shutdown)))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(resp.Body)
+	if got := readEvent(t, body); got.name != "snapshot" {
+		t.Errorf("Got first event %s, expected snapshot", got.name)
+	}
+
+	close(shutdown)
+
+	if got := readEvent(t, body); got.name != "shutdown" {
+		t.Errorf("Got event %s, expected shutdown", got.name)
+	}
+
+	if _, err := body.ReadByte(); err != io.EOF {
+		t.Errorf("Stream was not closed after the shutdown event: %v", err)
+	}
+}
+
+func TestHandleWebsocketShutdown(t *testing.T) {
+	service := &websocketServiceStub{polls: map[int][]int{1: {}}}
+
+	eventer := func() (<-chan time.Time, func()) {
+		return nil, func() {}
+	}
+
+	shutdown := make(chan struct{})
+	ts := httptest.NewServer(handleExternal(handleWebsocket(service, &autherStub{userID: 5}, rateLimitAuth{}, eventer, shutdown)))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var msg wsServerMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil || msg.Type != "poll_started" {
+		t.Fatalf("Got first message %v (%v), expected poll_started", msg, err)
+	}
+
+	close(shutdown)
+
+	if err := wsjson.Read(ctx, conn, &msg); err != nil || msg.Type != "shutdown" {
+		t.Fatalf("Got message %v (%v), expected shutdown", msg, err)
+	}
+
+	_, _, err = conn.Read(ctx)
+	if got := websocket.CloseStatus(err); got != websocket.StatusGoingAway {
+		t.Errorf("Got close status %v (%v), expected going away", got, err)
+	}
+}
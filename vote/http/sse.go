@@ -0,0 +1,335 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+const (
+	// liveVotesHistorySize is the number of changes, that are remembered for
+	// reconnecting clients.
+	liveVotesHistorySize = 1000
+
+	// sseHeartbeatInterval is the interval in which a comment is sent on an
+	// idle server-sent-events connection.
+	sseHeartbeatInterval = 15 * time.Second
+)
+
+// liveVotesHistory subscribes to the live votes and remembers the last changes,
+// so a reconnecting client only gets the changes since its last event.
+//
+// Each change gets an event id in the form `EPOCH-SEQUENCE`. The epoch is
+// random for each instance of the service, so an id from another instance or
+// from before a restart is not used.
+type liveVotesHistory struct {
+	source liveVotesSubscriber
+	filter vote.LiveVotesFilter
+	epoch  string
+
+	mu      sync.Mutex
+	state   map[int]map[int]*string
+	seq     uint64
+	entries []historyEntry
+	changed chan struct{}
+}
+
+type historyEntry struct {
+	seq  uint64
+	diff map[int]map[int]*string
+}
+
+func newLiveVotesHistory(source liveVotesSubscriber, filter vote.LiveVotesFilter) *liveVotesHistory {
+	epoch := make([]byte, 4)
+	rand.Read(epoch)
+
+	return &liveVotesHistory{
+		source:  source,
+		filter:  filter,
+		epoch:   hex.EncodeToString(epoch),
+		state:   make(map[int]map[int]*string),
+		changed: make(chan struct{}),
+	}
+}
+
+// start subscribes to the live votes and updates the history in the
+// background until the context is done.
+func (h *liveVotesHistory) start(ctx context.Context) {
+	snapshot, changes, unsubscribe := h.source.SubscribeLiveVotes(ctx, h.filter)
+	h.update(func(state map[int]map[int]*string) map[int]map[int]*string {
+		return liveVotesDiff(state, snapshot)
+	})
+
+	go func() {
+		defer func() { unsubscribe() }()
+
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					// The history was too slow. Subscribe again.
+					unsubscribe()
+					snapshot, changes, unsubscribe = h.source.SubscribeLiveVotes(ctx, h.filter)
+					h.update(func(state map[int]map[int]*string) map[int]map[int]*string {
+						return liveVotesDiff(state, snapshot)
+					})
+					continue
+				}
+
+				h.update(func(state map[int]map[int]*string) map[int]map[int]*string {
+					return applyLiveVotesChange(state, change)
+				})
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// update changes the state with the given function and saves the returned
+// changes.
+func (h *liveVotesHistory) update(apply func(state map[int]map[int]*string) map[int]map[int]*string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	diff := apply(h.state)
+
+	if h.seq > 0 {
+		// Do not save the changes from the first call. Every client starts
+		// with a snapshot.
+		if len(diff) == 0 {
+			return
+		}
+
+		// The maps in diff are shared with h.state, that is changed on later
+		// updates.
+		for pollID, userID2Vote := range diff {
+			diff[pollID] = maps.Clone(userID2Vote)
+		}
+
+		h.entries = append(h.entries, historyEntry{seq: h.seq + 1, diff: diff})
+		if len(h.entries) > liveVotesHistorySize {
+			h.entries = h.entries[len(h.entries)-liveVotesHistorySize:]
+		}
+	}
+
+	h.seq++
+
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// wait returns a channel, that is closed on the next change.
+func (h *liveVotesHistory) wait() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.changed
+}
+
+// sseEvent is an event for a server-sent-events stream.
+type sseEvent struct {
+	id   string
+	name string
+	data []byte
+}
+
+func (e sseEvent) writeTo(w http.ResponseWriter) error {
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.id, e.name, e.data); err != nil {
+		return fmt.Errorf("writing event: %w", err)
+	}
+	return nil
+}
+
+// since returns the event for a client, that has received the event with the
+// given id.
+//
+// If lastEventID is empty or unknown, a snapshot of all live votes is returned.
+// If there are no changes, the returned event has no data.
+func (h *liveVotesHistory) since(lastEventID string) (sseEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	currentID := h.eventID(h.seq)
+
+	lastSeq, ok := h.parseEventID(lastEventID)
+	if ok && lastSeq == h.seq {
+		return sseEvent{id: currentID}, nil
+	}
+
+	if ok {
+		if diff, ok := h.mergedDiff(lastSeq); ok {
+			data, err := json.Marshal(diff)
+			if err != nil {
+				return sseEvent{}, fmt.Errorf("encoding diff: %w", err)
+			}
+			return sseEvent{id: currentID, name: "diff", data: data}, nil
+		}
+	}
+
+	data, err := json.Marshal(h.state)
+	if err != nil {
+		return sseEvent{}, fmt.Errorf("encoding snapshot: %w", err)
+	}
+	return sseEvent{id: currentID, name: "snapshot", data: data}, nil
+}
+
+// mergedDiff merges all changes after the given sequence number.
+//
+// Returns false, if the changes are not in the history anymore or if they can
+// not be expressed as one diff, for example when a poll was removed and added
+// again.
+func (h *liveVotesHistory) mergedDiff(lastSeq uint64) (map[int]map[int]*string, bool) {
+	if lastSeq > h.seq || len(h.entries) == 0 || h.entries[0].seq > lastSeq+1 {
+		return nil, false
+	}
+
+	merged := make(map[int]map[int]*string)
+	for _, entry := range h.entries {
+		if entry.seq <= lastSeq {
+			continue
+		}
+
+		for pollID, userID2Vote := range entry.diff {
+			old, exists := merged[pollID]
+			if exists && old == nil && userID2Vote != nil {
+				// The poll was removed and added again.
+				return nil, false
+			}
+
+			if userID2Vote == nil || !exists {
+				merged[pollID] = maps.Clone(userID2Vote)
+				continue
+			}
+
+			maps.Copy(old, userID2Vote)
+		}
+	}
+	return merged, true
+}
+
+func (h *liveVotesHistory) eventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+func (h *liveVotesHistory) parseEventID(id string) (uint64, bool) {
+	epoch, rawSeq, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(rawSeq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// handleAllVotedIDsSSE is like handleAllVotedIDs but uses server-sent-events.
+// It supports the same query arguments.
+//
+// The first event has the type `snapshot` and contains all live votes. A client
+// has to replace its state with it. All other events have the type `diff` and
+// have the same format as the lines of handleAllVotedIDs.
+//
+// If the client reconnects with the header `Last-Event-ID`, it only gets the
+// changes since that event. If this is not possible, it gets a new snapshot.
+//
+// Connections with a filter use their own history. If they reconnect, they
+// always get a new snapshot.
+//
+// On idle connections, a comment is sent regularly, so proxies do not close the
+// connection.
+//
+// When the shutdown starts, an event with the type `shutdown` is sent and the
+// response is ended. The client should reconnect with `Last-Event-ID`.
+func handleAllVotedIDsSSE(service liveVotesSubscriber, history *liveVotesHistory, heartbeat func() (<-chan time.Time, func()), shutdown <-chan struct{}) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.InfoContext(r.Context(), "Receiving all voted ids as server-sent-events")
+
+		filter, err := liveVotesFilter(r)
+		if err != nil {
+			return vote.WrapError(vote.ErrInvalid, err)
+		}
+
+		h := history
+		if len(filter.PollIDs) > 0 || filter.MeetingID != 0 {
+			// The shared history contains all polls. A filtered connection
+			// needs its own history.
+			h = newLiveVotesHistory(service, filter)
+			h.start(r.Context())
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		// Tell nginx to not buffer the response.
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		heartbeatEvent, cancelHeartbeat := heartbeat()
+		defer cancelHeartbeat()
+
+		lastEventID := r.Header.Get("Last-Event-ID")
+		for {
+			// Get the channel before the event. In other case, a change
+			// between the two calls would be missed.
+			changed := h.wait()
+
+			e, err := h.since(lastEventID)
+			if err != nil {
+				return err
+			}
+
+			if e.data != nil {
+				if err := e.writeTo(w); err != nil {
+					return err
+				}
+			}
+			lastEventID = e.id
+
+			// Flush also, when there is no data, to send the headers.
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-changed:
+
+			case <-heartbeatEvent:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return fmt.Errorf("writing heartbeat: %w", err)
+				}
+
+			case <-r.Context().Done():
+				return nil
+
+			case <-shutdown:
+				if _, err := fmt.Fprint(w, "event: shutdown\ndata: {}\n\n"); err != nil {
+					return fmt.Errorf("writing shutdown event: %w", err)
+				}
+				w.(http.Flusher).Flush()
+				return nil
+			}
+		}
+	}
+}
+
+// acceptEventStream calls the sse handler, if the client accepts
+// server-sent-events. In other case, it calls the other handler.
+func acceptEventStream(sse, other Handler) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			return sse.ServeHTTP(w, r)
+		}
+		return other.ServeHTTP(w, r)
+	}
+}
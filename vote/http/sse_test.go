@@ -0,0 +1,244 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/vote"
+)
+
+type testEvent struct {
+	id   string
+	name string
+	data map[int]map[int]*string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) testEvent {
+	t.Helper()
+
+	var e testEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			if e.name == "" {
+				// Comment
+				continue
+			}
+			return e
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.name = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &e.data); err != nil {
+				t.Fatalf("decoding data %q: %v", value, err)
+			}
+		}
+	}
+}
+
+func TestHandleAllVotedIDsSSE(t *testing.T) {
+	voteStr := "vote"
+	voteCounter := &allLiveVotesStub{
+		expectCount: map[int]map[int]*string{1: {1: &voteStr}},
+		changes:     make(chan map[int]map[int]*string),
+	}
+
+	history := newLiveVotesHistory(voteCounter, vote.LiveVotesFilter{})
+	history.start(t.Context())
+
+	// sendChange sends a change to the history. The second send blocks until
+	// the first change is processed.
+	sendChange := func(change map[int]map[int]*string) {
+		voteCounter.changes <- change
+		voteCounter.changes <- map[int]map[int]*string{}
+	}
+
+	heartbeat := make(chan time.Time)
+	heartbeater := func() (<-chan time.Time, func()) {
+		return heartbeat, func() {}
+	}
+
+	ts := httptest.NewServer(handleInternal(handleAllVotedIDsSSE(voteCounter, history, heartbeater, nil)))
+	defer ts.Close()
+
+	open := func(t *testing.T, lastEventID string) (*bufio.Reader, func()) {
+		t.Helper()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+
+		if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("Got content type %s, expected text/event-stream", got)
+		}
+
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	body, closeBody := open(t, "")
+	first := readEvent(t, body)
+
+	if first.name != "snapshot" {
+		t.Errorf("Got first event %s, expected snapshot", first.name)
+	}
+
+	if expect := (map[int]map[int]*string{1: {1: &voteStr}}); !reflect.DeepEqual(first.data, expect) {
+		t.Errorf("Got %v, expected %v", first.data, expect)
+	}
+
+	sendChange(map[int]map[int]*string{1: {2: nil}, 2: {3: nil}})
+	second := readEvent(t, body)
+
+	if second.name != "diff" {
+		t.Errorf("Got second event %s, expected diff", second.name)
+	}
+
+	if expect := (map[int]map[int]*string{1: {2: nil}, 2: {3: nil}}); !reflect.DeepEqual(second.data, expect) {
+		t.Errorf("Got %v, expected %v", second.data, expect)
+	}
+
+	t.Run("heartbeat", func(t *testing.T) {
+		heartbeat <- time.Now()
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading heartbeat: %v", err)
+		}
+
+		if line != ": heartbeat\n" {
+			t.Errorf("Got %q, expected heartbeat comment", line)
+		}
+	})
+
+	closeBody()
+
+	sendChange(map[int]map[int]*string{1: {4: nil}, 2: nil})
+
+	t.Run("reconnect with last event id", func(t *testing.T) {
+		body, closeBody := open(t, first.id)
+		defer closeBody()
+
+		got := readEvent(t, body)
+
+		if got.name != "diff" {
+			t.Errorf("Got event %s, expected diff", got.name)
+		}
+
+		expect := map[int]map[int]*string{1: {2: nil, 4: nil}, 2: nil}
+		if !reflect.DeepEqual(got.data, expect) {
+			t.Errorf("Got %v, expected %v", got.data, expect)
+		}
+	})
+
+	t.Run("reconnect with unknown event id", func(t *testing.T) {
+		body, closeBody := open(t, "unknown-1")
+		defer closeBody()
+
+		got := readEvent(t, body)
+
+		if got.name != "snapshot" {
+			t.Errorf("Got event %s, expected snapshot", got.name)
+		}
+
+		expect := map[int]map[int]*string{1: {1: &voteStr, 2: nil, 4: nil}}
+		if !reflect.DeepEqual(got.data, expect) {
+			t.Errorf("Got %v, expected %v", got.data, expect)
+		}
+	})
+}
+
+func TestHandleAllVotedIDsSSEFilteredFirst(t *testing.T) {
+	voteCounter := &allLiveVotesStub{
+		expectCount: map[int]map[int]*string{1: {1: nil}, 2: {2: nil}},
+		changes:     make(chan map[int]map[int]*string),
+	}
+
+	history := newLiveVotesHistory(voteCounter, vote.LiveVotesFilter{})
+	history.start(t.Context())
+
+	heartbeater := func() (<-chan time.Time, func()) {
+		return nil, func() {}
+	}
+
+	ts := httptest.NewServer(handleInternal(handleAllVotedIDsSSE(voteCounter, history, heartbeater, nil)))
+	t.Cleanup(ts.Close)
+
+	open := func(t *testing.T, query string) *bufio.Reader {
+		t.Helper()
+
+		resp, err := http.Get(ts.URL + query)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return bufio.NewReader(resp.Body)
+	}
+
+	filtered := readEvent(t, open(t, "?ids=1"))
+	if expect := (map[int]map[int]*string{1: {1: nil}}); !reflect.DeepEqual(filtered.data, expect) {
+		t.Errorf("Filtered client got %v, expected %v", filtered.data, expect)
+	}
+
+	unfiltered := readEvent(t, open(t, ""))
+	if expect := (map[int]map[int]*string{1: {1: nil}, 2: {2: nil}}); !reflect.DeepEqual(unfiltered.data, expect) {
+		t.Errorf("Unfiltered client got %v, expected %v", unfiltered.data, expect)
+	}
+}
+
+func TestLiveVotesHistoryRemovedAndAddedAgain(t *testing.T) {
+	history := newLiveVotesHistory(&allLiveVotesStub{}, vote.LiveVotesFilter{})
+
+	apply := func(change map[int]map[int]*string) {
+		history.update(func(state map[int]map[int]*string) map[int]map[int]*string {
+			return applyLiveVotesChange(state, change)
+		})
+	}
+
+	apply(map[int]map[int]*string{1: {1: nil}})
+
+	start, err := history.since("")
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+
+	apply(map[int]map[int]*string{1: nil})
+	apply(map[int]map[int]*string{1: {2: nil}})
+
+	got, err := history.since(start.id)
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+
+	if got.name != "snapshot" {
+		t.Errorf("Got event %s, expected snapshot", got.name)
+	}
+}
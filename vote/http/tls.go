@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/environment"
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+var (
+	envTLSCertFile          = environment.NewVariable("VOTE_TLS_CERT_FILE", "", "Certificate for TLS. If empty, the service uses plain HTTP.")
+	envTLSKeyFile           = environment.NewVariable("VOTE_TLS_KEY_FILE", "", "Private key for TLS.")
+	envTLSClientCAFile      = environment.NewVariable("VOTE_TLS_CLIENT_CA_FILE", "", "CA to verify client certificates. If empty, client certificates are not requested.")
+	envTLSRequireClientCert = environment.NewVariable("VOTE_TLS_REQUIRE_CLIENT_CERT", "false", "Reject connections without a valid client certificate.")
+)
+
+// certReloadInterval is the interval in which the certificate files are checked
+// for changes.
+const certReloadInterval = 10 * time.Second
+
+// certReloader holds the tls config of the server and reloads it, when the
+// certificate files change.
+type certReloader struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	requireClientCert bool
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes []time.Time
+}
+
+// newCertReloader initializes a certReloader from the environment. Returns nil,
+// if TLS is not configured.
+func newCertReloader(lookup environment.Environmenter) (*certReloader, error) {
+	// All environment variables have to be called in this function and not in
+	// a sub function. In other case they will not be included in the generated
+	// file environment.md.
+	certFile := envTLSCertFile.Value(lookup)
+	keyFile := envTLSKeyFile.Value(lookup)
+	clientCAFile := envTLSClientCAFile.Value(lookup)
+	rawRequireClientCert := envTLSRequireClientCert.Value(lookup)
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%s and %s have to be set together", envTLSCertFile.Key, envTLSKeyFile.Key)
+	}
+
+	requireClientCert, err := strconv.ParseBool(rawRequireClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", envTLSRequireClientCert.Key, err)
+	}
+
+	if requireClientCert && clientCAFile == "" {
+		return nil, fmt.Errorf("%s requires %s", envTLSRequireClientCert.Key, envTLSClientCAFile.Key)
+	}
+
+	c := certReloader{
+		certFile:          certFile,
+		keyFile:           keyFile,
+		clientCAFile:      clientCAFile,
+		requireClientCert: requireClientCert,
+	}
+
+	if _, err := c.reloadIfChanged(); err != nil {
+		return nil, fmt.Errorf("loading certificates: %w", err)
+	}
+
+	return &c, nil
+}
+
+// serverConfig returns the tls config for the http server.
+//
+// Each connection uses the latest loaded certificates.
+func (c *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return &c.config.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return c.config, nil
+		},
+	}
+}
+
+// watch reloads the certificates, when the files change. It blocks until the
+// context is done.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := c.reloadIfChanged()
+		if err != nil {
+			log.Error("reloading certificates: %v", err)
+			continue
+		}
+
+		if reloaded {
+			log.Info("Reloaded TLS certificates")
+		}
+	}
+}
+
+// reloadIfChanged loads the certificate files, if one of them has changed since
+// the last call.
+//
+// If the new files are invalid, the old config is kept.
+func (c *certReloader) reloadIfChanged() (bool, error) {
+	files := []string{c.certFile, c.keyFile}
+	if c.clientCAFile != "" {
+		files = append(files, c.clientCAFile)
+	}
+
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("stat %s: %w", file, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	c.mu.RLock()
+	changed := c.config == nil || !equalTimes(c.modTimes, modTimes)
+	c.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	config, err := c.load()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.config = config
+	c.modTimes = modTimes
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+func (c *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading key pair: %w", err)
+	}
+
+	config := tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if c.clientCAFile != "" {
+		pem, err := os.ReadFile(c.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client ca: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.clientCAFile)
+		}
+
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &config, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader(t *testing.T) {
+	ca, caKey := createCert(t, nil, nil, true)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	firstCert, firstKey := createCert(t, ca, caKey, false)
+	writeCertFiles(t, certFile, keyFile, firstCert, firstKey, time.Now())
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reloadIfChanged(); err != nil {
+		t.Fatalf("loading certificates: %v", err)
+	}
+
+	addr := startTLSServer(t, reloader)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: caPool},
+			ForceAttemptHTTP2: true,
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Got protocol %s, expected HTTP/2", resp.Proto)
+	}
+
+	if got := resp.TLS.PeerCertificates[0].SerialNumber; got.Cmp(firstCert.SerialNumber) != 0 {
+		t.Errorf("Server used certificate %s, expected %s", got, firstCert.SerialNumber)
+	}
+
+	secondCert, secondKey := createCert(t, ca, caKey, false)
+	writeCertFiles(t, certFile, keyFile, secondCert, secondKey, time.Now().Add(time.Minute))
+
+	reloaded, err := reloader.reloadIfChanged()
+	if err != nil {
+		t.Fatalf("reloading certificates: %v", err)
+	}
+
+	if !reloaded {
+		t.Errorf("reloadIfChanged did not reload the changed files")
+	}
+
+	resp, err = client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("sending request after reload: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.TLS.PeerCertificates[0].SerialNumber; got.Cmp(secondCert.SerialNumber) != 0 {
+		t.Errorf("Server used certificate %s after reload, expected %s", got, secondCert.SerialNumber)
+	}
+}
+
+func TestCertReloaderRequireClientCert(t *testing.T) {
+	ca, caKey := createCert(t, nil, nil, true)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	serverCert, serverKey := createCert(t, ca, caKey, false)
+	writeCertFiles(t, certFile, keyFile, serverCert, serverKey, time.Now())
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatalf("writing ca: %v", err)
+	}
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, clientCAFile: caFile, requireClientCert: true}
+	if _, err := reloader.reloadIfChanged(); err != nil {
+		t.Fatalf("loading certificates: %v", err)
+	}
+
+	addr := startTLSServer(t, reloader)
+
+	t.Run("without client certificate", func(t *testing.T) {
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caPool}}}
+
+		if resp, err := client.Get("https://" + addr); err == nil {
+			resp.Body.Close()
+			t.Errorf("Request without client certificate did not fail")
+		}
+	})
+
+	t.Run("with client certificate", func(t *testing.T) {
+		clientCert, clientKey := createCert(t, ca, caKey, false)
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: caPool,
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{clientCert.Raw},
+				PrivateKey:  clientKey,
+			}},
+		}}}
+
+		resp, err := client.Get("https://" + addr)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		resp.Body.Close()
+	})
+}
+
+func startTLSServer(t *testing.T, reloader *certReloader) string {
+	t.Helper()
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: reloader.serverConfig(),
+	}
+	go srv.ServeTLS(lst, "", "")
+	t.Cleanup(func() { srv.Close() })
+
+	return lst.Addr().String()
+}
+
+func writeCertFiles(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey, modTime time.Time) {
+	t.Helper()
+
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatalf("writing cert: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0o600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("setting mod time: %v", err)
+		}
+	}
+}
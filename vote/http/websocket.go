@@ -0,0 +1,302 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// maxWebsocketMessageSize is the maximal size of a message from the client.
+const maxWebsocketMessageSize = 1 << 20
+
+type votablePollser interface {
+	VotablePolls(ctx context.Context, requestUser int) (map[int][]int, error)
+}
+
+type websocketService interface {
+	voter
+	votablePollser
+}
+
+// messageLimiter limits the number of votes, that a user can send over a
+// websocket connection.
+type messageLimiter interface {
+	takeUserToken(ctx context.Context, uid int) error
+}
+
+// wsClientMessage is a message from the client to the server.
+//
+// The only supported type is `vote`. The field ballot has the same format as
+// the body of a vote request.
+type wsClientMessage struct {
+	Type           string          `json:"type"`
+	RequestID      string          `json:"request_id"`
+	PollID         int             `json:"poll_id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Ballot         json.RawMessage `json:"ballot"`
+}
+
+// wsServerMessage is a message from the server to the client.
+type wsServerMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	PollID    int    `json:"poll_id,omitempty"`
+	Voted     []int  `json:"voted,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// handleWebsocket opens a websocket connection for the request user.
+//
+// Over the connection, the server pushes a message when a poll, on which the
+// user or one of its delegators can vote, is started or stopped and when the
+// voted state of such a poll changes. The client can send its ballots over the
+// same connection. Each ballot is counted by the rate limit of the user.
+//
+// When the shutdown starts, new ballots are rejected. After a running vote is
+// saved, a message with the type `shutdown` is sent and the connection is
+// closed with the status `going away`.
+func handleWebsocket(service websocketService, auth authenticater, limiter messageLimiter, eventer func() (<-chan time.Time, func()), shutdown <-chan struct{}) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		log.InfoContext(r.Context(), "Receiving websocket connection")
+
+		ctx, err := auth.Authenticate(w, r)
+		if err != nil {
+			return err
+		}
+
+		uid := auth.FromContext(ctx)
+		if uid == 0 {
+			return statusCode(401, vote.MessageError(vote.ErrNotAllowed, "Anonymous user can not vote"))
+		}
+		ctx = log.With(ctx, "user_id", uid)
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			// Accept has already written the response.
+			log.DebugContext(ctx, "Websocket handshake failed", "error", err)
+			return nil
+		}
+		defer conn.CloseNow()
+
+		conn.SetReadLimit(maxWebsocketMessageSize)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// voting is held by the reader while it saves a vote.
+		var voting sync.Mutex
+
+		changed := make(chan struct{}, 1)
+		go func() {
+			defer cancel()
+			if err := readWebsocket(ctx, conn, service, limiter, uid, changed, shutdown, &voting); err != nil {
+				log.DebugContext(ctx, "Websocket reader stopped", "error", err)
+			}
+		}()
+
+		if err := pushVotablePolls(ctx, conn, service, uid, eventer, changed, shutdown); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			_, msg := formatError(ctx, err, false)
+			conn.Close(websocket.StatusInternalError, msg)
+			return nil
+		}
+
+		select {
+		case <-shutdown:
+			// Wait for a running vote. New votes are rejected by the reader.
+			voting.Lock()
+			defer voting.Unlock()
+
+			if err := wsjson.Write(ctx, conn, wsServerMessage{Type: "shutdown"}); err != nil {
+				log.DebugContext(ctx, "Writing shutdown message", "error", err)
+			}
+			conn.Close(websocket.StatusGoingAway, "Server is shutting down")
+			return nil
+		default:
+		}
+
+		conn.Close(websocket.StatusNormalClosure, "")
+		return nil
+	}
+}
+
+// readWebsocket handles the messages from the client until the connection is
+// closed.
+//
+// After each successful vote, it signals the changed channel. It holds voting,
+// while it saves a vote. After the shutdown has started, the votes are
+// rejected.
+func readWebsocket(ctx context.Context, conn *websocket.Conn, service voter, limiter messageLimiter, uid int, changed chan<- struct{}, shutdown <-chan struct{}, voting *sync.Mutex) error {
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return fmt.Errorf("reading message: %w", err)
+		}
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if err := writeWebsocketError(ctx, conn, "", vote.MessageErrorf(vote.ErrInvalid, "decoding message: %v", err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.Type != "vote" {
+			if err := writeWebsocketError(ctx, conn, msg.RequestID, vote.MessageErrorf(vote.ErrInvalid, "Unknown message type %q", msg.Type)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		saved, err := voteWebsocket(ctx, conn, service, limiter, uid, msg, shutdown, voting)
+		if err != nil {
+			return err
+		}
+
+		if !saved {
+			continue
+		}
+
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// voteWebsocket saves the vote from a message and sends the result to the
+// client. It returns true, if the vote was saved.
+//
+// The returned error is only set, if the connection failed.
+func voteWebsocket(ctx context.Context, conn *websocket.Conn, service voter, limiter messageLimiter, uid int, msg wsClientMessage, shutdown <-chan struct{}, voting *sync.Mutex) (bool, error) {
+	voting.Lock()
+	defer voting.Unlock()
+
+	select {
+	case <-shutdown:
+		return false, writeWebsocketError(ctx, conn, msg.RequestID, shutdownError{})
+	default:
+	}
+
+	if err := limiter.takeUserToken(ctx, uid); err != nil {
+		return false, writeWebsocketError(ctx, conn, msg.RequestID, err)
+	}
+
+	voteCtx := log.With(ctx, "poll_id", msg.PollID)
+	if msg.IdempotencyKey != "" {
+		if len(msg.IdempotencyKey) > maxIdempotencyKeyLength {
+			err := vote.MessageErrorf(vote.ErrInvalid, "idempotency_key has to be at most %d characters", maxIdempotencyKeyLength)
+			return false, writeWebsocketError(ctx, conn, msg.RequestID, err)
+		}
+		voteCtx = vote.WithIdempotencyKey(voteCtx, msg.IdempotencyKey)
+	}
+
+	if err := service.Vote(voteCtx, msg.PollID, uid, bytes.NewReader(msg.Ballot)); err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		return false, writeWebsocketError(ctx, conn, msg.RequestID, err)
+	}
+
+	if err := wsjson.Write(ctx, conn, wsServerMessage{Type: "vote_result", RequestID: msg.RequestID}); err != nil {
+		return false, fmt.Errorf("writing vote result: %w", err)
+	}
+
+	return true, nil
+}
+
+func writeWebsocketError(ctx context.Context, conn *websocket.Conn, requestID string, err error) error {
+	msgType := "error"
+	if requestID != "" {
+		msgType = "vote_result"
+	}
+
+	errType, msg := formatError(ctx, err, false)
+	out := wsServerMessage{
+		Type:      msgType,
+		RequestID: requestID,
+		Error:     errType,
+		Message:   msg,
+	}
+
+	if err := wsjson.Write(ctx, conn, out); err != nil {
+		return fmt.Errorf("writing error: %w", err)
+	}
+	return nil
+}
+
+// pushVotablePolls sends the changes of the votable polls to the client. It
+// checks for changes on each event and after each vote of the client.
+//
+// It returns, when the shutdown starts.
+func pushVotablePolls(ctx context.Context, conn *websocket.Conn, service votablePollser, uid int, eventer func() (<-chan time.Time, func()), changed <-chan struct{}, shutdown <-chan struct{}) error {
+	event, cancel := eventer()
+	defer cancel()
+
+	known := make(map[int][]int)
+	for {
+		polls, err := service.VotablePolls(ctx, uid)
+		if err != nil {
+			return fmt.Errorf("getting votable polls: %w", err)
+		}
+
+		for _, msg := range votablePollsDiff(known, polls) {
+			if err := wsjson.Write(ctx, conn, msg); err != nil {
+				return fmt.Errorf("writing message: %w", err)
+			}
+		}
+		known = polls
+
+		select {
+		case _, ok := <-event:
+			if !ok {
+				return nil
+			}
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		case <-shutdown:
+			return nil
+		}
+	}
+}
+
+// votablePollsDiff returns the messages, that describe the changes between two
+// results of VotablePolls.
+func votablePollsDiff(old, new map[int][]int) []wsServerMessage {
+	var messages []wsServerMessage
+	for _, pollID := range slices.Sorted(maps.Keys(new)) {
+		voted := new[pollID]
+		oldVoted, ok := old[pollID]
+		switch {
+		case !ok:
+			messages = append(messages, wsServerMessage{Type: "poll_started", PollID: pollID, Voted: voted})
+		case !slices.Equal(oldVoted, voted):
+			messages = append(messages, wsServerMessage{Type: "voted", PollID: pollID, Voted: voted})
+		}
+	}
+
+	for _, pollID := range slices.Sorted(maps.Keys(old)) {
+		if _, ok := new[pollID]; !ok {
+			messages = append(messages, wsServerMessage{Type: "poll_stopped", PollID: pollID})
+		}
+	}
+
+	return messages
+}
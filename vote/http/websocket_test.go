@@ -0,0 +1,223 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-vote-service/vote"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+type websocketServiceStub struct {
+	mu        sync.Mutex
+	polls     map[int][]int
+	voteBody  string
+	expectErr error
+}
+
+func (s *websocketServiceStub) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.voteBody = string(body)
+	if s.expectErr != nil {
+		return s.expectErr
+	}
+
+	s.polls[pollID] = append(s.polls[pollID], requestUser)
+	return nil
+}
+
+func (s *websocketServiceStub) VotablePolls(ctx context.Context, requestUser int) (map[int][]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[int][]int, len(s.polls))
+	for pollID, voted := range s.polls {
+		out[pollID] = append([]int{}, voted...)
+	}
+	return out, nil
+}
+
+func (s *websocketServiceStub) setPolls(polls map[int][]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls = polls
+}
+
+func TestHandleWebsocket(t *testing.T) {
+	service := &websocketServiceStub{polls: map[int][]int{1: {}}}
+
+	event := make(chan time.Time)
+	eventer := func() (<-chan time.Time, func()) {
+		return event, func() {}
+	}
+
+	ts := httptest.NewServer(handleExternal(handleWebsocket(service, &autherStub{userID: 5}, rateLimitAuth{}, eventer, nil)))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	read := func(t *testing.T) wsServerMessage {
+		t.Helper()
+
+		var msg wsServerMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			t.Fatalf("reading message: %v", err)
+		}
+		return msg
+	}
+
+	if got, expect := read(t), (wsServerMessage{Type: "poll_started", PollID: 1}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %v, expected %v", got, expect)
+	}
+
+	t.Run("vote", func(t *testing.T) {
+		msg := wsClientMessage{Type: "vote", RequestID: "abc", PollID: 1, Ballot: []byte(`{"value":"Y"}`)}
+		if err := wsjson.Write(ctx, conn, msg); err != nil {
+			t.Fatalf("writing vote: %v", err)
+		}
+
+		if got, expect := read(t), (wsServerMessage{Type: "vote_result", RequestID: "abc"}); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got %v, expected %v", got, expect)
+		}
+
+		if got, expect := read(t), (wsServerMessage{Type: "voted", PollID: 1, Voted: []int{5}}); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got %v, expected %v", got, expect)
+		}
+
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		if service.voteBody != `{"value":"Y"}` {
+			t.Errorf("Got ballot %s, expected {\"value\":\"Y\"}", service.voteBody)
+		}
+	})
+
+	t.Run("vote error", func(t *testing.T) {
+		service.mu.Lock()
+		service.expectErr = vote.ErrDoubleVote
+		service.mu.Unlock()
+
+		msg := wsClientMessage{Type: "vote", RequestID: "def", PollID: 1, Ballot: []byte(`{"value":"Y"}`)}
+		if err := wsjson.Write(ctx, conn, msg); err != nil {
+			t.Fatalf("writing vote: %v", err)
+		}
+
+		got := read(t)
+		if got.Type != "vote_result" || got.RequestID != "def" || got.Error != "double-vote" {
+			t.Errorf("Got %v, expected vote_result with error double-vote", got)
+		}
+	})
+
+	t.Run("invalid message", func(t *testing.T) {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(`not json`)); err != nil {
+			t.Fatalf("writing message: %v", err)
+		}
+
+		got := read(t)
+		if got.Type != "error" || got.Error != "invalid" {
+			t.Errorf("Got %v, expected error invalid", got)
+		}
+	})
+
+	t.Run("poll stopped and started", func(t *testing.T) {
+		service.setPolls(map[int][]int{2: {}})
+		event <- time.Now()
+
+		if got, expect := read(t), (wsServerMessage{Type: "poll_started", PollID: 2}); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got %v, expected %v", got, expect)
+		}
+
+		if got, expect := read(t), (wsServerMessage{Type: "poll_stopped", PollID: 1}); !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got %v, expected %v", got, expect)
+		}
+	})
+}
+
+func TestHandleWebsocketAnonymous(t *testing.T) {
+	eventer := func() (<-chan time.Time, func()) {
+		return make(chan time.Time), func() {}
+	}
+
+	ts := httptest.NewServer(handleExternal(handleWebsocket(&websocketServiceStub{}, &autherStub{}, rateLimitAuth{}, eventer, nil)))
+	defer ts.Close()
+
+	_, resp, err := websocket.Dial(t.Context(), strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err == nil {
+		t.Fatalf("Dial did not return an error")
+	}
+
+	if resp == nil || resp.StatusCode != 401 {
+		t.Errorf("Got response %v, expected status 401", resp)
+	}
+}
+
+func TestHandleWebsocketRateLimit(t *testing.T) {
+	service := &websocketServiceStub{polls: map[int][]int{1: {}}}
+	eventer := func() (<-chan time.Time, func()) {
+		return make(chan time.Time), func() {}
+	}
+
+	now := time.Now()
+	bucket := newMemoryTokenBucket()
+	bucket.now = func() time.Time { return now }
+	limiter := rateLimitAuth{bucket: bucket, user: rateLimit{rate: 1, burst: 1}}
+
+	ts := httptest.NewServer(handleExternal(handleWebsocket(service, &autherStub{userID: 5}, limiter, eventer, nil)))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	results := make(map[string]wsServerMessage)
+	for _, requestID := range []string{"first", "second"} {
+		msg := wsClientMessage{Type: "vote", RequestID: requestID, PollID: 1, Ballot: []byte(`{"value":"Y"}`)}
+		if err := wsjson.Write(ctx, conn, msg); err != nil {
+			t.Fatalf("writing vote: %v", err)
+		}
+	}
+
+	for len(results) < 2 {
+		var msg wsServerMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			t.Fatalf("reading message: %v", err)
+		}
+
+		if msg.Type == "vote_result" {
+			results[msg.RequestID] = msg
+		}
+	}
+
+	if got := results["first"]; got.Error != "" {
+		t.Errorf("First vote returned %v, expected no error", got)
+	}
+
+	if got := results["second"]; got.Error != "too-many-requests" {
+		t.Errorf("Second vote returned %v, expected error too-many-requests", got)
+	}
+}
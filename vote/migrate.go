@@ -0,0 +1,262 @@
+package vote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/dsmodels"
+	"github.com/OpenSlides/openslides-vote-service/log"
+)
+
+// MigratePoll moves a started poll with all its votes to the backend with the
+// given name. The name is `fast` or `long` like the field poll/backend.
+//
+// The poll is marked as moved and stopped in the old backend, started in the
+// new backend and the votes are copied. Afterwards, the poll is pinned to the
+// new backend and cleared in the old backend.
+//
+// If the migration fails after the poll was stopped, the poll stays marked in
+// the old backend. The migration is finished by the next vote on any instance
+// or by calling MigratePoll again. Votes, that are already in the new backend,
+// are not copied twice. Idempotency keys are not moved.
+func (v *Vote) MigratePoll(ctx context.Context, pollID int, backendName string) (err error) {
+	defer func() { v.audit(ctx, "migrate", pollID, 0, err) }()
+
+	poll, err := dsmodels.New(v.flow).Poll(pollID).First(ctx)
+	if err != nil {
+		var doesNotExist dsfetch.DoesNotExistError
+		if errors.As(err, &doesNotExist) {
+			return MessageErrorf(ErrNotExists, "Poll %d does not exist", pollID)
+		}
+		return fmt.Errorf("loading poll: %w", err)
+	}
+
+	if poll.State != "started" {
+		return MessageErrorf(ErrInvalid, "Poll %d is not started", pollID)
+	}
+
+	if backendName != "fast" && backendName != "long" {
+		return MessageErrorf(ErrInvalid, "Unknown backend %q", backendName)
+	}
+
+	source := v.backend(poll)
+	target := v.backendByName(backendName)
+	if source == target {
+		return nil
+	}
+
+	return v.migrate(ctx, poll, source, target)
+}
+
+// migrate moves the poll from the source to the target backend.
+func (v *Vote) migrate(ctx context.Context, poll dsmodels.Poll, source, target Backend) error {
+	pollID := poll.ID
+
+	if err := v.moveVotes(ctx, poll, source, target); err != nil {
+		if other, ok := v.movedBackend(source, err); ok && other == target {
+			// The poll was already migrated by another instance.
+			v.pin(pollID, target)
+			return nil
+		}
+
+		var errNotExist interface{ DoesNotExist() }
+		if errors.As(err, &errNotExist) {
+			return MessageErrorf(ErrNotExists, "Poll %d does not exist in the backend", pollID)
+		}
+		return err
+	}
+
+	v.pin(pollID, target)
+
+	if err := source.Clear(ctx, pollID); err != nil {
+		return fmt.Errorf("clearing poll in %s: %w", source, err)
+	}
+
+	log.InfoContext(ctx, "Migrated poll", "poll_id", pollID, "from", source.String(), "to", target.String())
+	return nil
+}
+
+// moveVotes marks the poll as moved and stops it in the source. Afterwards, the
+// poll is started in the target and the votes are copied.
+//
+// If the poll can not be stopped, the mark is removed, so the poll keeps
+// running in the source.
+func (v *Vote) moveVotes(ctx context.Context, poll dsmodels.Poll, source, target Backend) error {
+	if err := v.markMoved(ctx, poll.ID, source, target); err != nil {
+		return err
+	}
+
+	ballots, userIDs, err := source.Stop(ctx, poll.ID)
+	if err != nil {
+		v.unmarkMoved(ctx, poll.ID, source)
+		return fmt.Errorf("stopping poll in %s: %w", source, err)
+	}
+
+	return v.copyPoll(ctx, poll, source, target, ballots, userIDs)
+}
+
+// copyPoll starts the stopped poll from the source in the target and saves all
+// votes.
+func (v *Vote) copyPoll(ctx context.Context, poll dsmodels.Poll, source, target Backend, ballots [][]byte, userIDs []int) error {
+	userID2Vote, err := migrationVotes(ctx, source, poll, ballots, userIDs)
+	if err != nil {
+		return fmt.Errorf("reading votes from %s: %w", source, err)
+	}
+
+	start := target.Start
+	if namedBackend, ok := target.(NamedBackend); ok && poll.Type == "named" {
+		start = namedBackend.StartNamed
+	}
+
+	if err := start(ctx, poll.ID); err != nil {
+		return fmt.Errorf("starting poll in %s: %w", target, err)
+	}
+
+	for _, userID := range slices.Sorted(maps.Keys(userID2Vote)) {
+		if err := target.Vote(ctx, poll.ID, userID, userID2Vote[userID]); err != nil {
+			var errDoubleVote interface{ DoubleVote() }
+			if errors.As(err, &errDoubleVote) {
+				// The vote was copied by an earlier call.
+				continue
+			}
+			return fmt.Errorf("saving vote of user %d in %s: %w", userID, target, err)
+		}
+	}
+	return nil
+}
+
+// markMoved saves in the source, that the poll is moved to the target. So all
+// instances know about the migration, even after a restart.
+//
+// It returns an error, if the mark is also visible in the target. In this case,
+// both backends use the same storage and the migration would remove the poll.
+func (v *Vote) markMoved(ctx context.Context, pollID int, source, target Backend) error {
+	mover, ok := source.(MoveBackend)
+	if !ok {
+		// The migration is only known by this instance.
+		return nil
+	}
+
+	if moved, err := movedPolls(ctx, target); err != nil {
+		return err
+	} else if _, ok := moved[pollID]; ok {
+		return MessageErrorf(ErrInvalid, "Poll %d is marked as moved in the %s backend", pollID, v.backendName(target))
+	}
+
+	if err := mover.SetMoved(ctx, pollID, v.backendName(target)); err != nil {
+		return fmt.Errorf("marking poll as moved in %s: %w", source, err)
+	}
+
+	moved, err := movedPolls(ctx, target)
+	if err != nil {
+		v.unmarkMoved(ctx, pollID, source)
+		return err
+	}
+
+	if _, ok := moved[pollID]; ok {
+		v.unmarkMoved(ctx, pollID, source)
+		return MessageErrorf(ErrInvalid, "The backends %s and %s use the same storage", source, target)
+	}
+	return nil
+}
+
+// unmarkMoved removes the mark from markMoved. An error is only logged, since
+// it is called after another error.
+func (v *Vote) unmarkMoved(ctx context.Context, pollID int, source Backend) {
+	mover, ok := source.(MoveBackend)
+	if !ok {
+		return
+	}
+
+	if err := mover.SetMoved(ctx, pollID, ""); err != nil {
+		log.ErrorContext(ctx, "Removing the migration mark", "poll_id", pollID, "backend", source.String(), "error", err)
+	}
+}
+
+// movedPolls returns the marked polls of a backend. It returns nil, if the
+// backend does not implement MoveBackend.
+func movedPolls(ctx context.Context, backend Backend) (map[int]string, error) {
+	mover, ok := backend.(MoveBackend)
+	if !ok {
+		return nil, nil
+	}
+
+	moved, err := mover.Moved(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching moved polls from %s: %w", backend, err)
+	}
+	return moved, nil
+}
+
+// migrationTarget returns the backend, the poll is moved to, if the error tells,
+// that the poll is stopped and the poll is marked as moved in the backend.
+func (v *Vote) migrationTarget(ctx context.Context, backend Backend, pollID int, err error) (Backend, bool) {
+	var errStopped interface{ Stopped() }
+	if !errors.As(err, &errStopped) {
+		return nil, false
+	}
+
+	moved, err := movedPolls(ctx, backend)
+	if err != nil {
+		log.ErrorContext(ctx, "Checking for a migration", "poll_id", pollID, "error", err)
+		return nil, false
+	}
+
+	name, ok := moved[pollID]
+	if !ok {
+		return nil, false
+	}
+
+	target := v.backendByName(name)
+	if target == backend {
+		return nil, false
+	}
+	return target, true
+}
+
+// backendName returns the name of a backend like in the field poll/backend.
+func (v *Vote) backendName(backend Backend) string {
+	if backend == v.fastBackend {
+		return "fast"
+	}
+	return "long"
+}
+
+// migrationVotes returns the vote of each user from a stopped poll.
+//
+// For named polls, the votes are read from the live votes of the backend. For
+// other polls, the backend does not know, which user has sent which vote. In
+// this case, the sorted votes are assigned to the sorted users. This does not
+// change the result of the poll. Since the order does not depend on the
+// backend, a resumed migration assigns the same votes as the first try.
+func migrationVotes(ctx context.Context, backend Backend, poll dsmodels.Poll, ballots [][]byte, userIDs []int) (map[int][]byte, error) {
+	if len(ballots) != len(userIDs) {
+		return nil, fmt.Errorf("got %d votes from %d users", len(ballots), len(userIDs))
+	}
+
+	if poll.Type == "named" {
+		liveVotes, err := backend.LiveVotes(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetching live votes: %w", err)
+		}
+
+		userID2Vote := liveVotes[poll.ID]
+		if len(userID2Vote) == len(userIDs) && !slices.ContainsFunc(userIDs, func(userID int) bool { return userID2Vote[userID] == nil }) {
+			return userID2Vote, nil
+		}
+	}
+
+	ballots = slices.SortedFunc(slices.Values(ballots), bytes.Compare)
+	userIDs = slices.Sorted(slices.Values(userIDs))
+
+	userID2Vote := make(map[int][]byte, len(userIDs))
+	for i, userID := range userIDs {
+		userID2Vote[userID] = ballots[i]
+	}
+	return userID2Vote, nil
+}
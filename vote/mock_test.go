@@ -2,6 +2,7 @@ package vote_test
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/OpenSlides/openslides-go/datastore/dskey"
 )
@@ -29,3 +30,23 @@ func (g *StubGetter) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key]
 }
 
 func (g *StubGetter) Update(context.Context, func(map[dskey.Key][]byte, error)) {}
+
+// blockingGetter is a StubGetter, that blocks all requests after blocking is
+// set, until unblock is closed. It sends to blocked, when a request is blocked.
+type blockingGetter struct {
+	StubGetter
+	blocking atomic.Bool
+	blocked  chan struct{}
+	unblock  chan struct{}
+}
+
+func (g *blockingGetter) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	if g.blocking.Load() {
+		select {
+		case g.blocked <- struct{}{}:
+		default:
+		}
+		<-g.unblock
+	}
+	return g.StubGetter.Get(ctx, keys...)
+}
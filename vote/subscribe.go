@@ -0,0 +1,221 @@
+package vote
+
+import (
+	"context"
+	"maps"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmodels"
+)
+
+// liveVotesSubscriberBuffer is the number of changes, that are buffered for a
+// subscriber. If a subscriber is slower, it is removed.
+const liveVotesSubscriberBuffer = 100
+
+// liveVotesEvent is a change of the live votes of one poll.
+type liveVotesEvent struct {
+	pollID int
+
+	// meetingID is the meeting of the poll. It is 0, if it is unknown.
+	meetingID int
+
+	// userID2Vote contains the new votes of the poll. It is nil, if the poll
+	// was removed.
+	userID2Vote map[int]*string
+}
+
+type liveVotesSubscriber struct {
+	filter  LiveVotesFilter
+	changes chan map[int]map[int]*string
+}
+
+// SubscribeLiveVotes returns the same data as FilteredLiveVotes and a channel,
+// that receives all later changes.
+//
+// A change has the same format as the data: a poll with a map contains new
+// votes, a poll with a nil value was removed. The maps of a change are shared
+// between all subscribers and must not be modified. A change can be empty. This
+// means, that a poll was stopped.
+//
+// If the subscriber does not read the changes fast enough, the channel is
+// closed. The subscriber has to subscribe again to get a new snapshot.
+//
+// The returned function removes the subscription. It has to be called, when
+// the subscriber is done.
+func (v *Vote) SubscribeLiveVotes(ctx context.Context, filter LiveVotesFilter) (map[int]map[int]*string, <-chan map[int]map[int]*string, func()) {
+	polls := v.lockWithPolls(ctx)
+	defer v.liveVotesMu.Unlock()
+
+	snapshot := v.filteredLiveVotes(filter, polls)
+
+	sub := &liveVotesSubscriber{
+		filter:  filter,
+		changes: make(chan map[int]map[int]*string, liveVotesSubscriberBuffer),
+	}
+	v.subscribers[sub] = struct{}{}
+
+	unsubscribe := func() {
+		v.liveVotesMu.Lock()
+		defer v.liveVotesMu.Unlock()
+		v.removeSubscriber(sub)
+	}
+
+	return snapshot, sub.changes, unsubscribe
+}
+
+// publish sends the events to all subscribers, that have a matching filter.
+//
+// liveVotesMu has to be locked.
+func (v *Vote) publish(events ...liveVotesEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	for sub := range v.subscribers {
+		change := make(map[int]map[int]*string)
+		for _, event := range events {
+			if sub.filter.matches(event.pollID, event.meetingID) {
+				change[event.pollID] = event.userID2Vote
+			}
+		}
+
+		if len(change) == 0 {
+			continue
+		}
+
+		v.send(sub, change)
+	}
+}
+
+// notify sends an empty change to all subscribers. It is used for changes, that
+// do not change the live votes.
+//
+// liveVotesMu has to be locked.
+func (v *Vote) notify() {
+	for sub := range v.subscribers {
+		v.send(sub, map[int]map[int]*string{})
+	}
+}
+
+func (v *Vote) send(sub *liveVotesSubscriber, change map[int]map[int]*string) {
+	select {
+	case sub.changes <- change:
+	default:
+		// The subscriber is too slow. It has to subscribe again.
+		v.removeSubscriber(sub)
+	}
+}
+
+func (v *Vote) removeSubscriber(sub *liveVotesSubscriber) {
+	if _, ok := v.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(v.subscribers, sub)
+	close(sub.changes)
+}
+
+// liveVotesEvents returns the events, that change the live votes from old to
+// new. polls has to contain the polls of new.
+//
+// liveVotesMu has to be locked.
+func (v *Vote) liveVotesEvents(old, new map[int]map[int][]byte, polls map[int]*dsmodels.Poll) []liveVotesEvent {
+	if len(v.subscribers) == 0 {
+		return nil
+	}
+
+	var events []liveVotesEvent
+	for pollID, userID2Vote := range new {
+		oldUserID2Vote, known := old[pollID]
+
+		added := maps.Clone(userID2Vote)
+		maps.DeleteFunc(added, func(userID int, _ []byte) bool {
+			_, ok := oldUserID2Vote[userID]
+			return ok
+		})
+
+		if known && len(added) == 0 {
+			continue
+		}
+
+		poll := polls[pollID]
+		if poll == nil {
+			continue
+		}
+
+		event := liveVotesEvent{
+			pollID:      pollID,
+			meetingID:   poll.MeetingID,
+			userID2Vote: make(map[int]*string, len(added)),
+		}
+		for userID, vote := range added {
+			event.userID2Vote[userID] = liveVoteValue(*poll, vote)
+		}
+		events = append(events, event)
+	}
+
+	for pollID := range old {
+		if _, ok := new[pollID]; !ok {
+			events = append(events, liveVotesEvent{pollID: pollID})
+		}
+	}
+
+	return events
+}
+
+// liveVoteValue returns the value of a vote, that can be shown as live vote.
+//
+// Only named polls with live voting enabled show the values.
+func liveVoteValue(poll dsmodels.Poll, vote []byte) *string {
+	if !poll.LiveVotingEnabled || poll.Type != "named" || vote == nil {
+		return nil
+	}
+
+	str := string(vote)
+	return &str
+}
+
+// lockWithPolls locks liveVotesMu and returns the polls of the live votes from
+// the datastore. The polls are fetched before the lock, so a slow datastore
+// does not block the other requests. If a poll was started in the meantime,
+// the lock is released and the new poll is fetched.
+//
+// The value of a poll is nil, if it could not be fetched. The caller has to
+// unlock liveVotesMu.
+func (v *Vote) lockWithPolls(ctx context.Context) map[int]*dsmodels.Poll {
+	polls := make(map[int]*dsmodels.Poll)
+	for {
+		v.liveVotesMu.Lock()
+		var missing []int
+		for pollID := range v.liveVotes {
+			if _, ok := polls[pollID]; !ok {
+				missing = append(missing, pollID)
+			}
+		}
+
+		if len(missing) == 0 {
+			return polls
+		}
+		v.liveVotesMu.Unlock()
+
+		maps.Copy(polls, v.fetchPolls(ctx, missing))
+	}
+}
+
+// fetchPolls returns the polls from the datastore. The value of a poll is nil,
+// if it could not be fetched.
+//
+// liveVotesMu must not be locked.
+func (v *Vote) fetchPolls(ctx context.Context, pollIDs []int) map[int]*dsmodels.Poll {
+	ds := dsmodels.New(v.flow)
+
+	polls := make(map[int]*dsmodels.Poll, len(pollIDs))
+	for _, pollID := range pollIDs {
+		poll, err := ds.Poll(pollID).First(ctx)
+		if err != nil {
+			polls[pollID] = nil
+			continue
+		}
+		polls[pollID] = &poll
+	}
+	return polls
+}
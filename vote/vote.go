@@ -2,11 +2,18 @@ package vote
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,7 +22,9 @@ import (
 	"github.com/OpenSlides/openslides-go/datastore/dsrecorder"
 	"github.com/OpenSlides/openslides-go/datastore/flow"
 	"github.com/OpenSlides/openslides-vote-service/log"
+	"github.com/OpenSlides/openslides-vote-service/tracing"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Vote holds the state of the service.
@@ -28,14 +37,34 @@ type Vote struct {
 
 	liveVotesMu sync.Mutex
 	liveVotes   map[int]map[int][]byte // voted holds for all running polls, the votes of a user
+	pinned      map[int]Backend        // pinned holds the backend of each started poll
+	subscribers map[*liveVotesSubscriber]struct{}
+
+	// idempotencySecret is the key to hash the idempotency keys.
+	idempotencySecret []byte
+
+	// Auditor records the state changing operations. It is optional and has to
+	// be set before the service is used.
+	Auditor Auditor
 }
 
 // New creates an initializes vote service.
-func New(ctx context.Context, fast, long Backend, flow flow.Flow, singleInstance bool) (*Vote, func(context.Context, func(error)), error) {
+//
+// The idempotencySecret is used to hash the idempotency keys. It has to be the
+// same on all instances. If it is empty, a random secret is used. In this case,
+// an idempotency key only works on the same instance until a restart.
+func New(ctx context.Context, fast, long Backend, flow flow.Flow, singleInstance bool, idempotencySecret []byte) (*Vote, func(context.Context, func(error)), error) {
+	if len(idempotencySecret) == 0 {
+		idempotencySecret = []byte(rand.Text())
+	}
+
 	v := &Vote{
-		fastBackend: fast,
-		longBackend: long,
-		flow:        flow,
+		fastBackend:       fast,
+		longBackend:       long,
+		flow:              flow,
+		pinned:            make(map[int]Backend),
+		subscribers:       make(map[*liveVotesSubscriber]struct{}),
+		idempotencySecret: idempotencySecret,
 	}
 
 	if err := v.loadVoted(ctx); err != nil {
@@ -49,12 +78,24 @@ func New(ctx context.Context, fast, long Backend, flow flow.Flow, singleInstance
 			return
 		}
 
+		listeners := v.changeListeners()
+		for _, listener := range listeners {
+			go v.listenChanges(ctx, listener, errorHandler)
+		}
+
+		// With change listeners on all backends, loadVoted is only a fallback in
+		// case a message was lost.
+		interval := time.Second
+		if len(listeners) > 0 && len(listeners) == len(v.backends()) {
+			interval = loadVotedFallbackInterval
+		}
+
 		go func() {
 			for {
 				if err := v.loadVoted(ctx); err != nil {
 					errorHandler(err)
 				}
-				time.Sleep(time.Second)
+				time.Sleep(interval)
 			}
 		}()
 	}
@@ -63,21 +104,59 @@ func New(ctx context.Context, fast, long Backend, flow flow.Flow, singleInstance
 }
 
 // backend returns the poll backend for a pollConfig object.
+//
+// A started poll is pinned to the backend, it was started in. So a change of the
+// field poll/backend has no effect on a running poll.
 func (v *Vote) backend(p dsmodels.Poll) Backend {
-	backend := v.longBackend
-	if p.Backend == "fast" {
-		backend = v.fastBackend
+	v.liveVotesMu.Lock()
+	backend, ok := v.pinned[p.ID]
+	v.liveVotesMu.Unlock()
+
+	if !ok {
+		backend = v.backendByName(p.Backend)
 	}
 	log.Debug("Used backend: %v", backend)
 	return backend
 }
 
+// pin sets the backend of a poll.
+func (v *Vote) pin(pollID int, backend Backend) {
+	v.liveVotesMu.Lock()
+	v.pinned[pollID] = backend
+	v.liveVotesMu.Unlock()
+}
+
+// movedBackend returns the other backend, if the error tells, that the poll does
+// not exist in the used backend. This happens on other instances, after a poll
+// was migrated.
+func (v *Vote) movedBackend(used Backend, err error) (Backend, bool) {
+	var errNotExist interface{ DoesNotExist() }
+	if !errors.As(err, &errNotExist) || v.fastBackend == v.longBackend {
+		return nil, false
+	}
+
+	if used == v.fastBackend {
+		return v.longBackend, true
+	}
+	return v.fastBackend, true
+}
+
+// backendByName returns the backend for the value of the field poll/backend.
+func (v *Vote) backendByName(name string) Backend {
+	if name == "fast" {
+		return v.fastBackend
+	}
+	return v.longBackend
+}
+
 // Start an electronic vote.
 //
 // This function is idempotence. If you call it with the same input, you will
 // get the same output. This means, that when a poll is stopped, Start() will
 // not throw an error.
-func (v *Vote) Start(ctx context.Context, pollID int) error {
+func (v *Vote) Start(ctx context.Context, pollID int) (err error) {
+	defer func() { v.audit(ctx, "start", pollID, 0, err) }()
+
 	recorder := dsrecorder.New(v.flow)
 	ds := dsmodels.New(recorder)
 
@@ -100,10 +179,23 @@ func (v *Vote) Start(ctx context.Context, pollID int) error {
 	log.Debug("Preload cache. Received keys: %v", recorder.Keys())
 
 	backend := v.backend(poll)
-	if err := backend.Start(ctx, pollID); err != nil {
+	start := backend.Start
+	if namedBackend, ok := backend.(NamedBackend); ok && poll.Type == "named" {
+		start = namedBackend.StartNamed
+	}
+
+	if err := start(ctx, pollID); err != nil {
 		return fmt.Errorf("starting poll in the backend: %w", err)
 	}
 
+	v.liveVotesMu.Lock()
+	v.pinned[pollID] = backend
+	if _, ok := v.liveVotes[pollID]; !ok {
+		v.liveVotes[pollID] = make(map[int][]byte)
+		v.publish(liveVotesEvent{pollID: pollID, meetingID: poll.MeetingID, userID2Vote: map[int]*string{}})
+	}
+	v.liveVotesMu.Unlock()
+
 	return nil
 }
 
@@ -117,7 +209,9 @@ type StopResult struct {
 //
 // This method is idempotence. Many requests with the same pollID will return
 // the same data. Calling vote.Clear will stop this behavior.
-func (v *Vote) Stop(ctx context.Context, pollID int) (StopResult, error) {
+func (v *Vote) Stop(ctx context.Context, pollID int) (result StopResult, err error) {
+	defer func() { v.audit(ctx, "stop", pollID, len(result.Votes), err) }()
+
 	ds := dsmodels.New(v.flow)
 	poll, err := ds.Poll(pollID).First(ctx)
 	if err != nil {
@@ -130,6 +224,13 @@ func (v *Vote) Stop(ctx context.Context, pollID int) (StopResult, error) {
 
 	backend := v.backend(poll)
 	ballots, userIDs, err := backend.Stop(ctx, pollID)
+	if other, ok := v.movedBackend(backend, err); ok {
+		ballots, userIDs, err = other.Stop(ctx, pollID)
+		if err == nil {
+			v.pin(pollID, other)
+		}
+	}
+
 	if err != nil {
 		var errNotExist interface{ DoesNotExist() }
 		if errors.As(err, &errNotExist) {
@@ -139,11 +240,17 @@ func (v *Vote) Stop(ctx context.Context, pollID int) (StopResult, error) {
 		return StopResult{}, fmt.Errorf("fetching vote objects: %w", err)
 	}
 
+	v.liveVotesMu.Lock()
+	v.notify()
+	v.liveVotesMu.Unlock()
+
 	return StopResult{ballots, userIDs}, nil
 }
 
 // Clear removes all knowlage of a poll.
-func (v *Vote) Clear(ctx context.Context, pollID int) error {
+func (v *Vote) Clear(ctx context.Context, pollID int) (err error) {
+	defer func() { v.audit(ctx, "clear", pollID, 0, err) }()
+
 	if err := v.fastBackend.Clear(ctx, pollID); err != nil {
 		return fmt.Errorf("clearing fastBackend: %w", err)
 	}
@@ -153,14 +260,20 @@ func (v *Vote) Clear(ctx context.Context, pollID int) error {
 	}
 
 	v.liveVotesMu.Lock()
-	v.liveVotes[pollID] = nil
+	delete(v.pinned, pollID)
+	if _, ok := v.liveVotes[pollID]; ok {
+		delete(v.liveVotes, pollID)
+		v.publish(liveVotesEvent{pollID: pollID})
+	}
 	v.liveVotesMu.Unlock()
 
 	return nil
 }
 
 // ClearAll removes all knowlage of all polls and the datastore-cache.
-func (v *Vote) ClearAll(ctx context.Context) error {
+func (v *Vote) ClearAll(ctx context.Context) (err error) {
+	defer func() { v.audit(ctx, "clear_all", 0, 0, err) }()
+
 	// Reset the cache if it has the ResetCach() method.
 	type ResetCacher interface {
 		Reset()
@@ -178,16 +291,39 @@ func (v *Vote) ClearAll(ctx context.Context) error {
 	}
 
 	v.liveVotesMu.Lock()
+	events := make([]liveVotesEvent, 0, len(v.liveVotes))
+	for pollID := range v.liveVotes {
+		events = append(events, liveVotesEvent{pollID: pollID})
+	}
 	v.liveVotes = make(map[int]map[int][]byte)
+	v.pinned = make(map[int]Backend)
+	v.publish(events...)
 	v.liveVotesMu.Unlock()
 
 	return nil
 }
 
 // Vote validates and saves the vote.
-func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) error {
+//
+// The votes of named polls are recorded by the Auditor with the caller
+// `user/ID`. For other polls, the user and the order of the entries could be
+// used to find the ballot of a user, so they are not recorded.
+func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) (err error) {
+	var named bool
+	defer func() {
+		if named {
+			v.audit(WithCaller(ctx, "user/"+strconv.Itoa(requestUser)), "vote", pollID, 0, err)
+		}
+	}()
+
+	ctx, span := tracing.Start(ctx, "Vote.Vote", attribute.Int("vote.poll_id", pollID), attribute.Int("vote.request_user_id", requestUser))
+	defer func() { tracing.End(span, err) }()
+
 	ds := dsmodels.New(v.flow)
-	poll, err := ds.Poll(pollID).First(ctx)
+
+	stepCtx, step := tracing.Start(ctx, "load poll")
+	poll, err := ds.Poll(pollID).First(stepCtx)
+	tracing.End(step, err)
 	if err != nil {
 		var doesNotExist dsfetch.DoesNotExistError
 		if errors.As(err, &doesNotExist) {
@@ -195,9 +331,13 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 		}
 		return fmt.Errorf("loading poll: %w", err)
 	}
-	log.Debug("Poll config: %v", poll)
+	log.DebugContext(ctx, "Poll config", "meeting_id", poll.MeetingID, "type", poll.Type, "method", poll.Pollmethod, "backend", poll.Backend)
+	named = poll.Type == "named"
 
-	if err := ensurePresent(ctx, &ds.Fetch, poll.MeetingID, requestUser); err != nil {
+	stepCtx, step = tracing.Start(ctx, "ensurePresent")
+	err = ensurePresent(stepCtx, &ds.Fetch, poll.MeetingID, requestUser)
+	tracing.End(step, err)
+	if err != nil {
 		return err
 	}
 
@@ -224,11 +364,17 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 		return MessageError(ErrNotAllowed, "You are not in the right meeting")
 	}
 
-	if err := ensureVoteUser(ctx, &ds.Fetch, poll, voteUser, voteMeetingUserID, requestUser); err != nil {
+	stepCtx, step = tracing.Start(ctx, "ensureVoteUser", attribute.Int("vote.vote_user_id", voteUser))
+	err = ensureVoteUser(stepCtx, &ds.Fetch, poll, voteUser, voteMeetingUserID, requestUser)
+	tracing.End(step, err)
+	if err != nil {
 		return err
 	}
 
-	if validation := validate(poll, vote.Value); validation != "" {
+	_, step = tracing.Start(ctx, "validate")
+	validation := validate(poll, vote.Value)
+	step.End()
+	if validation != "" {
 		return MessageError(ErrInvalid, validation)
 	}
 
@@ -240,7 +386,10 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 	ds.MeetingUser_VoteWeight(voteMeetingUserID).Lazy(&meetingUserVoteWeight)
 	ds.User_DefaultVoteWeight(voteUser).Lazy(&userDefaultVoteWeight)
 
-	if err := ds.Execute(ctx); err != nil {
+	stepCtx, step = tracing.Start(ctx, "load vote weight")
+	err = ds.Execute(stepCtx)
+	tracing.End(step, err)
+	if err != nil {
 		return fmt.Errorf("getting vote weight: %w", err)
 	}
 
@@ -256,7 +405,7 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 		voteWeight = decimal.NewFromInt(1)
 	}
 
-	log.Debug("Using voteWeight %s", voteWeight.String())
+	log.DebugContext(ctx, "Using vote weight", "weight", voteWeight.String())
 
 	voteData := struct {
 		RequestUser int             `json:"request_user_id,omitempty"`
@@ -280,7 +429,7 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 		return fmt.Errorf("decoding vote data: %w", err)
 	}
 
-	if err := v.backend(poll).Vote(ctx, pollID, voteUser, bs); err != nil {
+	if err := v.saveVote(ctx, poll, pollID, voteUser, bs); err != nil {
 		var errNotExist interface{ DoesNotExist() }
 		if errors.As(err, &errNotExist) {
 			return ErrNotExists
@@ -291,6 +440,10 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 			return ErrDoubleVote
 		}
 
+		if errors.Is(err, ErrConflict) {
+			return MessageError(ErrConflict, "The idempotency key was already used with a different vote")
+		}
+
 		var errNotOpen interface{ Stopped() }
 		if errors.As(err, &errNotOpen) {
 			return ErrStopped
@@ -309,11 +462,119 @@ func (v *Vote) Vote(ctx context.Context, pollID, requestUser int, r io.Reader) e
 		v.liveVotes[pollID] = make(map[int][]byte)
 	}
 	v.liveVotes[pollID][voteUser] = liveVote
+	v.publish(liveVotesEvent{
+		pollID:      pollID,
+		meetingID:   poll.MeetingID,
+		userID2Vote: map[int]*string{voteUser: liveVoteValue(poll, liveVote)},
+	})
 	v.liveVotesMu.Unlock()
 
 	return nil
 }
 
+// saveVote saves the vote in the backend of the poll.
+//
+// If the context has an idempotency key and the backend supports it, the key is
+// saved together with the vote. A retry with the same key and the same vote is
+// not an error. A retry with the same key but a different vote returns
+// ErrConflict.
+func (v *Vote) saveVote(ctx context.Context, poll dsmodels.Poll, pollID int, voteUser int, vote []byte) error {
+	backend := v.backend(poll)
+	err := v.saveVoteInBackend(ctx, backend, pollID, voteUser, vote)
+	if target, ok := v.migrationTarget(ctx, backend, pollID, err); ok {
+		// The poll is stopped, since it is moved to another backend. The vote
+		// finishes the migration and is saved in the new backend.
+		if err := v.migrate(ctx, poll, backend, target); err != nil {
+			return fmt.Errorf("finishing migration of poll %d: %w", pollID, err)
+		}
+		return v.saveVoteInBackend(ctx, target, pollID, voteUser, vote)
+	}
+
+	if other, ok := v.movedBackend(backend, err); ok {
+		err = v.saveVoteInBackend(ctx, other, pollID, voteUser, vote)
+		if err == nil {
+			v.pin(pollID, other)
+		}
+	}
+	return err
+}
+
+// saveVoteInBackend is like saveVote but uses the given backend.
+func (v *Vote) saveVoteInBackend(ctx context.Context, backend Backend, pollID int, voteUser int, vote []byte) error {
+	key := idempotencyKey(ctx)
+	if key == "" {
+		return backend.Vote(ctx, pollID, voteUser, vote)
+	}
+
+	idempotentBackend, ok := backend.(IdempotentBackend)
+	if !ok {
+		log.DebugContext(ctx, "Backend does not support idempotency keys", "backend", backend.String())
+		return backend.Vote(ctx, pollID, voteUser, vote)
+	}
+
+	token := idempotencyToken(v.idempotencySecret, voteUser, key, vote)
+	err := idempotentBackend.VoteIdempotent(ctx, pollID, voteUser, vote, token)
+	if err == nil {
+		return nil
+	}
+
+	var errDoubleVote interface {
+		DoubleVote()
+		IdempotencyKey() string
+	}
+	if !errors.As(err, &errDoubleVote) {
+		return err
+	}
+
+	savedKeyHash, _, _ := strings.Cut(errDoubleVote.IdempotencyKey(), ":")
+	keyHash, _, _ := strings.Cut(token, ":")
+	if savedKeyHash != keyHash {
+		return err
+	}
+
+	if errDoubleVote.IdempotencyKey() != token {
+		return ErrConflict
+	}
+
+	log.DebugContext(ctx, "Repeated vote with the same idempotency key")
+	return nil
+}
+
+type contextKey int
+
+const (
+	idempotencyKeyContextKey contextKey = iota
+	callerContextKey
+)
+
+// WithIdempotencyKey returns a context that holds an idempotency key for
+// vote.Vote.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey).(string)
+	return key
+}
+
+// idempotencyToken returns the value that is saved in the backend for an
+// idempotency key.
+//
+// It has the form `hmac(user, key):hmac(user, key, vote)`. The key is only saved
+// as a hash. Both parts use the server-side secret, so the saved value can not
+// be used to find out the user or the vote, even if the key is guessed.
+func idempotencyToken(secret []byte, userID int, key string, vote []byte) string {
+	id := hmac.New(sha256.New, secret)
+	fmt.Fprintf(id, "%d\x00%s", userID, key)
+
+	check := hmac.New(sha256.New, secret)
+	fmt.Fprintf(check, "%d\x00%s\x00", userID, key)
+	check.Write(vote)
+
+	return hex.EncodeToString(id.Sum(nil)) + ":" + hex.EncodeToString(check.Sum(nil))
+}
+
 // getMeetingUser returns the meeting_user id between a userID and a meetingID.
 func getMeetingUser(ctx context.Context, fetch *dsfetch.Fetch, userID, meetingID int) (int, bool, error) {
 	meetingUserIDs, err := fetch.User_MeetingUserIDs(userID).Value(ctx)
@@ -390,7 +651,7 @@ func ensureVoteUser(ctx context.Context, ds *dsfetch.Fetch, poll dsmodels.Poll,
 		return nil
 	}
 
-	log.Debug("Vote delegation")
+	log.DebugContext(ctx, "Vote delegation")
 
 	if !delegationActivated {
 		return MessageErrorf(ErrNotAllowed, "Vote delegation is not activated in meeting %d", poll.MeetingID)
@@ -489,17 +750,164 @@ func (v *Vote) Voted(ctx context.Context, pollIDs []int, requestUser int) (map[i
 	return out, nil
 }
 
+// VotablePolls returns all started polls, on which the requestUser or one of
+// its delegators can vote. For each poll, it returns the ids of these users,
+// that have already voted.
+func (v *Vote) VotablePolls(ctx context.Context, requestUser int) (map[int][]int, error) {
+	ds := dsfetch.New(v.flow)
+
+	ownMeetingUserIDs, err := ds.User_MeetingUserIDs(requestUser).Value(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching meeting users: %w", err)
+	}
+
+	delegatorIDs := make([][]int, len(ownMeetingUserIDs))
+	for i, muid := range ownMeetingUserIDs {
+		ds.MeetingUser_VoteDelegationsFromIDs(muid).Lazy(&delegatorIDs[i])
+	}
+
+	if err := ds.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("fetching vote delegations: %w", err)
+	}
+
+	meetingUserIDs := ownMeetingUserIDs
+	for _, ids := range delegatorIDs {
+		meetingUserIDs = append(meetingUserIDs, ids...)
+	}
+
+	userIDs := make([]int, len(meetingUserIDs))
+	meetingIDs := make([]int, len(meetingUserIDs))
+	groupIDs := make([][]int, len(meetingUserIDs))
+	for i, muid := range meetingUserIDs {
+		ds.MeetingUser_UserID(muid).Lazy(&userIDs[i])
+		ds.MeetingUser_MeetingID(muid).Lazy(&meetingIDs[i])
+		ds.MeetingUser_GroupIDs(muid).Lazy(&groupIDs[i])
+	}
+
+	if err := ds.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("fetching meeting user data: %w", err)
+	}
+
+	meetingPollIDs := make(map[int]*[]int)
+	for _, meetingID := range meetingIDs {
+		if _, ok := meetingPollIDs[meetingID]; ok {
+			continue
+		}
+		pollIDs := new([]int)
+		ds.Meeting_PollIDs(meetingID).Lazy(pollIDs)
+		meetingPollIDs[meetingID] = pollIDs
+	}
+
+	if err := ds.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("fetching polls of meetings: %w", err)
+	}
+
+	type pollData struct {
+		meetingID        int
+		state            string
+		entitledGroupIDs []int
+	}
+
+	polls := make(map[int]*pollData)
+	for meetingID, pollIDs := range meetingPollIDs {
+		for _, pollID := range *pollIDs {
+			p := pollData{meetingID: meetingID}
+			ds.Poll_State(pollID).Lazy(&p.state)
+			ds.Poll_EntitledGroupIDs(pollID).Lazy(&p.entitledGroupIDs)
+			polls[pollID] = &p
+		}
+	}
+
+	if err := ds.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("fetching poll data: %w", err)
+	}
+
+	v.liveVotesMu.Lock()
+	defer v.liveVotesMu.Unlock()
+
+	out := make(map[int][]int)
+	for pollID, poll := range polls {
+		if poll.state != "started" {
+			continue
+		}
+
+		entitled := false
+		voted := []int{}
+		for i, userID := range userIDs {
+			if meetingIDs[i] != poll.meetingID || !equalElement(groupIDs[i], poll.entitledGroupIDs) {
+				continue
+			}
+
+			entitled = true
+			if _, ok := v.liveVotes[pollID][userID]; ok {
+				voted = append(voted, userID)
+			}
+		}
+
+		if entitled {
+			slices.Sort(voted)
+			out[pollID] = voted
+		}
+	}
+
+	return out, nil
+}
+
+// LiveVotesFilter restricts the polls returned by FilteredLiveVotes. The zero
+// value does not filter.
+type LiveVotesFilter struct {
+	// PollIDs are the ids of the polls to return. If empty, all polls are
+	// returned.
+	PollIDs []int
+
+	// MeetingID is the id of the meeting of the polls. If 0, the polls from all
+	// meetings are returned.
+	MeetingID int
+}
+
+// matches tells, if a poll matches the filter. A meetingID of 0 means, that the
+// meeting is unknown. It matches every meeting.
+func (f LiveVotesFilter) matches(pollID, meetingID int) bool {
+	if len(f.PollIDs) > 0 && !slices.Contains(f.PollIDs, pollID) {
+		return false
+	}
+
+	if f.MeetingID != 0 && meetingID != 0 && meetingID != f.MeetingID {
+		return false
+	}
+
+	return true
+}
+
 // AllLiveVotes returns for all running polls the vote from each user.
 func (v *Vote) AllLiveVotes(ctx context.Context) map[int]map[int]*string {
-	v.liveVotesMu.Lock()
+	return v.FilteredLiveVotes(ctx, LiveVotesFilter{})
+}
+
+// FilteredLiveVotes is like AllLiveVotes but only returns the polls that match
+// the filter.
+func (v *Vote) FilteredLiveVotes(ctx context.Context, filter LiveVotesFilter) map[int]map[int]*string {
+	polls := v.lockWithPolls(ctx)
 	defer v.liveVotesMu.Unlock()
 
-	ds := dsmodels.New(v.flow)
+	return v.filteredLiveVotes(filter, polls)
+}
 
+// filteredLiveVotes is like FilteredLiveVotes but expects, that liveVotesMu is
+// locked. polls has to contain the polls of the live votes.
+func (v *Vote) filteredLiveVotes(filter LiveVotesFilter, polls map[int]*dsmodels.Poll) map[int]map[int]*string {
 	out := make(map[int]map[int]*string, len(v.liveVotes))
 	for pollID, userID2Vote := range v.liveVotes {
-		poll, err := ds.Poll(pollID).First(ctx)
-		if err != nil {
+		if !filter.matches(pollID, 0) {
+			continue
+		}
+
+		poll := polls[pollID]
+		if poll == nil {
+			continue
+		}
+
+		if !filter.matches(pollID, poll.MeetingID) {
 			continue
 		}
 
@@ -510,7 +918,7 @@ func (v *Vote) AllLiveVotes(ctx context.Context) map[int]map[int]*string {
 			// named vote. Remove the votes for all other votes.
 			for userID, vote := range userID2Vote {
 				if vote == nil {
-					out[pollID] = nil
+					out[pollID][userID] = nil
 					continue
 				}
 				str := string(vote)
@@ -538,10 +946,59 @@ func (v *Vote) loadVoted(ctx context.Context) error {
 		return fmt.Errorf("fetching data from long backend: %w", err)
 	}
 
-	maps.Copy(combinedData, longData)
+	pinned := make(map[int]Backend, len(combinedData)+len(longData))
+	for pollID := range longData {
+		pinned[pollID] = v.longBackend
+	}
+	for pollID := range combinedData {
+		pinned[pollID] = v.fastBackend
+	}
+
+	// A poll is in both backends, while it is migrated. It is pinned to the
+	// backend, that has the mark, until the migration is finished. So a vote
+	// finishes the migration, before it is saved in the new backend.
+	movedFrom := make(map[int]Backend)
+	for _, backend := range v.backends() {
+		moved, err := movedPolls(ctx, backend)
+		if err != nil {
+			return err
+		}
+
+		for pollID := range moved {
+			movedFrom[pollID] = backend
+			pinned[pollID] = backend
+		}
+	}
+
+	for pollID, userID2Vote := range longData {
+		if combinedData[pollID] == nil {
+			combinedData[pollID] = userID2Vote
+			continue
+		}
+
+		for userID, vote := range userID2Vote {
+			if _, ok := combinedData[pollID][userID]; !ok {
+				combinedData[pollID][userID] = vote
+			}
+		}
+	}
+
+	// The polls are fetched before liveVotesMu is locked, so a slow datastore
+	// does not block the other requests.
+	polls := v.fetchPolls(ctx, slices.Collect(maps.Keys(combinedData)))
 
 	v.liveVotesMu.Lock()
+	// An existing pin is only changed for a migrated poll. Without a mark,
+	// the poll can be in both backends for a moment, when it is migrated by an
+	// instance, that does not save the mark.
+	for pollID, backend := range pinned {
+		if _, ok := v.pinned[pollID]; !ok || movedFrom[pollID] != nil {
+			v.pinned[pollID] = backend
+		}
+	}
+	old := v.liveVotes
 	v.liveVotes = combinedData
+	v.publish(v.liveVotesEvents(old, combinedData, polls)...)
 	v.liveVotesMu.Unlock()
 	return nil
 }
@@ -581,6 +1038,117 @@ type Backend interface {
 	fmt.Stringer
 }
 
+// IdempotentBackend is an optional interface for a Backend. It saves an
+// idempotency key atomically together with the vote.
+type IdempotentBackend interface {
+	// VoteIdempotent is like Vote, but also saves the given key for the user.
+	//
+	// If the user has already voted, the error with the method `DoubleVote()`
+	// also needs a method `IdempotencyKey() string`, that returns the key,
+	// that was saved with the first vote. It is an empty string, if the first
+	// vote had no key.
+	//
+	// The part of the key before the first `:` identifies the user and the
+	// key. A backend can use it to find the saved key instead of the user id,
+	// so the user is not saved next to the vote. Such a backend returns an
+	// empty string, if no key with the same id was saved.
+	VoteIdempotent(ctx context.Context, pollID int, userID int, object []byte, key string) error
+}
+
+// NamedBackend is an optional interface for a Backend, that only saves the link
+// between a user and its vote for named polls.
+type NamedBackend interface {
+	// StartNamed is like Start, but for a named poll. For such a poll, the
+	// backend saves the link between a user and its vote and returns the votes
+	// with LiveVotes. Polls started with Start are secret.
+	StartNamed(ctx context.Context, pollID int) error
+}
+
+// ChangeType is the kind of a BackendChange.
+type ChangeType string
+
+// The types of a BackendChange.
+const (
+	ChangeStart    ChangeType = "start"
+	ChangeVote     ChangeType = "vote"
+	ChangeStop     ChangeType = "stop"
+	ChangeClear    ChangeType = "clear"
+	ChangeClearAll ChangeType = "clear_all"
+
+	// ChangeReload tells, that the live votes of the poll have to be loaded
+	// from the backend, since the change was too big to be sent.
+	ChangeReload ChangeType = "reload"
+)
+
+// BackendChange is a change of a backend, that was done by any instance of the
+// service.
+type BackendChange struct {
+	Type   ChangeType `json:"type"`
+	PollID int        `json:"poll_id,omitempty"`
+	UserID int        `json:"user_id,omitempty"`
+	Vote   []byte     `json:"vote,omitempty"`
+}
+
+// ChangeListener is an optional interface for a Backend. It informs all
+// instances of the service about the changes of the backend.
+type ChangeListener interface {
+	// ListenChanges calls change for each change of the backend, including the
+	// changes of the own instance. It calls ready each time it (re)starts
+	// listening, so the caller can load the data, that changed in the meantime.
+	//
+	// It blocks until the context is done or the connection fails.
+	ListenChanges(ctx context.Context, ready func(), change func(BackendChange)) error
+}
+
+// MoveBackend is an optional interface for a Backend. It saves, that a poll is
+// moved to another backend, so all instances know about a migration, even after
+// a restart.
+type MoveBackend interface {
+	// SetMoved marks the poll as moved to the backend with the given name. An
+	// empty name removes the mark. Clear also removes it.
+	//
+	// If the poll does not exist, the error has to have the method
+	// `DoesNotExist()`.
+	SetMoved(ctx context.Context, pollID int, backend string) error
+
+	// Moved returns the name of the new backend for each marked poll.
+	Moved(ctx context.Context) (map[int]string, error)
+}
+
+// Pinger is an optional interface for a Backend or the flow. It checks, if the
+// storage is reachable.
+type Pinger interface {
+	// Ping returns an error, if the storage can not be reached.
+	Ping(ctx context.Context) error
+}
+
+// Closer is an optional interface for a Backend, that holds connections or
+// files.
+type Closer interface {
+	// Close releases the resources of the backend. It is called at the end of
+	// the shutdown, when no request uses the backend anymore.
+	Close() error
+}
+
+// Close closes a backend, if it implements Closer.
+func Close(backend Backend) error {
+	closer, ok := backend.(Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
+
+// Ping checks, if a backend or the flow is reachable. If it does not implement
+// Pinger, it is always reachable.
+func Ping(ctx context.Context, dependency any) error {
+	pinger, ok := dependency.(Pinger)
+	if !ok {
+		return nil
+	}
+	return pinger.Ping(ctx)
+}
+
 // preload loads all data in the cache, that is needed later for the vote
 // requests.
 func preload(ctx context.Context, ds *dsfetch.Fetch, poll dsmodels.Poll) error {
@@ -4,14 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/OpenSlides/openslides-go/datastore/cache"
 	"github.com/OpenSlides/openslides-go/datastore/dsmock"
 	"github.com/OpenSlides/openslides-go/datastore/flow"
 	"github.com/OpenSlides/openslides-vote-service/backend/memory"
+	"github.com/OpenSlides/openslides-vote-service/tracing/tracingtest"
 	"github.com/OpenSlides/openslides-vote-service/vote"
 )
 
@@ -21,7 +24,7 @@ func TestVoteStart(t *testing.T) {
 	t.Run("Unknown poll", func(t *testing.T) {
 		backend := memory.New()
 		ds := dsmock.NewFlow(dsmock.YAMLData(""))
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		err := v.Start(ctx, 1)
 		if !errors.Is(err, vote.ErrNotExists) {
@@ -52,7 +55,7 @@ func TestVoteStart(t *testing.T) {
 		)
 		counter := ds.Middlewares()[0].(*dsmock.Counter)
 
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		if err := v.Start(ctx, 1); err != nil {
 			t.Errorf("Start returned unexpected error: %v", err)
@@ -86,7 +89,7 @@ func TestVoteStart(t *testing.T) {
 		user/1/is_present_in_meeting_ids: [1]
 		meeting/5/id: 5
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 		v.Start(ctx, 1)
 
 		if err := v.Start(ctx, 1); err != nil {
@@ -112,7 +115,7 @@ func TestVoteStart(t *testing.T) {
 		user/1/is_present_in_meeting_ids: [1]
 		meeting/5/id: 5
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 		v.Start(ctx, 1)
 
 		if _, _, err := backend.Stop(ctx, 1); err != nil {
@@ -141,7 +144,7 @@ func TestVoteStart(t *testing.T) {
 
 		user/1/is_present_in_meeting_ids: [1]
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		err := v.Start(ctx, 1)
 
@@ -168,7 +171,7 @@ func TestVoteStart(t *testing.T) {
 		user/1/is_present_in_meeting_ids: [1]
 		meeting/5/id: 5
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		err := v.Start(ctx, 1)
 		if err != nil {
@@ -193,7 +196,7 @@ func TestVoteStart(t *testing.T) {
 
 		user/1/is_present_in_meeting_ids: [1]
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		err := v.Start(ctx, 1)
 
@@ -219,7 +222,7 @@ func TestVoteStart(t *testing.T) {
 
 		user/1/is_present_in_meeting_ids: [1]
 		`)}
-		v, _, _ := vote.New(ctx, backend, backend, ds, true)
+		v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 		err := v.Start(ctx, 1)
 
@@ -233,7 +236,7 @@ func TestVoteStartDSError(t *testing.T) {
 	ctx := context.Background()
 	backend := memory.New()
 	ds := &StubGetter{err: errors.New("Some error")}
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 	err := v.Start(ctx, 1)
 
 	if err == nil {
@@ -276,7 +279,7 @@ func TestVoteStop(t *testing.T) {
 			title: myPoll
 	`)}
 
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 	t.Run("Unknown poll", func(t *testing.T) {
 		_, err := v.Stop(ctx, 404)
@@ -345,7 +348,7 @@ func TestVoteStop(t *testing.T) {
 func TestVoteClear(t *testing.T) {
 	ctx := context.Background()
 	backend := memory.New()
-	v, _, _ := vote.New(ctx, backend, backend, &StubGetter{}, true)
+	v, _, _ := vote.New(ctx, backend, backend, &StubGetter{}, true, nil)
 
 	if err := v.Clear(ctx, 1); err != nil {
 		t.Fatalf("Clear returned unexpected error: %v", err)
@@ -355,7 +358,7 @@ func TestVoteClear(t *testing.T) {
 func TestVoteClearAll(t *testing.T) {
 	ctx := context.Background()
 	backend := memory.New()
-	v, _, _ := vote.New(ctx, backend, backend, &StubGetter{}, true)
+	v, _, _ := vote.New(ctx, backend, backend, &StubGetter{}, true, nil)
 
 	if err := v.ClearAll(ctx); err != nil {
 		t.Fatalf("ClearAll returned unexpected error: %v", err)
@@ -392,7 +395,7 @@ func TestVoteVote(t *testing.T) {
 			meeting_id: 1
 		`),
 	}
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 	t.Run("Poll does not exist in DS", func(t *testing.T) {
 		err := v.Vote(ctx, 404, 1, strings.NewReader(`{"value":"Y"}`))
@@ -482,6 +485,167 @@ func TestVoteVote(t *testing.T) {
 	})
 }
 
+func TestVoteIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	ds := &StubGetter{
+		data: dsmock.YAMLData(`
+		poll/1:
+			meeting_id: 1
+			entitled_group_ids: [1]
+			pollmethod: Y
+			global_yes: true
+			global_no: true
+			backend: fast
+			type: pseudoanonymous
+			content_object_id: some_field/1
+			sequential_number: 1
+			onehundred_percent_base: base
+			title: myPoll
+
+		meeting/1/id: 1
+
+		user/1:
+			is_present_in_meeting_ids: [1]
+			meeting_user_ids: [10]
+
+		meeting_user/10:
+			user_id: 1
+			group_ids: [1]
+			meeting_id: 1
+		`),
+	}
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, []byte("my secret"))
+
+	if err := backend.Start(ctx, 1); err != nil {
+		t.Fatalf("Starting poll returned unexpected error: %v", err)
+	}
+
+	keyCtx := vote.WithIdempotencyKey(ctx, "my-key")
+
+	if err := v.Vote(keyCtx, 1, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+		t.Fatalf("Vote returned unexpected error: %v", err)
+	}
+
+	t.Run("Same key and same body", func(t *testing.T) {
+		if err := v.Vote(keyCtx, 1, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Errorf("Vote returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Same key and different body", func(t *testing.T) {
+		err := v.Vote(keyCtx, 1, 1, strings.NewReader(`{"value":"N"}`))
+		if !errors.Is(err, vote.ErrConflict) {
+			t.Errorf("Expected ErrConflict, got: %v", err)
+		}
+	})
+
+	t.Run("Different key", func(t *testing.T) {
+		err := v.Vote(vote.WithIdempotencyKey(ctx, "other-key"), 1, 1, strings.NewReader(`{"value":"Y"}`))
+		if !errors.Is(err, vote.ErrDoubleVote) {
+			t.Errorf("Expected ErrDoubleVote, got: %v", err)
+		}
+	})
+
+	t.Run("Without key", func(t *testing.T) {
+		err := v.Vote(ctx, 1, 1, strings.NewReader(`{"value":"Y"}`))
+		if !errors.Is(err, vote.ErrDoubleVote) {
+			t.Errorf("Expected ErrDoubleVote, got: %v", err)
+		}
+	})
+
+	t.Run("Other instance with the same secret", func(t *testing.T) {
+		other, _, _ := vote.New(ctx, backend, backend, ds, true, []byte("my secret"))
+
+		if err := other.Vote(keyCtx, 1, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Errorf("Vote returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Other secret", func(t *testing.T) {
+		other, _, _ := vote.New(ctx, backend, backend, ds, true, []byte("other secret"))
+
+		err := other.Vote(keyCtx, 1, 1, strings.NewReader(`{"value":"Y"}`))
+		if !errors.Is(err, vote.ErrDoubleVote) {
+			t.Errorf("Expected ErrDoubleVote, got: %v", err)
+		}
+	})
+
+	result, err := v.Stop(ctx, 1)
+	if err != nil {
+		t.Fatalf("Stop returned unexpected error: %v", err)
+	}
+
+	if len(result.Votes) != 1 {
+		t.Errorf("Stop returned %d votes, expected 1", len(result.Votes))
+	}
+}
+
+type auditorStub struct {
+	entries []string
+}
+
+func (a *auditorStub) Audit(ctx context.Context, operation string, caller string, pollID int, votes int, err error) {
+	a.entries = append(a.entries, fmt.Sprintf("%s %s %d", operation, caller, pollID))
+}
+
+func TestVoteAudit(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	ds := &StubGetter{
+		data: dsmock.YAMLData(`
+		poll:
+			1:
+				meeting_id: 1
+				entitled_group_ids: [1]
+				pollmethod: Y
+				global_yes: true
+				backend: fast
+				type: named
+				content_object_id: some_field/1
+				sequential_number: 1
+				onehundred_percent_base: base
+				title: named poll
+			2:
+				meeting_id: 1
+				entitled_group_ids: [1]
+				pollmethod: Y
+				global_yes: true
+				backend: fast
+				type: pseudoanonymous
+				content_object_id: some_field/1
+				sequential_number: 2
+				onehundred_percent_base: base
+				title: secret poll
+
+		meeting/1/id: 1
+
+		user/1:
+			is_present_in_meeting_ids: [1]
+			meeting_user_ids: [10]
+
+		meeting_user/10:
+			user_id: 1
+			group_ids: [1]
+			meeting_id: 1
+		`),
+	}
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+	auditor := &auditorStub{}
+	v.Auditor = auditor
+
+	for _, pollID := range []int{1, 2} {
+		backend.Start(ctx, pollID)
+		if err := v.Vote(ctx, pollID, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Fatalf("Vote on poll %d returned unexpected error: %v", pollID, err)
+		}
+	}
+
+	if expect := []string{"vote user/1 1"}; !reflect.DeepEqual(auditor.entries, expect) {
+		t.Errorf("Got audit entries %v, expected %v", auditor.entries, expect)
+	}
+}
+
 func TestVoteNoRequests(t *testing.T) {
 	// This tests makes sure, that a request to vote does not do any reading
 	// from the database. All values have to be in the cache from pollpreload.
@@ -652,7 +816,7 @@ func TestVoteNoRequests(t *testing.T) {
 			counter := ds.Middlewares()[0].(*dsmock.Counter)
 			cachedDS := cache.New(ds)
 			backend := memory.New()
-			v, _, _ := vote.New(ctx, backend, backend, cachedDS, true)
+			v, _, _ := vote.New(ctx, backend, backend, cachedDS, true, nil)
 
 			if err := v.Start(ctx, 1); err != nil {
 				t.Fatalf("Can not start poll: %v", err)
@@ -1194,7 +1358,7 @@ func TestVoteDelegationAndGroup(t *testing.T) {
 			backend := memory.New()
 			ds := &StubGetter{data: dsmock.YAMLData(tt.data)}
 
-			v, _, _ := vote.New(ctx, backend, backend, ds, true)
+			v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 			if err := backend.Start(ctx, 1); err != nil {
 				t.Fatalf("backend.Start(): %v", err)
@@ -1368,7 +1532,7 @@ func TestVoteWeight(t *testing.T) {
 			ctx := context.Background()
 			backend := memory.New()
 			ds := &StubGetter{data: dsmock.YAMLData(tt.data)}
-			v, _, _ := vote.New(ctx, backend, backend, ds, true)
+			v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 			if err := backend.Start(ctx, 1); err != nil {
 				t.Fatalf("bakckend.Start: %v", err)
@@ -1398,6 +1562,49 @@ func TestVoteWeight(t *testing.T) {
 	}
 }
 
+func TestVoteSpans(t *testing.T) {
+	spans := tracingtest.Record(t)
+
+	ctx := context.Background()
+	backend := memory.New()
+	ds := &StubGetter{data: dsmock.YAMLData(`
+	poll/1:
+		meeting_id: 1
+		entitled_group_ids: [1]
+		pollmethod: Y
+		global_yes: true
+		backend: fast
+		type: pseudoanonymous
+		content_object_id: some_field/1
+		sequential_number: 1
+		onehundred_percent_base: base
+		title: myPoll
+
+	meeting/1/id: 1
+
+	user/1:
+		is_present_in_meeting_ids: [1]
+		meeting_user_ids: [10]
+	meeting_user/10:
+		group_ids: [1]
+		meeting_id: 1
+	`)}
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+
+	if err := backend.Start(ctx, 1); err != nil {
+		t.Fatalf("bakckend.Start: %v", err)
+	}
+
+	if err := v.Vote(ctx, 1, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+		t.Fatalf("vote returned unexpected error: %v", err)
+	}
+
+	expect := []string{"load poll", "ensurePresent", "ensureVoteUser", "validate", "load vote weight", "Vote.Vote"}
+	if got := tracingtest.Names(spans); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got spans %v, expected %v", got, expect)
+	}
+}
+
 func TestItLikeBackend(t *testing.T) {
 	ctx := context.Background()
 	backend := memory.New()
@@ -1459,7 +1666,7 @@ func TestItLikeBackend(t *testing.T) {
 		onehundred_percent_base: base
 	`))
 
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 	if err := backend.Start(ctx, 1); err != nil {
 		t.Fatalf("bakckend.Start: %v", err)
 	}
@@ -1488,7 +1695,7 @@ func TestVotedPolls(t *testing.T) {
 	backend.Start(ctx, 1)
 	backend.Vote(ctx, 1, 5, []byte(`"Y"`))
 
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 	got, err := v.Voted(ctx, []int{1, 2}, 5)
 	if err != nil {
@@ -1528,7 +1735,7 @@ func TestVotedPollsWithDelegation(t *testing.T) {
 	backend.Vote(ctx, 1, 5, []byte(`"Y"`))
 	backend.Vote(ctx, 1, 6, []byte(`"Y"`))
 	backend.Vote(ctx, 1, 7, []byte(`"Y"`))
-	v, _, _ := vote.New(ctx, backend, backend, ds, true)
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
 
 	got, err := v.Voted(ctx, []int{1, 2}, 5)
 	if err != nil {
@@ -1541,6 +1748,55 @@ func TestVotedPollsWithDelegation(t *testing.T) {
 	}
 }
 
+func TestVotablePolls(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	ds := dsmock.NewFlow(dsmock.YAMLData(`---
+	meeting/1/poll_ids: [1, 2, 3]
+
+	poll:
+		1:
+			meeting_id: 1
+			state: started
+			entitled_group_ids: [1]
+		2:
+			meeting_id: 1
+			state: finished
+			entitled_group_ids: [1]
+		3:
+			meeting_id: 1
+			state: started
+			entitled_group_ids: [2]
+
+	user/5:
+		meeting_user_ids: [10]
+	meeting_user:
+		10:
+			user_id: 5
+			meeting_id: 1
+			group_ids: [2]
+			vote_delegations_from_ids: [11]
+		11:
+			user_id: 6
+			meeting_id: 1
+			group_ids: [1]
+	`))
+
+	backend.Start(ctx, 1)
+	backend.Vote(ctx, 1, 6, []byte(`"Y"`))
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+
+	got, err := v.VotablePolls(ctx, 5)
+	if err != nil {
+		t.Fatalf("VotablePolls() returned unexected error: %v", err)
+	}
+
+	expect := map[int][]int{1: {6}, 3: {}}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("VotablePolls() == `%v`, expected `%v`", got, expect)
+	}
+}
+
 func TestAllLiveVotesIDs_LiveVote_enabled_type_is_named(t *testing.T) {
 	ctx := context.Background()
 	backend1 := memory.New()
@@ -1576,7 +1832,7 @@ func TestAllLiveVotesIDs_LiveVote_enabled_type_is_named(t *testing.T) {
 			content_object_id: assignment/1
 	`))
 
-	v, _, _ := vote.New(ctx, backend1, backend2, ds, true)
+	v, _, _ := vote.New(ctx, backend1, backend2, ds, true, nil)
 
 	liveVotes := resolvePointers(v.AllLiveVotes(ctx))
 
@@ -1633,7 +1889,7 @@ func TestAllLiveVotesIDs_LiveVote_disabled_or_type_is_not_named(t *testing.T) {
 			content_object_id: assignment/1
 	`))
 
-	v, _, _ := vote.New(ctx, backend1, backend2, ds, true)
+	v, _, _ := vote.New(ctx, backend1, backend2, ds, true, nil)
 
 	liveVotes := v.AllLiveVotes(ctx)
 
@@ -1642,3 +1898,464 @@ func TestAllLiveVotesIDs_LiveVote_disabled_or_type_is_not_named(t *testing.T) {
 		t.Errorf("Got %v, expected %v", liveVotes, expect)
 	}
 }
+
+func TestFilteredLiveVotes(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	for _, pollID := range []int{1, 2, 3} {
+		backend.Start(ctx, pollID)
+		backend.Vote(ctx, pollID, 1, []byte("vote"))
+	}
+
+	ds := dsmock.NewFlow(dsmock.YAMLData(`---
+	poll:
+		1:
+			type: pseudoanonymous
+			meeting_id: 7
+		2:
+			type: pseudoanonymous
+			meeting_id: 8
+		3:
+			type: pseudoanonymous
+			meeting_id: 7
+	`))
+
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+
+	for _, tt := range []struct {
+		name   string
+		filter vote.LiveVotesFilter
+		expect map[int]map[int]*string
+	}{
+		{"no filter", vote.LiveVotesFilter{}, map[int]map[int]*string{1: {1: nil}, 2: {1: nil}, 3: {1: nil}}},
+		{"ids", vote.LiveVotesFilter{PollIDs: []int{2, 3}}, map[int]map[int]*string{2: {1: nil}, 3: {1: nil}}},
+		{"meeting", vote.LiveVotesFilter{MeetingID: 7}, map[int]map[int]*string{1: {1: nil}, 3: {1: nil}}},
+		{"ids and meeting", vote.LiveVotesFilter{PollIDs: []int{1, 2}, MeetingID: 8}, map[int]map[int]*string{2: {1: nil}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := v.FilteredLiveVotes(ctx, tt.filter)
+
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Got %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestFilteredLiveVotesSlowDatastore(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	backend.Start(ctx, 1)
+
+	ds := &blockingGetter{
+		blocked: make(chan struct{}, 1),
+		unblock: make(chan struct{}),
+	}
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+
+	ds.blocking.Store(true)
+	done := make(chan struct{})
+	go func() {
+		v.AllLiveVotes(ctx)
+		close(done)
+	}()
+	defer func() {
+		close(ds.unblock)
+		<-done
+	}()
+
+	select {
+	case <-ds.blocked:
+	case <-time.After(time.Second):
+		t.Fatalf("AllLiveVotes did not fetch the poll")
+	}
+
+	// Other requests must not wait for the datastore.
+	cleared := make(chan error, 1)
+	go func() { cleared <- v.Clear(ctx, 1) }()
+
+	select {
+	case err := <-cleared:
+		if err != nil {
+			t.Errorf("Clear returned unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Clear waited for the datastore")
+	}
+}
+
+func TestSubscribeLiveVotes(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.New()
+	ds := dsmock.NewFlow(dsmock.YAMLData(`---
+	poll:
+		1:
+			meeting_id: 7
+			backend: fast
+			type: named
+			live_voting_enabled: true
+			pollmethod: Y
+			global_yes: true
+			entitled_group_ids: [1]
+			content_object_id: some_field/1
+			sequential_number: 1
+			onehundred_percent_base: base
+			title: myPoll
+		2:
+			meeting_id: 8
+			backend: fast
+			type: pseudoanonymous
+			pollmethod: Y
+			content_object_id: some_field/1
+			sequential_number: 2
+			onehundred_percent_base: base
+			title: myPoll
+
+	user/1:
+		is_present_in_meeting_ids: [7]
+		meeting_user_ids: [10]
+
+	meeting_user/10:
+		group_ids: [1]
+		meeting_id: 7
+		user_id: 1
+	`))
+
+	v, _, _ := vote.New(ctx, backend, backend, ds, true, nil)
+
+	snapshot, changes, unsubscribe := v.SubscribeLiveVotes(ctx, vote.LiveVotesFilter{MeetingID: 7})
+	defer unsubscribe()
+
+	if len(snapshot) != 0 {
+		t.Errorf("Got snapshot %v, expected an empty snapshot", snapshot)
+	}
+
+	if err := v.Start(ctx, 2); err != nil {
+		t.Fatalf("Start poll 2: %v", err)
+	}
+
+	if err := v.Start(ctx, 1); err != nil {
+		t.Fatalf("Start poll 1: %v", err)
+	}
+
+	if got, expect := <-changes, (map[int]map[int]*string{1: {}}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("After start: got %v, expected %v", got, expect)
+	}
+
+	if err := v.Vote(ctx, 1, 1, strings.NewReader(`{"value":"Y"}`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+
+	got := <-changes
+	if vote := got[1][1]; vote == nil || !strings.Contains(*vote, `"value":"Y"`) {
+		t.Errorf("After vote: got %v, expected the vote of user 1", got)
+	}
+
+	if err := v.Clear(ctx, 1); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	if got, expect := <-changes, (map[int]map[int]*string{1: nil}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("After clear: got %v, expected %v", got, expect)
+	}
+}
+
+type changeListenerStub struct {
+	*memory.Backend
+	listening chan struct{}
+	changes   chan vote.BackendChange
+}
+
+func (b *changeListenerStub) ListenChanges(ctx context.Context, ready func(), change func(vote.BackendChange)) error {
+	ready()
+	close(b.listening)
+
+	for {
+		select {
+		case c := <-b.changes:
+			change(c)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func TestListenChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := &changeListenerStub{
+		Backend:   memory.New(),
+		listening: make(chan struct{}),
+		changes:   make(chan vote.BackendChange),
+	}
+
+	ds := dsmock.NewFlow(dsmock.YAMLData(`---
+	poll/1:
+		meeting_id: 7
+		backend: fast
+		type: named
+		live_voting_enabled: true
+		pollmethod: Y
+		content_object_id: some_field/1
+		sequential_number: 1
+		onehundred_percent_base: base
+		title: myPoll
+	`))
+
+	v, bg, _ := vote.New(ctx, backend, backend, ds, false, nil)
+	go bg(ctx, func(err error) { t.Errorf("background error: %v", err) })
+	<-backend.listening
+
+	_, changes, unsubscribe := v.SubscribeLiveVotes(ctx, vote.LiveVotesFilter{})
+	defer unsubscribe()
+
+	backend.changes <- vote.BackendChange{Type: vote.ChangeStart, PollID: 1}
+	if got, expect := <-changes, (map[int]map[int]*string{1: {}}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("After start: got %v, expected %v", got, expect)
+	}
+
+	// The same change twice has to be ignored.
+	backend.changes <- vote.BackendChange{Type: vote.ChangeStart, PollID: 1}
+
+	backend.changes <- vote.BackendChange{Type: vote.ChangeVote, PollID: 1, UserID: 5, Vote: []byte(`"Y"`)}
+	got := <-changes
+	if vote := got[1][5]; vote == nil || *vote != `"Y"` {
+		t.Errorf("After vote: got %v, expected the vote of user 5", got)
+	}
+
+	// A vote of a named poll without the ballot has to be loaded from the
+	// backend.
+	if err := backend.Start(ctx, 1); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := backend.Backend.Vote(ctx, 1, 6, []byte(`"N"`)); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	backend.changes <- vote.BackendChange{Type: vote.ChangeVote, PollID: 1, UserID: 6}
+	got = <-changes
+	if vote := got[1][6]; vote == nil || *vote != `"N"` {
+		t.Errorf("After vote without ballot: got %v, expected the vote of user 6", got)
+	}
+
+	backend.changes <- vote.BackendChange{Type: vote.ChangeClear, PollID: 1}
+	if got, expect := <-changes, (map[int]map[int]*string{1: nil}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("After clear: got %v, expected %v", got, expect)
+	}
+}
+
+func TestMigratePoll(t *testing.T) {
+	ctx := context.Background()
+	fast := memory.New()
+	long := memory.New()
+
+	ds := dsmock.NewFlow(dsmock.YAMLData(`---
+	poll:
+		1:
+			meeting_id: 1
+			backend: fast
+			type: named
+			state: started
+			pollmethod: Y
+			content_object_id: some_field/1
+			sequential_number: 1
+			onehundred_percent_base: base
+			title: myPoll
+		2:
+			meeting_id: 1
+			backend: fast
+			type: named
+			state: finished
+			pollmethod: Y
+			content_object_id: some_field/1
+			sequential_number: 1
+			onehundred_percent_base: base
+			title: myPoll
+	`))
+
+	if err := fast.Start(ctx, 1); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	fast.Vote(ctx, 1, 1, []byte(`"Y"`))
+	fast.Vote(ctx, 1, 2, []byte(`"N"`))
+
+	v, _, _ := vote.New(ctx, fast, long, ds, true, nil)
+
+	t.Run("Unknown backend", func(t *testing.T) {
+		if err := v.MigratePoll(ctx, 1, "other"); !errors.Is(err, vote.ErrInvalid) {
+			t.Errorf("MigratePoll returned %v, expected ErrInvalid", err)
+		}
+	})
+
+	t.Run("Poll not started", func(t *testing.T) {
+		if err := v.MigratePoll(ctx, 2, "long"); !errors.Is(err, vote.ErrInvalid) {
+			t.Errorf("MigratePoll returned %v, expected ErrInvalid", err)
+		}
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		if err := v.MigratePoll(ctx, 1, "long"); err != nil {
+			t.Fatalf("MigratePoll: %v", err)
+		}
+
+		fastVotes, _ := fast.LiveVotes(ctx)
+		if _, ok := fastVotes[1]; ok {
+			t.Errorf("Poll is still in the fast backend")
+		}
+
+		long.AssertUserHasVoted(t, 1, 1)
+		long.AssertUserHasVoted(t, 1, 2)
+
+		// The poll is pinned to the long backend, even if poll/backend is fast.
+		result, err := v.Stop(ctx, 1)
+		if err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.UserIDs, []int{1, 2}) {
+			t.Errorf("Got users %v, expected [1 2]", result.UserIDs)
+		}
+	})
+
+	t.Run("Same backend", func(t *testing.T) {
+		if err := v.MigratePoll(ctx, 1, "long"); err != nil {
+			t.Errorf("MigratePoll: %v", err)
+		}
+	})
+}
+
+// migrationBackend is a memory backend, whose Stop and Vote can fail.
+type migrationBackend struct {
+	*memory.Backend
+	failStop bool
+	failVote bool
+}
+
+func (b *migrationBackend) Stop(ctx context.Context, pollID int) ([][]byte, []int, error) {
+	if b.failStop {
+		return nil, nil, errors.New("stop failed")
+	}
+	return b.Backend.Stop(ctx, pollID)
+}
+
+func (b *migrationBackend) Vote(ctx context.Context, pollID int, userID int, object []byte) error {
+	if b.failVote {
+		return errors.New("vote failed")
+	}
+	return b.Backend.Vote(ctx, pollID, userID, object)
+}
+
+func TestMigratePollFailure(t *testing.T) {
+	ctx := context.Background()
+
+	ds := &StubGetter{
+		data: dsmock.YAMLData(`
+		poll/1:
+			meeting_id: 1
+			entitled_group_ids: [1]
+			pollmethod: Y
+			global_yes: true
+			backend: fast
+			type: named
+			state: started
+			content_object_id: some_field/1
+			sequential_number: 1
+			onehundred_percent_base: base
+			title: myPoll
+
+		meeting/1/id: 1
+
+		user/3:
+			is_present_in_meeting_ids: [1]
+			meeting_user_ids: [30]
+
+		meeting_user/30:
+			user_id: 3
+			group_ids: [1]
+			meeting_id: 1
+		`),
+	}
+
+	newPoll := func(t *testing.T) (*migrationBackend, *migrationBackend) {
+		t.Helper()
+
+		fast := &migrationBackend{Backend: memory.New()}
+		long := &migrationBackend{Backend: memory.New()}
+		if err := fast.Start(ctx, 1); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		fast.Vote(ctx, 1, 1, []byte(`"Y"`))
+		fast.Vote(ctx, 1, 2, []byte(`"N"`))
+		return fast, long
+	}
+
+	t.Run("Shared storage", func(t *testing.T) {
+		fast, _ := newPoll(t)
+		long := &migrationBackend{Backend: fast.Backend}
+		v, _, _ := vote.New(ctx, fast, long, ds, true, nil)
+
+		if err := v.MigratePoll(ctx, 1, "long"); !errors.Is(err, vote.ErrInvalid) {
+			t.Fatalf("MigratePoll returned %v, expected ErrInvalid", err)
+		}
+
+		moved, _ := fast.Moved(ctx)
+		if len(moved) != 0 {
+			t.Errorf("Poll is still marked: %v", moved)
+		}
+
+		if err := v.Vote(ctx, 1, 3, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Errorf("Vote after the failed migration: %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		fast, long := newPoll(t)
+		v, _, _ := vote.New(ctx, fast, long, ds, true, nil)
+
+		fast.failStop = true
+		if err := v.MigratePoll(ctx, 1, "long"); err == nil {
+			t.Fatalf("MigratePoll did not return an error")
+		}
+		fast.failStop = false
+
+		moved, _ := fast.Moved(ctx)
+		if len(moved) != 0 {
+			t.Errorf("Poll is still marked: %v", moved)
+		}
+
+		if err := v.Vote(ctx, 1, 3, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Fatalf("Vote after the failed migration: %v", err)
+		}
+		fast.AssertUserHasVoted(t, 1, 3)
+	})
+
+	t.Run("Resume with a vote", func(t *testing.T) {
+		fast, long := newPoll(t)
+		v, _, _ := vote.New(ctx, fast, long, ds, true, nil)
+
+		long.failVote = true
+		if err := v.MigratePoll(ctx, 1, "long"); err == nil {
+			t.Fatalf("MigratePoll did not return an error")
+		}
+		long.failVote = false
+
+		moved, _ := fast.Moved(ctx)
+		if moved[1] != "long" {
+			t.Fatalf("Poll is not marked as moved: %v", moved)
+		}
+
+		// Another instance finishes the migration with the next vote.
+		other, _, _ := vote.New(ctx, fast, long, ds, true, nil)
+		if err := other.Vote(ctx, 1, 3, strings.NewReader(`{"value":"Y"}`)); err != nil {
+			t.Fatalf("Vote during the migration: %v", err)
+		}
+
+		if _, _, err := fast.Stop(ctx, 1); err == nil {
+			t.Errorf("Poll is still in the fast backend")
+		}
+
+		long.AssertUserHasVoted(t, 1, 1)
+		long.AssertUserHasVoted(t, 1, 2)
+		long.AssertUserHasVoted(t, 1, 3)
+	})
+}